@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/plinkplenk/img-share/internal/api/routers"
+	"github.com/plinkplenk/img-share/internal/app"
+	"github.com/plinkplenk/img-share/internal/config"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("server: %v\n", err)
+	}
+}
+
+// run serves until it is interrupted, returning rather than exiting on
+// errors so that the deferred cleanups run.
+func run() error {
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	logger := cfg.Log.NewLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.Database.URL)
+	if err != nil {
+		return fmt.Errorf("failed to create db pool: %w", err)
+	}
+	defer pool.Close()
+	if err := pool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to connect to db: %w", err)
+	}
+
+	services, err := app.NewServices(ctx, cfg, pool, logger)
+	if err != nil {
+		return err
+	}
+	var runner *jobs.Runner
+	if cfg.Jobs.InServer {
+		runner, err = app.NewRunner(cfg, pool, services, logger.With("worker", "jobs"))
+		if err != nil {
+			return fmt.Errorf("failed to create job runner: %w", err)
+		}
+	}
+
+	r := chi.NewRouter()
+	routers.SetupAPIRouter(r, routers.Opts{
+		UsersService:         services.Users,
+		AuthService:          services.Auth,
+		ImagesService:        services.Images,
+		UploadsService:       services.Uploads,
+		SharesService:        services.Shares,
+		AlbumsService:        services.Albums,
+		VerificationService:  services.Verification,
+		PasswordResetService: services.PasswordReset,
+		EmailChangeService:   services.EmailChange,
+		TwoFactorService:     services.TwoFactor,
+		MaxUploadSize:        cfg.Images.MaxUploadSize,
+		SessionCookieName:    cfg.API.SessionCookieName,
+		RedirectParamName:    cfg.API.RedirectParamName,
+		Logger:               logger,
+	})
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+	}
+
+	runnerDone := make(chan struct{})
+	go func() {
+		defer close(runnerDone)
+		if runner != nil {
+			runner.Run(ctx)
+		}
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting server", "addr", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	var serveErr error
+	select {
+	case serveErr = <-serverErr:
+		if serveErr != nil {
+			serveErr = fmt.Errorf("server stopped unexpectedly: %w", serveErr)
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("cannot gracefully shutdown server", "error", err)
+	}
+	<-runnerDone
+	return serveErr
+}
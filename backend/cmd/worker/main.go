@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/plinkplenk/img-share/internal/app"
+	"github.com/plinkplenk/img-share/internal/config"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("worker: %v\n", err)
+	}
+}
+
+// run works off jobs until it is interrupted, returning rather than exiting
+// on errors so that the deferred cleanups run.
+func run() error {
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	logger := cfg.Log.NewLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := pgxpool.New(ctx, cfg.Database.URL)
+	if err != nil {
+		return fmt.Errorf("failed to create db pool: %w", err)
+	}
+	defer pool.Close()
+	if err := pool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to connect to db: %w", err)
+	}
+
+	services, err := app.NewServices(ctx, cfg, pool, logger)
+	if err != nil {
+		return err
+	}
+	runner, err := app.NewRunner(cfg, pool, services, logger.With("worker", "jobs"))
+	if err != nil {
+		return fmt.Errorf("failed to create job runner: %w", err)
+	}
+
+	logger.Info("starting worker", "workers", cfg.Jobs.Workers)
+	runner.Run(ctx)
+	logger.Info("worker stopped")
+	return nil
+}
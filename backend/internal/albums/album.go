@@ -0,0 +1,71 @@
+package albums
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/images"
+)
+
+var (
+	ErrAlbumNotFound      = errors.New("album not found")
+	ErrAlbumImageNotFound = errors.New("image is not in the album")
+	ErrAlbumImageExists   = errors.New("image is already in the album")
+	ErrInvalidAlbum       = errors.New("invalid album")
+)
+
+// positionGap is the distance between neighbouring images when an album is
+// appended to or renumbered. A move puts the image halfway between its new
+// neighbours, so about twenty moves into the same spot fit before the album
+// has to be renumbered.
+const positionGap int64 = 1 << 20
+
+type Album struct {
+	Id           uuid.UUID
+	OwnerId      uuid.UUID
+	Title        string
+	Description  string
+	CoverImageId uuid.NullUUID
+	Visibility   images.Visibility
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Resource lets the images service authorize access to the album by the
+// same rules as to images.
+func (a Album) Resource() images.Resource {
+	return images.Resource{OwnerId: a.OwnerId, Visibility: a.Visibility}
+}
+
+// AlbumImage places an image in an album. Images are ordered by Position,
+// which is sparse and only meaningful relative to the other positions.
+type AlbumImage struct {
+	AlbumId  uuid.UUID
+	ImageId  uuid.UUID
+	Position int64
+	AddedAt  time.Time
+}
+
+type Repository interface {
+	CreateAlbum(ctx context.Context, album Album) (Album, error)
+	GetAlbumById(ctx context.Context, id uuid.UUID) (Album, error)
+	GetAlbumsByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Album, error)
+	// GetPublicAlbums returns up to limit public albums created before the
+	// cursor, newest first.
+	GetPublicAlbums(ctx context.Context, before images.FeedCursor, limit int) ([]Album, error)
+	UpdateAlbum(ctx context.Context, album Album) (Album, error)
+	DeleteAlbum(ctx context.Context, id uuid.UUID) error
+	// AddImage appends the image at the end of the album.
+	AddImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error)
+	GetAlbumImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error)
+	// GetAlbumImages returns up to limit images positioned after
+	// afterPosition, in album order.
+	GetAlbumImages(ctx context.Context, albumId uuid.UUID, afterPosition int64, limit int) ([]AlbumImage, error)
+	// MoveImage places the image right after afterImageId, or first in the
+	// album when afterImageId is not valid. Only the moved row is written
+	// unless the neighbours are too close, then the album is renumbered.
+	MoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID, afterImageId uuid.NullUUID) (AlbumImage, error)
+	RemoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) error
+}
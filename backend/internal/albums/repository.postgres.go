@@ -0,0 +1,453 @@
+package albums
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/plinkplenk/img-share/internal/images"
+	"time"
+)
+
+const postgresRepositorySource = "albums.repo.pg"
+
+const (
+	albumColumns      = `id, owner_id, title, description, cover_image_id, visibility, created_at, updated_at`
+	albumImageColumns = `album_id, image_id, position, added_at`
+)
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgAlbum struct {
+	id           pgtype.UUID
+	ownerId      pgtype.UUID
+	title        string
+	description  string
+	coverImageId pgtype.UUID
+	visibility   string
+	createdAt    pgtype.Timestamp
+	updatedAt    pgtype.Timestamp
+}
+
+func (a *pgAlbum) scanTargets() []any {
+	return []any{
+		&a.id,
+		&a.ownerId,
+		&a.title,
+		&a.description,
+		&a.coverImageId,
+		&a.visibility,
+		&a.createdAt,
+		&a.updatedAt,
+	}
+}
+
+func fromPGAlbum(album pgAlbum) (Album, error) {
+	id, err := uuid.FromBytes(album.id.Bytes[:])
+	if err != nil {
+		return Album{}, err
+	}
+	ownerId, err := uuid.FromBytes(album.ownerId.Bytes[:])
+	if err != nil {
+		return Album{}, err
+	}
+	var coverImageId uuid.NullUUID
+	if album.coverImageId.Valid {
+		coverImageId = uuid.NullUUID{UUID: uuid.UUID(album.coverImageId.Bytes), Valid: true}
+	}
+	return Album{
+		Id:           id,
+		OwnerId:      ownerId,
+		Title:        album.title,
+		Description:  album.description,
+		CoverImageId: coverImageId,
+		Visibility:   images.Visibility(album.visibility),
+		CreatedAt:    album.createdAt.Time,
+		UpdatedAt:    album.updatedAt.Time,
+	}, nil
+}
+
+func toPGAlbum(album Album) pgAlbum {
+	return pgAlbum{
+		id:          pgtype.UUID{Bytes: [16]byte(album.Id.Bytes()), Valid: true},
+		ownerId:     pgtype.UUID{Bytes: [16]byte(album.OwnerId.Bytes()), Valid: true},
+		title:       album.Title,
+		description: album.Description,
+		coverImageId: pgtype.UUID{
+			Bytes: [16]byte(album.CoverImageId.UUID.Bytes()),
+			Valid: album.CoverImageId.Valid,
+		},
+		visibility: string(album.Visibility),
+		createdAt:  pgtype.Timestamp{Time: album.CreatedAt.UTC(), Valid: true},
+		updatedAt:  pgtype.Timestamp{Time: album.UpdatedAt.UTC(), Valid: true},
+	}
+}
+
+type pgAlbumImage struct {
+	albumId  pgtype.UUID
+	imageId  pgtype.UUID
+	position int64
+	addedAt  pgtype.Timestamp
+}
+
+func (i *pgAlbumImage) scanTargets() []any {
+	return []any{
+		&i.albumId,
+		&i.imageId,
+		&i.position,
+		&i.addedAt,
+	}
+}
+
+func fromPGAlbumImage(albumImage pgAlbumImage) AlbumImage {
+	return AlbumImage{
+		AlbumId:  uuid.UUID(albumImage.albumId.Bytes),
+		ImageId:  uuid.UUID(albumImage.imageId.Bytes),
+		Position: albumImage.position,
+		AddedAt:  albumImage.addedAt.Time,
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateAlbum(ctx context.Context, album Album) (Album, error) {
+	const op = postgresRepositorySource + ".CreateAlbum"
+	query := `
+INSERT INTO albums (` + albumColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING ` + albumColumns
+
+	toCreate := toPGAlbum(album)
+	var created pgAlbum
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		toCreate.id,
+		toCreate.ownerId,
+		toCreate.title,
+		toCreate.description,
+		toCreate.coverImageId,
+		toCreate.visibility,
+		toCreate.createdAt,
+		toCreate.updatedAt,
+	).Scan(created.scanTargets()...); err != nil {
+		return Album{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbum(created)
+}
+
+func (r *postgresRepository) GetAlbumById(ctx context.Context, id uuid.UUID) (Album, error) {
+	const op = postgresRepositorySource + ".GetAlbumById"
+	query := `SELECT ` + albumColumns + ` FROM albums WHERE id = $1`
+	var album pgAlbum
+	if err := r.db.QueryRow(ctx, query, id).Scan(album.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Album{}, ErrAlbumNotFound
+		}
+		return Album{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbum(album)
+}
+
+func (r *postgresRepository) GetAlbumsByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Album, error) {
+	const op = postgresRepositorySource + ".GetAlbumsByOwnerId"
+	query := `SELECT ` + albumColumns + ` FROM albums WHERE owner_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, ownerId)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var albums []Album
+	for rows.Next() {
+		var pgAlbum pgAlbum
+		if err := rows.Scan(pgAlbum.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		album, err := fromPGAlbum(pgAlbum)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return albums, nil
+}
+
+func (r *postgresRepository) GetPublicAlbums(
+	ctx context.Context,
+	before images.FeedCursor,
+	limit int,
+) ([]Album, error) {
+	const op = postgresRepositorySource + ".GetPublicAlbums"
+	query := `
+SELECT ` + albumColumns + ` FROM albums
+	WHERE visibility = 'public' AND ($1::timestamp IS NULL OR (created_at, id) < ($1, $2))
+	ORDER BY created_at DESC, id DESC
+	LIMIT $3`
+	createdAt := pgtype.Timestamp{Time: before.CreatedAt.UTC(), Valid: !before.CreatedAt.IsZero()}
+	rows, err := r.db.Query(ctx, query, createdAt, before.Id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var albums []Album
+	for rows.Next() {
+		var pgAlbum pgAlbum
+		if err := rows.Scan(pgAlbum.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		album, err := fromPGAlbum(pgAlbum)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		albums = append(albums, album)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return albums, nil
+}
+
+func (r *postgresRepository) UpdateAlbum(ctx context.Context, album Album) (Album, error) {
+	const op = postgresRepositorySource + ".UpdateAlbum"
+	query := `
+UPDATE albums SET title = $2, description = $3, cover_image_id = $4, visibility = $5, updated_at = $6
+	WHERE id = $1
+	RETURNING ` + albumColumns
+
+	toUpdate := toPGAlbum(album)
+	var updated pgAlbum
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		toUpdate.id,
+		toUpdate.title,
+		toUpdate.description,
+		toUpdate.coverImageId,
+		toUpdate.visibility,
+		toUpdate.updatedAt,
+	).Scan(updated.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Album{}, ErrAlbumNotFound
+		}
+		return Album{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbum(updated)
+}
+
+func (r *postgresRepository) DeleteAlbum(ctx context.Context, id uuid.UUID) error {
+	const op = postgresRepositorySource + ".DeleteAlbum"
+	query := `DELETE FROM albums WHERE id = $1`
+	if _, err := r.db.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+// lockAlbum serialises changes to the order of an album's images.
+func lockAlbum(ctx context.Context, tx pgx.Tx, albumId uuid.UUID) error {
+	query := `SELECT id FROM albums WHERE id = $1 FOR UPDATE`
+	var id pgtype.UUID
+	if err := tx.QueryRow(ctx, query, albumId).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrAlbumNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *postgresRepository) AddImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error) {
+	const op = postgresRepositorySource + ".AddImage"
+	query := `
+INSERT INTO album_images (` + albumImageColumns + `)
+	SELECT $1, $2, COALESCE(MAX(position), 0) + $3, $4 FROM album_images WHERE album_id = $1
+	ON CONFLICT (album_id, image_id) DO NOTHING
+	RETURNING ` + albumImageColumns
+
+	var added pgAlbumImage
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := lockAlbum(ctx, tx, albumId); err != nil {
+			return err
+		}
+		err := tx.QueryRow(
+			ctx,
+			query,
+			albumId,
+			imageId,
+			positionGap,
+			pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+		).Scan(added.scanTargets()...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrAlbumImageExists
+		}
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlbumNotFound) || errors.Is(err, ErrAlbumImageExists) {
+			return AlbumImage{}, err
+		}
+		return AlbumImage{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbumImage(added), nil
+}
+
+func (r *postgresRepository) GetAlbumImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error) {
+	const op = postgresRepositorySource + ".GetAlbumImage"
+	query := `SELECT ` + albumImageColumns + ` FROM album_images WHERE album_id = $1 AND image_id = $2`
+	var albumImage pgAlbumImage
+	if err := r.db.QueryRow(ctx, query, albumId, imageId).Scan(albumImage.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return AlbumImage{}, ErrAlbumImageNotFound
+		}
+		return AlbumImage{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbumImage(albumImage), nil
+}
+
+func (r *postgresRepository) GetAlbumImages(
+	ctx context.Context,
+	albumId uuid.UUID,
+	afterPosition int64,
+	limit int,
+) ([]AlbumImage, error) {
+	const op = postgresRepositorySource + ".GetAlbumImages"
+	query := `
+SELECT ` + albumImageColumns + ` FROM album_images
+	WHERE album_id = $1 AND position > $2
+	ORDER BY position
+	LIMIT $3`
+	rows, err := r.db.Query(ctx, query, albumId, afterPosition, limit)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var albumImages []AlbumImage
+	for rows.Next() {
+		var albumImage pgAlbumImage
+		if err := rows.Scan(albumImage.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		albumImages = append(albumImages, fromPGAlbumImage(albumImage))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return albumImages, nil
+}
+
+func (r *postgresRepository) MoveImage(
+	ctx context.Context,
+	albumId uuid.UUID,
+	imageId uuid.UUID,
+	afterImageId uuid.NullUUID,
+) (AlbumImage, error) {
+	const op = postgresRepositorySource + ".MoveImage"
+	positionQuery := `SELECT position FROM album_images WHERE album_id = $1 AND image_id = $2`
+	nextQuery := `
+SELECT position FROM album_images
+	WHERE album_id = $1 AND position > $2 AND image_id <> $3
+	ORDER BY position
+	LIMIT 1`
+	renumberQuery := `
+UPDATE album_images SET position = ordered.rank * $2
+	FROM (
+		SELECT image_id, row_number() OVER (ORDER BY position) AS rank
+		FROM album_images WHERE album_id = $1
+	) AS ordered
+	WHERE album_images.album_id = $1 AND album_images.image_id = ordered.image_id`
+	moveQuery := `
+UPDATE album_images SET position = $3
+	WHERE album_id = $1 AND image_id = $2
+	RETURNING ` + albumImageColumns
+
+	// neighbours returns the positions the image has to go between, next
+	// is zero when it goes last
+	neighbours := func(tx pgx.Tx) (prev int64, next int64, err error) {
+		if afterImageId.Valid {
+			if err := tx.QueryRow(ctx, positionQuery, albumId, afterImageId.UUID).Scan(&prev); err != nil {
+				return 0, 0, err
+			}
+		}
+		if err := tx.QueryRow(ctx, nextQuery, albumId, prev, imageId).Scan(&next); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return prev, 0, nil
+			}
+			return 0, 0, err
+		}
+		return prev, next, nil
+	}
+
+	var moved pgAlbumImage
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := lockAlbum(ctx, tx, albumId); err != nil {
+			return err
+		}
+		var current int64
+		if err := tx.QueryRow(ctx, positionQuery, albumId, imageId).Scan(&current); err != nil {
+			return err
+		}
+		prev, next, err := neighbours(tx)
+		if err != nil {
+			return err
+		}
+		if next != 0 && next-prev < 2 {
+			if _, err := tx.Exec(ctx, renumberQuery, albumId, positionGap); err != nil {
+				return err
+			}
+			if prev, next, err = neighbours(tx); err != nil {
+				return err
+			}
+		}
+		position := prev + positionGap
+		if next != 0 {
+			position = prev + (next-prev)/2
+		}
+		return tx.QueryRow(ctx, moveQuery, albumId, imageId, position).Scan(moved.scanTargets()...)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAlbumNotFound):
+			return AlbumImage{}, err
+		case errors.Is(err, pgx.ErrNoRows):
+			return AlbumImage{}, ErrAlbumImageNotFound
+		}
+		return AlbumImage{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGAlbumImage(moved), nil
+}
+
+func (r *postgresRepository) RemoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) error {
+	const op = postgresRepositorySource + ".RemoveImage"
+	query := `DELETE FROM album_images WHERE album_id = $1 AND image_id = $2`
+	coverQuery := `UPDATE albums SET cover_image_id = NULL WHERE id = $1 AND cover_image_id = $2`
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, albumId, imageId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrAlbumImageNotFound
+		}
+		_, err = tx.Exec(ctx, coverQuery, albumId, imageId)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAlbumImageNotFound) {
+			return err
+		}
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
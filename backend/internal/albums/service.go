@@ -0,0 +1,248 @@
+package albums
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/images"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	MaxPageSize          = 200
+)
+
+type Service interface {
+	Create(ctx context.Context, album Album) (Album, error)
+	GetAlbumById(ctx context.Context, id uuid.UUID) (Album, error)
+	GetAlbumsByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Album, error)
+	GetPublicAlbums(ctx context.Context, before images.FeedCursor, limit int) ([]Album, error)
+	Update(ctx context.Context, album Album) (Album, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	// AddImage appends an image of the album owner to the album.
+	AddImage(ctx context.Context, album Album, imageId uuid.UUID) (AlbumImage, error)
+	GetAlbumImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error)
+	GetAlbumImages(ctx context.Context, albumId uuid.UUID, afterPosition int64, limit int) ([]AlbumImage, error)
+	MoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID, afterImageId uuid.NullUUID) (AlbumImage, error)
+	RemoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) error
+}
+
+type service struct {
+	repository    Repository
+	imagesService images.Service
+	timeout       time.Duration
+	logger        *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	imagesService images.Service,
+	timeout time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		repository:    repository,
+		imagesService: imagesService,
+		timeout:       timeout,
+		logger:        logger,
+	}
+}
+
+func (s service) validate(album Album) (Album, error) {
+	album.Title = strings.TrimSpace(album.Title)
+	album.Description = strings.TrimSpace(album.Description)
+	if album.Title == "" {
+		return Album{}, fmt.Errorf("%w: title is required", ErrInvalidAlbum)
+	}
+	if utf8.RuneCountInString(album.Title) > maxTitleLength {
+		return Album{}, fmt.Errorf("%w: title is longer than %d characters", ErrInvalidAlbum, maxTitleLength)
+	}
+	if utf8.RuneCountInString(album.Description) > maxDescriptionLength {
+		return Album{}, fmt.Errorf("%w: description is longer than %d characters", ErrInvalidAlbum, maxDescriptionLength)
+	}
+	visibility, err := images.ParseVisibility(string(album.Visibility))
+	if err != nil {
+		return Album{}, fmt.Errorf("%w: %w", ErrInvalidAlbum, err)
+	}
+	album.Visibility = visibility
+	return album, nil
+}
+
+func (s service) Create(ctx context.Context, album Album) (Album, error) {
+	album, err := s.validate(album)
+	if err != nil {
+		return Album{}, err
+	}
+	if album.CoverImageId.Valid {
+		// a new album has no images to pick a cover from
+		return Album{}, fmt.Errorf("%w: cover image must be in the album", ErrInvalidAlbum)
+	}
+	album.Id = uuid.Must(uuid.NewV4())
+	album.CreatedAt = time.Now().UTC()
+	album.UpdatedAt = album.CreatedAt
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	created, err := s.repository.CreateAlbum(c, album)
+	if err != nil {
+		s.logger.Error("cannot create album", "error", err)
+		return Album{}, err
+	}
+	return created, nil
+}
+
+func (s service) GetAlbumById(ctx context.Context, id uuid.UUID) (Album, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	album, err := s.repository.GetAlbumById(c, id)
+	if err != nil && !errors.Is(err, ErrAlbumNotFound) {
+		s.logger.Error("cannot get album by id", "error", err)
+	}
+	return album, err
+}
+
+func (s service) GetAlbumsByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Album, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	albums, err := s.repository.GetAlbumsByOwnerId(c, ownerId)
+	if err != nil {
+		s.logger.Error("cannot get albums by owner id", "error", err)
+		return []Album{}, err
+	}
+	return albums, nil
+}
+
+func (s service) GetPublicAlbums(ctx context.Context, before images.FeedCursor, limit int) ([]Album, error) {
+	limit = min(max(limit, 1), images.MaxFeedSize)
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	albums, err := s.repository.GetPublicAlbums(c, before, limit)
+	if err != nil {
+		s.logger.Error("cannot get public albums", "error", err)
+		return []Album{}, err
+	}
+	return albums, nil
+}
+
+func (s service) Update(ctx context.Context, album Album) (Album, error) {
+	album, err := s.validate(album)
+	if err != nil {
+		return Album{}, err
+	}
+	if album.CoverImageId.Valid {
+		if _, err := s.GetAlbumImage(ctx, album.Id, album.CoverImageId.UUID); err != nil {
+			if errors.Is(err, ErrAlbumImageNotFound) {
+				return Album{}, fmt.Errorf("%w: cover image must be in the album", ErrInvalidAlbum)
+			}
+			return Album{}, err
+		}
+	}
+	album.UpdatedAt = time.Now().UTC()
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	updated, err := s.repository.UpdateAlbum(c, album)
+	if err != nil {
+		if !errors.Is(err, ErrAlbumNotFound) {
+			s.logger.Error("cannot update album", "error", err)
+		}
+		return Album{}, err
+	}
+	return updated, nil
+}
+
+func (s service) Delete(ctx context.Context, id uuid.UUID) error {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.DeleteAlbum(c, id); err != nil {
+		s.logger.Error("cannot delete album", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) AddImage(ctx context.Context, album Album, imageId uuid.UUID) (AlbumImage, error) {
+	image, err := s.imagesService.GetImageById(ctx, imageId)
+	if err != nil {
+		return AlbumImage{}, err
+	}
+	// only images the album owner could manage themselves can be added
+	ownerId := uuid.NullUUID{UUID: album.OwnerId, Valid: true}
+	if err := s.imagesService.Authorize(ownerId, image.Resource(), images.ActionManage); err != nil {
+		return AlbumImage{}, images.ErrImageNotFound
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	added, err := s.repository.AddImage(c, album.Id, image.Id)
+	if err != nil {
+		if !errors.Is(err, ErrAlbumNotFound) && !errors.Is(err, ErrAlbumImageExists) {
+			s.logger.Error("cannot add image to album", "error", err)
+		}
+		return AlbumImage{}, err
+	}
+	return added, nil
+}
+
+func (s service) GetAlbumImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) (AlbumImage, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	albumImage, err := s.repository.GetAlbumImage(c, albumId, imageId)
+	if err != nil && !errors.Is(err, ErrAlbumImageNotFound) {
+		s.logger.Error("cannot get album image", "error", err)
+	}
+	return albumImage, err
+}
+
+func (s service) GetAlbumImages(
+	ctx context.Context,
+	albumId uuid.UUID,
+	afterPosition int64,
+	limit int,
+) ([]AlbumImage, error) {
+	limit = min(max(limit, 1), MaxPageSize)
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	albumImages, err := s.repository.GetAlbumImages(c, albumId, afterPosition, limit)
+	if err != nil {
+		s.logger.Error("cannot get album images", "error", err)
+		return []AlbumImage{}, err
+	}
+	return albumImages, nil
+}
+
+func (s service) MoveImage(
+	ctx context.Context,
+	albumId uuid.UUID,
+	imageId uuid.UUID,
+	afterImageId uuid.NullUUID,
+) (AlbumImage, error) {
+	if afterImageId.Valid && afterImageId.UUID == imageId {
+		return AlbumImage{}, fmt.Errorf("%w: an image cannot be moved after itself", ErrInvalidAlbum)
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	moved, err := s.repository.MoveImage(c, albumId, imageId, afterImageId)
+	if err != nil {
+		if !errors.Is(err, ErrAlbumNotFound) && !errors.Is(err, ErrAlbumImageNotFound) {
+			s.logger.Error("cannot move album image", "error", err)
+		}
+		return AlbumImage{}, err
+	}
+	return moved, nil
+}
+
+func (s service) RemoveImage(ctx context.Context, albumId uuid.UUID, imageId uuid.UUID) error {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.RemoveImage(c, albumId, imageId); err != nil {
+		if !errors.Is(err, ErrAlbumImageNotFound) {
+			s.logger.Error("cannot remove album image", "error", err)
+		}
+		return err
+	}
+	return nil
+}
@@ -1,7 +1,8 @@
 package api
 
+// Defaults for the names configured through config.API.
 const (
-	SessionIdCookieName = "session-id"
+	DefaultSessionIdCookieName = "session-id"
 
-	RedirectUrlParamName = "redirect-url"
+	DefaultRedirectUrlParamName = "redirect-url"
 )
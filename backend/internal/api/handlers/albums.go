@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/albums"
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/images"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	albumIdURLParamName      = "id"
+	albumImageIdURLParamName = "imageId"
+	afterQueryParamName      = "after"
+	limitQueryParamName      = "limit"
+	defaultAlbumPageSize     = 50
+)
+
+type albumRequest struct {
+	Title        string            `json:"title"`
+	Description  string            `json:"description"`
+	CoverImageId uuid.NullUUID     `json:"cover_image_id"`
+	Visibility   images.Visibility `json:"visibility"`
+}
+
+type albumImageAdd struct {
+	ImageId uuid.UUID `json:"image_id"`
+}
+
+type albumImageMove struct {
+	// AfterImageId is null to move the image to the front of the album.
+	AfterImageId uuid.NullUUID `json:"after_image_id"`
+}
+
+type albumResponse struct {
+	Id           uuid.UUID         `json:"id"`
+	OwnerId      uuid.UUID         `json:"owner_id"`
+	Title        string            `json:"title"`
+	Description  string            `json:"description"`
+	CoverImageId *uuid.UUID        `json:"cover_image_id,omitempty"`
+	Visibility   images.Visibility `json:"visibility"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+type albumFeedResponse struct {
+	Albums []albumResponse `json:"albums"`
+	// Next is the before parameter of the next page, absent on the last.
+	Next string `json:"next,omitempty"`
+}
+
+func newAlbumResponse(album albums.Album) albumResponse {
+	response := albumResponse{
+		Id:          album.Id,
+		OwnerId:     album.OwnerId,
+		Title:       album.Title,
+		Description: album.Description,
+		Visibility:  album.Visibility,
+		CreatedAt:   album.CreatedAt,
+		UpdatedAt:   album.UpdatedAt,
+	}
+	if album.CoverImageId.Valid {
+		response.CoverImageId = &album.CoverImageId.UUID
+	}
+	return response
+}
+
+type albumImageResponse struct {
+	imageResponse
+	Position int64 `json:"position"`
+}
+
+type albumImagesResponse struct {
+	Images []albumImageResponse `json:"images"`
+	// NextAfter is the after parameter of the next page, absent on the last.
+	NextAfter *int64 `json:"next_after,omitempty"`
+}
+
+// pageParams reads the keyset pagination parameters of album listings.
+func pageParams(r *http.Request) (int64, int, error) {
+	var after int64
+	limit := defaultAlbumPageSize
+	query := r.URL.Query()
+	if raw := query.Get(afterQueryParamName); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value < 0 {
+			return 0, 0, errors.New("after must be a non negative integer")
+		}
+		after = value
+	}
+	if raw := query.Get(limitQueryParamName); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > albums.MaxPageSize {
+			return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(albums.MaxPageSize))
+		}
+		limit = value
+	}
+	return after, limit, nil
+}
+
+// albumImagesPage loads a page of the album with the images it refers to.
+// Images visible reports false for are left out, a nil visible keeps all of
+// them. link turns an image response into the URLs it should be served under.
+func albumImagesPage(
+	ctx context.Context,
+	albumsService albums.Service,
+	imagesService images.Service,
+	albumId uuid.UUID,
+	after int64,
+	limit int,
+	visible func(images.Image) bool,
+	link func(*imageResponse),
+) (albumImagesResponse, error) {
+	albumImages, err := albumsService.GetAlbumImages(ctx, albumId, after, limit)
+	if err != nil {
+		return albumImagesResponse{}, err
+	}
+	ids := make([]uuid.UUID, len(albumImages))
+	for i, albumImage := range albumImages {
+		ids[i] = albumImage.ImageId
+	}
+	imgs, err := imagesService.GetImagesByIds(ctx, ids)
+	if err != nil {
+		return albumImagesResponse{}, err
+	}
+	// images deleted since the page was read are skipped
+	ordered := make([]images.Image, 0, len(albumImages))
+	positions := make([]int64, 0, len(albumImages))
+	for _, albumImage := range albumImages {
+		if image, ok := imgs[albumImage.ImageId]; ok && (visible == nil || visible(image)) {
+			ordered = append(ordered, image)
+			positions = append(positions, albumImage.Position)
+		}
+	}
+	responses, err := imageResponses(ctx, imagesService, ordered...)
+	if err != nil {
+		return albumImagesResponse{}, err
+	}
+	page := albumImagesResponse{Images: make([]albumImageResponse, len(responses))}
+	for i := range responses {
+		if link != nil {
+			link(&responses[i])
+		}
+		page.Images[i] = albumImageResponse{imageResponse: responses[i], Position: positions[i]}
+	}
+	if len(albumImages) == limit {
+		next := albumImages[len(albumImages)-1].Position
+		page.NextAfter = &next
+	}
+	return page, nil
+}
+
+type AlbumsHandler struct {
+	albumsService albums.Service
+	imagesService images.Service
+	logger        *slog.Logger
+}
+
+func NewAlbumsHandler(
+	albumsService albums.Service,
+	imagesService images.Service,
+	logger *slog.Logger,
+) AlbumsHandler {
+	return AlbumsHandler{
+		albumsService: albumsService,
+		imagesService: imagesService,
+		logger:        logger,
+	}
+}
+
+func (h AlbumsHandler) writeAlbumError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, albums.ErrInvalidAlbum):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+	case errors.Is(err, albums.ErrAlbumNotFound), errors.Is(err, albums.ErrAlbumImageNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, albums.ErrAlbumImageExists):
+		writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: "image is already in the album"})
+	case errors.Is(err, images.ErrImageNotFound):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "image not found"})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h AlbumsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	request, err := JSONFromReaderTo[albumRequest](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	album, err := h.albumsService.Create(r.Context(), albums.Album{
+		OwnerId:      user.Id,
+		Title:        request.Title,
+		Description:  request.Description,
+		CoverImageId: request.CoverImageId,
+		Visibility:   request.Visibility,
+	})
+	if err != nil {
+		h.writeAlbumError(w, err)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusCreated, newAlbumResponse(album))
+}
+
+func (h AlbumsHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	userAlbums, err := h.albumsService.GetAlbumsByOwnerId(r.Context(), user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := make([]albumResponse, len(userAlbums))
+	for i, album := range userAlbums {
+		response[i] = newAlbumResponse(album)
+	}
+	writeJSON(w, h.logger, http.StatusOK, response)
+}
+
+// Public lists the public albums of all users, newest first.
+func (h AlbumsHandler) Public(w http.ResponseWriter, r *http.Request) {
+	before, limit, err := feedParams(r)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	publicAlbums, err := h.albumsService.GetPublicAlbums(r.Context(), before, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	feed := albumFeedResponse{Albums: make([]albumResponse, len(publicAlbums))}
+	for i, album := range publicAlbums {
+		feed.Albums[i] = newAlbumResponse(album)
+	}
+	if len(publicAlbums) == limit {
+		last := publicAlbums[len(publicAlbums)-1]
+		feed.Next = feedCursor(last.CreatedAt, last.Id)
+	}
+	writeJSON(w, h.logger, http.StatusOK, feed)
+}
+
+// authorizedAlbum resolves the album from the URL and makes sure the session
+// user, if any, may perform action on it. It writes the error response
+// itself and reports false if the request should not continue. Albums the
+// user may not access are reported as not found.
+func (h AlbumsHandler) authorizedAlbum(
+	w http.ResponseWriter,
+	r *http.Request,
+	action images.Action,
+) (albums.Album, uuid.NullUUID, bool) {
+	userId := auth.UserIdFromContext(r.Context())
+	if action == images.ActionManage && !userId.Valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		return albums.Album{}, userId, false
+	}
+	id, err := uuid.FromString(chi.URLParam(r, albumIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return albums.Album{}, userId, false
+	}
+	album, err := h.albumsService.GetAlbumById(r.Context(), id)
+	if err != nil {
+		h.writeAlbumError(w, err)
+		return albums.Album{}, userId, false
+	}
+	if err := h.imagesService.Authorize(userId, album.Resource(), action); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return albums.Album{}, userId, false
+	}
+	return album, userId, true
+}
+
+func (h AlbumsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, newAlbumResponse(album))
+}
+
+func (h AlbumsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	request, err := JSONFromReaderTo[albumRequest](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	album.Title = request.Title
+	album.Description = request.Description
+	album.CoverImageId = request.CoverImageId
+	album.Visibility = request.Visibility
+	album, err = h.albumsService.Update(r.Context(), album)
+	if err != nil {
+		h.writeAlbumError(w, err)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, newAlbumResponse(album))
+}
+
+func (h AlbumsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	if err := h.albumsService.Delete(r.Context(), album.Id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Images lists the images of the album in album order, a page at a time.
+// Users other than the owner only see the images they may view themselves.
+func (h AlbumsHandler) Images(w http.ResponseWriter, r *http.Request) {
+	album, userId, ok := h.authorizedAlbum(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	after, limit, err := pageParams(r)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	visible := func(image images.Image) bool {
+		return h.imagesService.Authorize(userId, image.Resource(), images.ActionView) == nil
+	}
+	page, err := albumImagesPage(r.Context(), h.albumsService, h.imagesService, album.Id, after, limit, visible, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, page)
+}
+
+func (h AlbumsHandler) AddImage(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	request, err := JSONFromReaderTo[albumImageAdd](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	if _, err := h.albumsService.AddImage(r.Context(), album, request.ImageId); err != nil {
+		h.writeAlbumError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// MoveImage places an image of the album right after another one, or first
+// when after_image_id is null.
+func (h AlbumsHandler) MoveImage(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	imageId, err := uuid.FromString(chi.URLParam(r, albumImageIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	request, err := JSONFromReaderTo[albumImageMove](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	if _, err := h.albumsService.MoveImage(r.Context(), album.Id, imageId, request.AfterImageId); err != nil {
+		h.writeAlbumError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h AlbumsHandler) RemoveImage(w http.ResponseWriter, r *http.Request) {
+	album, _, ok := h.authorizedAlbum(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	imageId, err := uuid.FromString(chi.URLParam(r, albumImageIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := h.albumsService.RemoveImage(r.Context(), album.Id, imageId); err != nil {
+		h.writeAlbumError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/gofrs/uuid/v5"
-	"github.com/plinkplenk/img-share/internal/api"
 	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/emailchange"
+	"github.com/plinkplenk/img-share/internal/passwordreset"
+	"github.com/plinkplenk/img-share/internal/twofactor"
 	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/internal/verification"
 	"github.com/plinkplenk/img-share/pkg/cookies"
 	"github.com/plinkplenk/img-share/pkg/password"
 	"log/slog"
@@ -24,6 +26,23 @@ type userResponse struct {
 type userLogin struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Bearer asks for the session id in the response instead of a cookie,
+	// for clients sending it in the Authorization header.
+	Bearer bool `json:"bearer"`
+}
+
+type bearerTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresOn time.Time `json:"expires_on"`
+}
+
+func newUserResponse(user users.User) userResponse {
+	return userResponse{
+		Id:        user.Id,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+		IsActive:  user.IsActive,
+	}
 }
 
 type userRegister struct {
@@ -32,53 +51,45 @@ type userRegister struct {
 }
 
 type AuthHandler struct {
-	authService  auth.Service
-	usersService users.Service
-	logger       *slog.Logger
+	authService          auth.Service
+	usersService         users.Service
+	verificationService  verification.Service
+	passwordResetService passwordreset.Service
+	emailChangeService   emailchange.Service
+	twoFactorService     twofactor.Service
+	sessionCookieName    string
+	logger               *slog.Logger
 }
 
-func NewAuthHandler(authService auth.Service, usersService users.Service, logger *slog.Logger) AuthHandler {
+func NewAuthHandler(
+	authService auth.Service,
+	usersService users.Service,
+	verificationService verification.Service,
+	passwordResetService passwordreset.Service,
+	emailChangeService emailchange.Service,
+	twoFactorService twofactor.Service,
+	sessionCookieName string,
+	logger *slog.Logger,
+) AuthHandler {
 	return AuthHandler{
-		authService:  authService,
-		usersService: usersService,
-		logger:       logger,
+		authService:          authService,
+		usersService:         usersService,
+		verificationService:  verificationService,
+		passwordResetService: passwordResetService,
+		emailChangeService:   emailChangeService,
+		twoFactorService:     twoFactorService,
+		sessionCookieName:    sessionCookieName,
+		logger:               logger,
 	}
 }
 
 func (h AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
 	ctx := r.Context()
-	reader, err := r.GetBody()
+	userToCreate, err := JSONFromReaderTo[userRegister](r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			h.logger.Error("cannot close reader", "error", err)
-		}
-	}()
-	userToCreate, err := JSONFromReaderTo[userRegister](reader)
-	if err != nil {
-		h.logger.Error("cannot unmarshal json", "error", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	_, err = h.usersService.GetUserByEmail(ctx, userToCreate.Email)
-	if err != nil && !errors.Is(err, users.ErrUserNotFound) {
-		w.WriteHeader(http.StatusBadRequest)
-		response, err := json.Marshal(BadRequest{Message: "user already exists"})
-		if err != nil {
-			h.logger.Error("cannot marshal json", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if _, err := w.Write(response); err != nil {
-			h.logger.Error("cannot write response", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		return
-	}
 	createdUser, err := h.usersService.CreateUser(
 		ctx,
 		users.User{
@@ -87,46 +98,27 @@ func (h AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		response, err := json.Marshal(BadRequest{Message: "cannot create user"})
-		if err != nil {
-			h.logger.Error("cannot marshal json", "error", err)
-			return
+		switch {
+		case errors.Is(err, users.ErrUserExists):
+			writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: "user already exists"})
+		case errors.Is(err, users.ErrInvalidEmail), errors.Is(err, users.ErrPasswordTooShort):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		default:
+			writeJSON(w, h.logger, http.StatusInternalServerError, BadRequest{Message: "cannot create user"})
 		}
-		if _, err := w.Write(response); err != nil {
-			h.logger.Error("cannot write response", "error", err)
-		}
-		return
-	}
-	response, err := json.Marshal(userResponse{
-		Id:        createdUser.Id,
-		Email:     createdUser.Email,
-		CreatedAt: createdUser.CreatedAt,
-		IsActive:  createdUser.IsActive,
-	})
-	if err != nil {
-		h.logger.Error("cannot marshal json", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if _, err := w.Write(response); err != nil {
-		h.logger.Error("cannot write response", "error", err)
-	}
+	// the account exists either way, the user can ask for another email
+	_ = h.verificationService.Send(ctx, createdUser, requestLocale(r))
+	writeJSON(w, h.logger, http.StatusCreated, newUserResponse(createdUser))
 }
 
+// Login signs the user in with their email and password. Users with
+// two-factor authentication get 202 and a challenge to complete at
+// /2fa/verify instead of a session.
 func (h AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	reader, err := r.GetBody()
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			h.logger.Error("cannot close reader", "error", err)
-		}
-	}()
-	userData, err := JSONFromReaderTo[userLogin](reader)
+	userData, err := JSONFromReaderTo[userLogin](r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -144,38 +136,54 @@ func (h AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	session, err := h.authService.CreateSession(ctx, dbUser.Id)
+	enabled, err := h.twoFactorService.Enabled(ctx, dbUser.Id)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	cookie := http.Cookie{
-		Name:     api.SessionIdCookieName,
-		Value:    session.Id,
-		Path:     "/",
-		Expires:  session.ExpiresOn.UTC(),
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
+	if enabled {
+		// the password alone does not sign in, the client completes the
+		// challenge with a code at /2fa/verify
+		challenge, expiresOn, err := h.twoFactorService.Challenge(ctx, dbUser)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, h.logger, http.StatusAccepted, twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+			ExpiresOn:         expiresOn,
+		})
+		return
 	}
-	http.SetCookie(w, &cookie)
-	w.WriteHeader(http.StatusOK)
+	h.startSession(w, r, dbUser, userData.Bearer)
 }
 
-func (h AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	sessionCookie, err := r.Cookie(api.SessionIdCookieName)
+// startSession signs user in, answering with the session id in a cookie or
+// in the body for bearer clients.
+func (h AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user users.User, bearer bool) {
+	session, err := h.authService.CreateSession(r.Context(), user.Id, auth.DeviceFromRequest(r))
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if err := sessionCookie.Valid(); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	if bearer {
+		writeJSON(w, h.logger, http.StatusOK, bearerTokenResponse{Token: session.Id, ExpiresOn: session.ExpiresOn})
+		return
+	}
+	cookies.Set(h.sessionCookieName, session.Id, session.ExpiresOn, w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	session, ok := currentSession(w, r)
+	if !ok {
 		return
 	}
-	if err := h.authService.DeleteSessionById(ctx, sessionCookie.Value); err != nil {
+	if err := h.authService.DeleteSessionById(r.Context(), session.Id); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	cookies.Delete(sessionCookie.Name, w)
+	cookies.Delete(h.sessionCookieName, w)
 	w.WriteHeader(http.StatusOK)
 }
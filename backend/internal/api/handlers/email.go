@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/plinkplenk/img-share/internal/emailchange"
+	"github.com/plinkplenk/img-share/internal/users"
+	"net/http"
+)
+
+const emailChangeTokenQueryParamName = "token"
+
+type changeEmail struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ChangeEmail mails a confirmation link to the new address, the address of
+// the account changes once it is followed.
+func (h AuthHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	body, err := JSONFromReaderTo[changeEmail](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := h.emailChangeService.Request(r.Context(), user, body.Email, body.Password, requestLocale(r)); err != nil {
+		switch {
+		case errors.Is(err, emailchange.ErrInvalidPassword):
+			writeJSON(w, h.logger, http.StatusForbidden, BadRequest{Message: err.Error()})
+		case errors.Is(err, users.ErrInvalidEmail), errors.Is(err, emailchange.ErrSameEmail):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		case errors.Is(err, emailchange.ErrEmailTaken):
+			writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: err.Error()})
+		case errors.Is(err, emailchange.ErrThrottled):
+			writeJSON(w, h.logger, http.StatusTooManyRequests, BadRequest{Message: err.Error()})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmEmailChange moves the account to the address of the emailed
+// confirmation link.
+func (h AuthHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get(emailChangeTokenQueryParamName)
+	if token == "" {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: emailchange.ErrInvalidToken.Error()})
+		return
+	}
+	user, err := h.emailChangeService.Confirm(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, emailchange.ErrInvalidToken):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		case errors.Is(err, emailchange.ErrEmailTaken):
+			writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: err.Error()})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, newUserResponse(user))
+}
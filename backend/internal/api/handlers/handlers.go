@@ -3,11 +3,23 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"github.com/plinkplenk/img-share/internal/api"
+	"github.com/gofrs/uuid/v5"
 	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/images"
 	"github.com/plinkplenk/img-share/internal/users"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	beforeQueryParamName = "before"
+	defaultFeedSize      = 30
+	// maxJSONBodySize bounds the JSON request bodies, which are read whole.
+	maxJSONBodySize = 1 << 20
 )
 
 var (
@@ -18,11 +30,20 @@ type BadRequest struct {
 	Message string `json:"message"`
 }
 
+// JSONFromReaderTo decodes the JSON read from reader, usually a request
+// body, and closes it. Bodies over maxJSONBodySize are refused.
 func JSONFromReaderTo[T any](reader io.ReadCloser) (T, error) {
-	bytes, err := io.ReadAll(reader)
+	if reader == nil {
+		return *new(T), errors.New("empty body")
+	}
+	defer reader.Close()
+	bytes, err := io.ReadAll(io.LimitReader(reader, maxJSONBodySize+1))
 	if err != nil {
 		return *new(T), err
 	}
+	if len(bytes) > maxJSONBodySize {
+		return *new(T), errors.New("body is too large")
+	}
 	return JSONFromBytesTo[T](bytes)
 }
 
@@ -34,14 +55,81 @@ func JSONFromBytesTo[T any](data []byte) (T, error) {
 	return target, nil
 }
 
-func GetUserFromSession(authService auth.Service, r *http.Request) (users.User, error) {
-	sessionId, err := r.Cookie(api.SessionIdCookieName)
+func writeJSON(w http.ResponseWriter, logger *slog.Logger, code int, value any) {
+	response, err := json.Marshal(value)
 	if err != nil {
-		return users.User{}, ErrUnauthorized
+		logger.Error("cannot marshal json", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	user, err := authService.GetUserBySessionId(r.Context(), sessionId.Value)
-	if err != nil {
-		return users.User{}, err
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(response); err != nil {
+		logger.Error("cannot write response", "error", err)
+	}
+}
+
+// sessionUser returns the user authenticated by the auth middlewares. It
+// answers 401 when there is none, so that a route registered without
+// RequireAuth fails closed.
+func sessionUser(w http.ResponseWriter, r *http.Request) (users.User, bool) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return users.User{}, false
+	}
+	return user, true
+}
+
+// feedParams reads the cursor and page size of public feeds. The cursor is
+// the next value of a previous page, absent for the first one.
+func feedParams(r *http.Request) (images.FeedCursor, int, error) {
+	var cursor images.FeedCursor
+	limit := defaultFeedSize
+	query := r.URL.Query()
+	if raw := query.Get(beforeQueryParamName); raw != "" {
+		nanos, id, found := strings.Cut(raw, "_")
+		createdAt, err := strconv.ParseInt(nanos, 10, 64)
+		if !found || err != nil {
+			return images.FeedCursor{}, 0, errors.New("invalid before cursor")
+		}
+		if cursor.Id, err = uuid.FromString(id); err != nil {
+			return images.FeedCursor{}, 0, errors.New("invalid before cursor")
+		}
+		cursor.CreatedAt = time.Unix(0, createdAt).UTC()
+	}
+	if raw := query.Get(limitQueryParamName); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > images.MaxFeedSize {
+			return images.FeedCursor{}, 0, errors.New("limit must be between 1 and " + strconv.Itoa(images.MaxFeedSize))
+		}
+		limit = value
+	}
+	return cursor, limit, nil
+}
+
+func feedCursor(createdAt time.Time, id uuid.UUID) string {
+	return strconv.FormatInt(createdAt.UnixNano(), 10) + "_" + id.String()
+}
+
+// requestLocale returns the language the client prefers the most according
+// to Accept-Language, empty when it has no preference.
+func requestLocale(r *http.Request) string {
+	locale, best := "", 0.0
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if tag == "" || tag == "*" || quality <= best {
+			continue
+		}
+		locale, best = tag, quality
 	}
-	return user, nil
+	return locale
 }
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/images"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	imageFormField             = "image"
+	imageIdURLParamName        = "id"
+	derivativeNameURLParamName = "name"
+	thumbnailDerivativeName    = "thumb"
+	keepMetadataQueryParamName = "keep_metadata"
+	visibilityQueryParamName   = "visibility"
+	ttlQueryParamName          = "ttl"
+	burnQueryParamName         = "burn_after_reading"
+	imagesPathPrefix           = "/api/images"
+)
+
+var (
+	errInvalidMultipart = errors.New("invalid multipart form")
+	errMissingImagePart = errors.New("missing image part")
+)
+
+type derivativeResponse struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type metadataResponse struct {
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	Orientation int        `json:"orientation"`
+	Stripped    bool       `json:"stripped"`
+}
+
+type imageResponse struct {
+	Id               uuid.UUID            `json:"id"`
+	OwnerId          uuid.UUID            `json:"owner_id"`
+	ContentType      string               `json:"content_type"`
+	Size             int64                `json:"size"`
+	Width            int                  `json:"width"`
+	Height           int                  `json:"height"`
+	OriginalName     string               `json:"original_name"`
+	CreatedAt        time.Time            `json:"created_at"`
+	Metadata         metadataResponse     `json:"metadata"`
+	Visibility       images.Visibility    `json:"visibility"`
+	ExpiresAt        *time.Time           `json:"expires_at,omitempty"`
+	BurnAfterReading bool                 `json:"burn_after_reading"`
+	URL              string               `json:"url"`
+	ThumbnailURL     string               `json:"thumbnail_url,omitempty"`
+	Derivatives      []derivativeResponse `json:"derivatives"`
+}
+
+func newImageResponse(image images.Image, derivatives []images.Derivative) imageResponse {
+	response := imageResponse{
+		Id:           image.Id,
+		OwnerId:      image.OwnerId,
+		ContentType:  image.ContentType,
+		Size:         image.Size,
+		Width:        image.Width,
+		Height:       image.Height,
+		OriginalName: image.OriginalName,
+		CreatedAt:    image.CreatedAt,
+		Metadata: metadataResponse{
+			CameraMake:  image.Metadata.CameraMake,
+			CameraModel: image.Metadata.CameraModel,
+			Orientation: image.Metadata.Orientation,
+			Stripped:    image.Metadata.Stripped,
+		},
+		Visibility:       image.Visibility,
+		BurnAfterReading: image.BurnAfterReading,
+		URL:              fmt.Sprintf("%s/%s/file", imagesPathPrefix, image.Id),
+		Derivatives:      make([]derivativeResponse, 0, len(derivatives)),
+	}
+	if !image.Metadata.TakenAt.IsZero() {
+		response.Metadata.TakenAt = &image.Metadata.TakenAt
+	}
+	if !image.ExpiresAt.IsZero() {
+		response.ExpiresAt = &image.ExpiresAt
+	}
+	for _, derivative := range derivatives {
+		url := fmt.Sprintf("%s/%s/derivatives/%s", imagesPathPrefix, image.Id, derivative.Name)
+		if derivative.Name == thumbnailDerivativeName {
+			response.ThumbnailURL = url
+		}
+		response.Derivatives = append(response.Derivatives, derivativeResponse{
+			Name:   derivative.Name,
+			URL:    url,
+			Width:  derivative.Width,
+			Height: derivative.Height,
+		})
+	}
+	return response
+}
+
+// imageResponses loads the derivatives of all imgs in one query.
+func imageResponses(ctx context.Context, imagesService images.Service, imgs ...images.Image) ([]imageResponse, error) {
+	ids := make([]uuid.UUID, len(imgs))
+	for i, image := range imgs {
+		ids[i] = image.Id
+	}
+	derivatives, err := imagesService.GetDerivatives(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	response := make([]imageResponse, len(imgs))
+	for i, image := range imgs {
+		response[i] = newImageResponse(image, derivatives[image.Id])
+	}
+	return response, nil
+}
+
+type imageUpdate struct {
+	Visibility images.Visibility `json:"visibility"`
+}
+
+type imageFeedResponse struct {
+	Images []imageResponse `json:"images"`
+	// Next is the before parameter of the next page, absent on the last.
+	Next string `json:"next,omitempty"`
+}
+
+func (h ImagesHandler) writeImage(w http.ResponseWriter, r *http.Request, code int, image images.Image) {
+	response, err := imageResponses(r.Context(), h.imagesService, image)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, code, response[0])
+}
+
+type ImagesHandler struct {
+	imagesService images.Service
+	maxUploadSize int64
+	logger        *slog.Logger
+}
+
+func NewImagesHandler(
+	imagesService images.Service,
+	maxUploadSize int64,
+	logger *slog.Logger,
+) ImagesHandler {
+	return ImagesHandler{
+		imagesService: imagesService,
+		maxUploadSize: maxUploadSize,
+		logger:        logger,
+	}
+}
+
+func (h ImagesHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	visibility, err := images.ParseVisibility(query.Get(visibilityQueryParamName))
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if raw := query.Get(ttlQueryParamName); raw != "" {
+		if ttl, err = time.ParseDuration(raw); err != nil {
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "ttl must be a duration such as 90m or 24h"})
+			return
+		}
+	}
+	burn, _ := strconv.ParseBool(query.Get(burnQueryParamName))
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	part, err := imagePart(r)
+	if err != nil {
+		h.writeUploadError(w, err)
+		return
+	}
+	defer func() {
+		if err := part.Close(); err != nil {
+			h.logger.Error("cannot close multipart part", "error", err)
+		}
+	}()
+	keepMetadata, _ := strconv.ParseBool(query.Get(keepMetadataQueryParamName))
+	image, err := h.imagesService.Upload(ctx, user.Id, part.FileName(), part, images.UploadOptions{
+		KeepMetadata:     keepMetadata,
+		Visibility:       visibility,
+		TTL:              ttl,
+		BurnAfterReading: burn,
+	})
+	if err != nil {
+		h.writeUploadError(w, err)
+		return
+	}
+	h.writeImage(w, r, http.StatusCreated, image)
+}
+
+// imagePart skips to the image field of a multipart body without buffering
+// the parts in memory or on disk.
+func imagePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errInvalidMultipart
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errMissingImagePart
+			}
+			return nil, err
+		}
+		if part.FormName() == imageFormField {
+			return part, nil
+		}
+		if err := part.Close(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (h ImagesHandler) writeUploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeJSON(w, h.logger, http.StatusRequestEntityTooLarge, BadRequest{Message: "image is too large"})
+	case errors.Is(err, errMissingImagePart):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "image field is required"})
+	case errors.Is(err, errInvalidMultipart):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid multipart form"})
+	case errors.Is(err, images.ErrUnsupportedFormat):
+		writeJSON(w, h.logger, http.StatusUnsupportedMediaType, BadRequest{Message: "only jpeg, png, gif and webp images are supported"})
+	case errors.Is(err, images.ErrInvalidTTL), errors.Is(err, images.ErrInvalidVisibility):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+	case errors.Is(err, images.ErrInvalidImageUpload):
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "cannot read uploaded image"})
+	default:
+		h.logger.Error("cannot upload image", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h ImagesHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	imgs, err := h.imagesService.GetImagesByOwnerId(r.Context(), user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response, err := imageResponses(r.Context(), h.imagesService, imgs...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, response)
+}
+
+// Public lists the public images of all users, newest first.
+func (h ImagesHandler) Public(w http.ResponseWriter, r *http.Request) {
+	before, limit, err := feedParams(r)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	imgs, err := h.imagesService.GetPublicImages(r.Context(), before, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response, err := imageResponses(r.Context(), h.imagesService, imgs...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	feed := imageFeedResponse{Images: response}
+	if len(imgs) == limit {
+		last := imgs[len(imgs)-1]
+		feed.Next = feedCursor(last.CreatedAt, last.Id)
+	}
+	writeJSON(w, h.logger, http.StatusOK, feed)
+}
+
+// authorizedImage resolves the image from the URL and makes sure the session
+// user, if any, may perform action on it. It writes the error response
+// itself and reports false if the request should not continue. Images the
+// user may not access are reported as not found.
+func (h ImagesHandler) authorizedImage(
+	w http.ResponseWriter,
+	r *http.Request,
+	action images.Action,
+) (images.Image, uuid.NullUUID, bool) {
+	userId := auth.UserIdFromContext(r.Context())
+	if action == images.ActionManage && !userId.Valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		return images.Image{}, userId, false
+	}
+	id, err := uuid.FromString(chi.URLParam(r, imageIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return images.Image{}, userId, false
+	}
+	image, err := h.imagesService.GetImageById(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return images.Image{}, userId, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return images.Image{}, userId, false
+	}
+	if err := h.imagesService.Authorize(userId, image.Resource(), action); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return images.Image{}, userId, false
+	}
+	return image, userId, true
+}
+
+func (h ImagesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	image, _, ok := h.authorizedImage(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	h.writeImage(w, r, http.StatusOK, image)
+}
+
+// Update changes the visibility of the image.
+func (h ImagesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	image, _, ok := h.authorizedImage(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	request, err := JSONFromReaderTo[imageUpdate](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	image, err = h.imagesService.SetVisibility(r.Context(), image.Id, request.Visibility)
+	if err != nil {
+		switch {
+		case errors.Is(err, images.ErrInvalidVisibility):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		case errors.Is(err, images.ErrImageNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	h.writeImage(w, r, http.StatusOK, image)
+}
+
+func (h ImagesHandler) File(w http.ResponseWriter, r *http.Request) {
+	image, userId, ok := h.authorizedImage(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	serveImage(w, r, h.imagesService, h.logger, userId, image)
+}
+
+func (h ImagesHandler) Derivative(w http.ResponseWriter, r *http.Request) {
+	image, _, ok := h.authorizedImage(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	derivative, err := h.imagesService.GetDerivative(r.Context(), image.Id, chi.URLParam(r, derivativeNameURLParamName))
+	if err != nil {
+		if errors.Is(err, images.ErrDerivativeNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	serveObject(w, r, h.imagesService, h.logger, derivative.StorageKey, derivative.ContentType, derivative.Size, func() (io.ReadCloser, error) {
+		return h.imagesService.OpenDerivative(r.Context(), derivative)
+	})
+}
+
+// serveImage serves the original of image to viewerId. An image burned by
+// this view is streamed through the API, a presigned URL could be fetched
+// again, and deleted right after; the reaper purges it should that fail.
+func serveImage(
+	w http.ResponseWriter,
+	r *http.Request,
+	imagesService images.Service,
+	logger *slog.Logger,
+	viewerId uuid.NullUUID,
+	image images.Image,
+) {
+	image, err := imagesService.ConsumeView(r.Context(), viewerId, image)
+	if err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	open := func() (io.ReadCloser, error) {
+		return imagesService.Open(r.Context(), image)
+	}
+	if image.BurnedAt.IsZero() {
+		serveObject(w, r, imagesService, logger, image.StorageKey, image.ContentType, image.Size, open)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	streamObject(w, logger, image.ContentType, image.Size, open)
+	_ = imagesService.DeleteImage(context.WithoutCancel(r.Context()), image.Id)
+}
+
+// serveObject redirects to a presigned storage URL when the storage supports
+// it and streams the object opened by open through the API otherwise.
+func serveObject(
+	w http.ResponseWriter,
+	r *http.Request,
+	imagesService images.Service,
+	logger *slog.Logger,
+	storageKey string,
+	contentType string,
+	size int64,
+	open func() (io.ReadCloser, error),
+) {
+	url, err := imagesService.PresignedURL(r.Context(), storageKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if url != "" {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	streamObject(w, logger, contentType, size, open)
+}
+
+func streamObject(
+	w http.ResponseWriter,
+	logger *slog.Logger,
+	contentType string,
+	size int64,
+	open func() (io.ReadCloser, error),
+) {
+	file, err := open()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Error("cannot close image file", "error", err)
+		}
+	}()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		logger.Error("cannot write image", "error", err)
+	}
+}
+
+// Render serves the image resized and re-encoded according to the query.
+// Requests with non canonical parameters are redirected to the canonical URL
+// so that each variant is rendered and cached once.
+func (h ImagesHandler) Render(w http.ResponseWriter, r *http.Request) {
+	image, _, ok := h.authorizedImage(w, r, images.ActionView)
+	if !ok {
+		return
+	}
+	params, err := h.imagesService.ParseRenderParams(r.URL.Query(), image)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	if canonical := params.Query().Encode(); canonical != r.URL.Query().Encode() {
+		target := *r.URL
+		target.RawQuery = canonical
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+	rendition, err := h.imagesService.Render(r.Context(), image, params)
+	if err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	serveObject(w, r, h.imagesService, h.logger, rendition.StorageKey, rendition.ContentType, rendition.Size, func() (io.ReadCloser, error) {
+		return h.imagesService.OpenRendition(r.Context(), rendition)
+	})
+}
+
+func (h ImagesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	image, _, ok := h.authorizedImage(w, r, images.ActionManage)
+	if !ok {
+		return
+	}
+	if err := h.imagesService.DeleteImage(r.Context(), image.Id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/plinkplenk/img-share/internal/passwordreset"
+	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/pkg/cookies"
+	"net/http"
+)
+
+type forgotPassword struct {
+	Email string `json:"email"`
+}
+
+type resetPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type changePassword struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ForgotPassword mails a password reset link. It answers 202 whether an
+// account with the address exists or not.
+func (h AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	body, err := JSONFromReaderTo[forgotPassword](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := h.passwordResetService.Request(r.Context(), body.Email, requestLocale(r)); err != nil {
+		if errors.Is(err, users.ErrInvalidEmail) {
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token of an emailed reset link
+// and signs the user out of every session.
+func (h AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	body, err := JSONFromReaderTo[resetPassword](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if body.Token == "" {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: passwordreset.ErrInvalidToken.Error()})
+		return
+	}
+	if _, err := h.passwordResetService.Reset(r.Context(), body.Token, body.Password); err != nil {
+		if errors.Is(err, passwordreset.ErrInvalidToken) || errors.Is(err, users.ErrPasswordTooShort) {
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	cookies.Delete(h.sessionCookieName, w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword sets a new password given the current one, signs the user
+// out of every other session and moves the current one to a new id. Bearer
+// clients get the new token in the response.
+func (h AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	session, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	body, err := JSONFromReaderTo[changePassword](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := h.usersService.ChangePassword(r.Context(), session.UserId, body.NewPassword, body.OldPassword); err != nil {
+		switch {
+		case errors.Is(err, users.ErrPasswordsDidNotMatch):
+			writeJSON(w, h.logger, http.StatusForbidden, BadRequest{Message: "invalid password"})
+		case errors.Is(err, users.ErrPasswordTooShort):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := h.authService.DeleteSessionByUserId(r.Context(), session.UserId, session.Id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token, err := h.rotateSession(w, r, session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if token != nil {
+		writeJSON(w, h.logger, http.StatusOK, token)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
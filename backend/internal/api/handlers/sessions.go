@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/pkg/cookies"
+	"net/http"
+	"time"
+)
+
+const sessionIdURLParamName = "id"
+
+type sessionResponse struct {
+	Id         string    `json:"id"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresOn  time.Time `json:"expires_on"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// currentSession returns the session authenticated by the auth
+// middlewares, answering 401 when there is none.
+func currentSession(w http.ResponseWriter, r *http.Request) (auth.Session, bool) {
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return auth.Session{}, false
+	}
+	return session, true
+}
+
+// rotateSession moves session to a new id after the privileges of its user
+// changed. Cookie clients get the new id in the cookie; for bearer clients,
+// recognised by their Authorization header, it is returned to be sent in
+// the response, nil otherwise.
+func (h AuthHandler) rotateSession(w http.ResponseWriter, r *http.Request, session auth.Session) (*bearerTokenResponse, error) {
+	rotated, err := h.authService.RotateSession(r.Context(), session.Id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Header.Get("Authorization") != "" {
+		return &bearerTokenResponse{Token: rotated.Id, ExpiresOn: rotated.ExpiresOn}, nil
+	}
+	cookies.Set(h.sessionCookieName, rotated.Id, rotated.ExpiresOn, w)
+	return nil, nil
+}
+
+func (h AuthHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := h.authService.GetSessionsByUserId(r.Context(), current.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := make([]sessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = sessionResponse{
+			Id:         session.PublicId(),
+			Current:    session.Id == current.Id,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresOn:  session.ExpiresOn,
+			IP:         session.Device.IP,
+			UserAgent:  session.Device.UserAgent,
+		}
+	}
+	writeJSON(w, h.logger, http.StatusOK, response)
+}
+
+func (h AuthHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	current, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := h.authService.DeleteUserSession(r.Context(), current.UserId, chi.URLParam(r, sessionIdURLParamName))
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if deleted.Id == current.Id {
+		cookies.Delete(h.sessionCookieName, w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs the user out everywhere but in the session of
+// the request.
+func (h AuthHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	if err := h.authService.DeleteSessionByUserId(r.Context(), current.UserId, current.Id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/albums"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/internal/shares"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	shareTokenURLParamName = "token"
+	sharePathPrefix        = "/s"
+	sharePasswordRealm     = `Basic realm="shared image", charset="UTF-8"`
+)
+
+type shareCreate struct {
+	ImageId   uuid.NullUUID `json:"image_id"`
+	AlbumId   uuid.NullUUID `json:"album_id"`
+	ExpiresAt *time.Time    `json:"expires_at"`
+	MaxViews  int           `json:"max_views"`
+	Password  string        `json:"password"`
+}
+
+type shareResponse struct {
+	Token       string     `json:"token"`
+	URL         string     `json:"url"`
+	ImageId     *uuid.UUID `json:"image_id,omitempty"`
+	AlbumId     *uuid.UUID `json:"album_id,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxViews    int        `json:"max_views"`
+	Views       int        `json:"views"`
+	HasPassword bool       `json:"has_password"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func newShareResponse(link shares.Link) shareResponse {
+	response := shareResponse{
+		Token:       link.Token,
+		URL:         fmt.Sprintf("%s/%s", sharePathPrefix, link.Token),
+		MaxViews:    link.MaxViews,
+		Views:       link.Views,
+		HasPassword: link.HasPassword(),
+		CreatedAt:   link.CreatedAt,
+	}
+	if link.ImageId.Valid {
+		response.ImageId = &link.ImageId.UUID
+	}
+	if link.AlbumId.Valid {
+		response.AlbumId = &link.AlbumId.UUID
+	}
+	if !link.ExpiresAt.IsZero() {
+		response.ExpiresAt = &link.ExpiresAt
+	}
+	return response
+}
+
+type sharedAlbumResponse struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	albumImagesResponse
+}
+
+type SharesHandler struct {
+	sharesService shares.Service
+	imagesService images.Service
+	albumsService albums.Service
+	logger        *slog.Logger
+}
+
+func NewSharesHandler(
+	sharesService shares.Service,
+	imagesService images.Service,
+	albumsService albums.Service,
+	logger *slog.Logger,
+) SharesHandler {
+	return SharesHandler{
+		sharesService: sharesService,
+		imagesService: imagesService,
+		albumsService: albumsService,
+		logger:        logger,
+	}
+}
+
+func (h SharesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	request, err := JSONFromReaderTo[shareCreate](r.Body)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid json"})
+		return
+	}
+	opts := shares.CreateOptions{
+		ImageId:  request.ImageId,
+		AlbumId:  request.AlbumId,
+		MaxViews: request.MaxViews,
+		Password: request.Password,
+	}
+	if request.ExpiresAt != nil {
+		opts.ExpiresAt = *request.ExpiresAt
+	}
+	link, err := h.sharesService.Create(r.Context(), user.Id, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, shares.ErrInvalidLink):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		case errors.Is(err, images.ErrImageNotFound):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "image not found"})
+		case errors.Is(err, albums.ErrAlbumNotFound):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "album not found"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, h.logger, http.StatusCreated, newShareResponse(link))
+}
+
+func (h SharesHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	links, err := h.sharesService.GetLinksByOwnerId(r.Context(), user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := make([]shareResponse, len(links))
+	for i, link := range links {
+		response[i] = newShareResponse(link)
+	}
+	writeJSON(w, h.logger, http.StatusOK, response)
+}
+
+func (h SharesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.sharesService.Delete(r.Context(), user.Id, chi.URLParam(r, shareTokenURLParamName)); err != nil {
+		if errors.Is(err, shares.ErrLinkNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h SharesHandler) writeLinkError(w http.ResponseWriter, err error) {
+	var locked shares.LockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		writeJSON(w, h.logger, http.StatusTooManyRequests, BadRequest{Message: shares.ErrLocked.Error()})
+	case errors.Is(err, shares.ErrLinkNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, shares.ErrLinkExpired):
+		w.WriteHeader(http.StatusGone)
+	case errors.Is(err, shares.ErrPasswordRequired), errors.Is(err, shares.ErrInvalidPassword):
+		w.Header().Set("WWW-Authenticate", sharePasswordRealm)
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// Resolve serves a shared image, or the listing of a shared album, to
+// anonymous visitors. The password of protected links is taken from basic
+// auth, so browsers prompt for it on their own; the user name is ignored.
+func (h SharesHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, shareTokenURLParamName)
+	_, pass, _ := r.BasicAuth()
+	if _, _, err := pageParams(r); err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+		return
+	}
+	link, err := h.sharesService.Resolve(r.Context(), token, pass)
+	if err != nil {
+		h.writeLinkError(w, err)
+		return
+	}
+	// every response counts as a view, caches must not serve it again
+	w.Header().Set("Cache-Control", "no-store")
+	if link.AlbumId.Valid {
+		h.writeSharedAlbum(w, r, link)
+		return
+	}
+	image, err := h.imagesService.GetImageById(r.Context(), link.ImageId.UUID)
+	if err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// share link visitors are anonymous, even the owner following their
+	// own link burns the image
+	serveImage(w, r, h.imagesService, h.logger, uuid.NullUUID{}, image)
+}
+
+// writeSharedAlbum lists a page of the album with image URLs that go through
+// the share link rather than the owner's API.
+func (h SharesHandler) writeSharedAlbum(w http.ResponseWriter, r *http.Request, link shares.Link) {
+	album, err := h.albumsService.GetAlbumById(r.Context(), link.AlbumId.UUID)
+	if err != nil {
+		if errors.Is(err, albums.ErrAlbumNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	after, limit, _ := pageParams(r)
+	prefix := fmt.Sprintf("%s/%s/images", sharePathPrefix, link.Token)
+	page, err := albumImagesPage(r.Context(), h.albumsService, h.imagesService, album.Id, after, limit, nil, func(image *imageResponse) {
+		image.URL = fmt.Sprintf("%s/%s", prefix, image.Id)
+		if image.ThumbnailURL != "" {
+			image.ThumbnailURL = fmt.Sprintf("%s/%s/derivatives/%s", prefix, image.Id, thumbnailDerivativeName)
+		}
+		for i, derivative := range image.Derivatives {
+			image.Derivatives[i].URL = fmt.Sprintf("%s/%s/derivatives/%s", prefix, image.Id, derivative.Name)
+		}
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, sharedAlbumResponse{
+		Title:               album.Title,
+		Description:         album.Description,
+		albumImagesResponse: page,
+	})
+}
+
+// sharedAlbumImage resolves an image of a shared album from the URL. Loading
+// the images of an album does not count as further views of the link, its
+// view limit applies to the album page.
+func (h SharesHandler) sharedAlbumImage(w http.ResponseWriter, r *http.Request) (images.Image, bool) {
+	_, pass, _ := r.BasicAuth()
+	link, err := h.sharesService.AuthorizeAlbumImage(r.Context(), chi.URLParam(r, shareTokenURLParamName), pass)
+	if err != nil {
+		h.writeLinkError(w, err)
+		return images.Image{}, false
+	}
+	imageId, err := uuid.FromString(chi.URLParam(r, imageIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return images.Image{}, false
+	}
+	if _, err := h.albumsService.GetAlbumImage(r.Context(), link.AlbumId.UUID, imageId); err != nil {
+		if errors.Is(err, albums.ErrAlbumImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return images.Image{}, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return images.Image{}, false
+	}
+	image, err := h.imagesService.GetImageById(r.Context(), imageId)
+	if err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return images.Image{}, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return images.Image{}, false
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return image, true
+}
+
+func (h SharesHandler) AlbumImage(w http.ResponseWriter, r *http.Request) {
+	image, ok := h.sharedAlbumImage(w, r)
+	if !ok {
+		return
+	}
+	serveImage(w, r, h.imagesService, h.logger, uuid.NullUUID{}, image)
+}
+
+func (h SharesHandler) AlbumImageDerivative(w http.ResponseWriter, r *http.Request) {
+	image, ok := h.sharedAlbumImage(w, r)
+	if !ok {
+		return
+	}
+	derivative, err := h.imagesService.GetDerivative(r.Context(), image.Id, chi.URLParam(r, derivativeNameURLParamName))
+	if err != nil {
+		if errors.Is(err, images.ErrDerivativeNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	serveObject(w, r, h.imagesService, h.logger, derivative.StorageKey, derivative.ContentType, derivative.Size, func() (io.ReadCloser, error) {
+		return h.imagesService.OpenDerivative(r.Context(), derivative)
+	})
+}
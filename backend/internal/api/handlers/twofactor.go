@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/plinkplenk/img-share/internal/twofactor"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type twoFactorChallengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	Challenge         string    `json:"challenge"`
+	ExpiresOn         time.Time `json:"expires_on"`
+}
+
+type twoFactorStatusResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+type enrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// bearerTokenResponse carries the rotated session of bearer clients.
+	*bearerTokenResponse
+}
+
+type twoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type twoFactorVerify struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+	Bearer    bool   `json:"bearer"`
+}
+
+// writeTwoFactorError answers the errors shared by the two-factor
+// endpoints.
+func (h AuthHandler) writeTwoFactorError(w http.ResponseWriter, err error) {
+	var locked twofactor.LockedError
+	switch {
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		writeJSON(w, h.logger, http.StatusTooManyRequests, BadRequest{Message: twofactor.ErrLocked.Error()})
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		writeJSON(w, h.logger, http.StatusForbidden, BadRequest{Message: err.Error()})
+	case errors.Is(err, twofactor.ErrAlreadyEnabled):
+		writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: err.Error()})
+	case errors.Is(err, twofactor.ErrNotEnabled), errors.Is(err, twofactor.ErrCredentialNotFound):
+		writeJSON(w, h.logger, http.StatusNotFound, BadRequest{Message: err.Error()})
+	case errors.Is(err, twofactor.ErrNotConfigured):
+		writeJSON(w, h.logger, http.StatusServiceUnavailable, BadRequest{Message: err.Error()})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h AuthHandler) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	enabled, err := h.twoFactorService.Enabled(r.Context(), user.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, twoFactorStatusResponse{Enabled: enabled})
+}
+
+// EnrollTwoFactor generates a secret to add to an authenticator app, two
+// factor authentication is enabled once ConfirmTwoFactor gets a code.
+func (h AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	enrollment, err := h.twoFactorService.Enroll(r.Context(), user)
+	if err != nil {
+		h.writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusCreated, enrollmentResponse{Secret: enrollment.Secret, URI: enrollment.URI})
+}
+
+// TwoFactorQRCode serves the pending enrolment as a PNG QR code.
+func (h AuthHandler) TwoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	enrollment, err := h.twoFactorService.Enrollment(r.Context(), user)
+	if err != nil {
+		h.writeTwoFactorError(w, err)
+		return
+	}
+	png, err := enrollment.QRCode()
+	if err != nil {
+		h.logger.Error("cannot render two-factor qr code", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	// the code holds the secret
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(png); err != nil {
+		h.logger.Error("cannot write response", "error", err)
+	}
+}
+
+// ConfirmTwoFactor enables two-factor authentication and moves the session
+// to a new id, bearer clients get the new token along with the recovery
+// codes.
+func (h AuthHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	body, err := JSONFromReaderTo[twoFactorCode](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	codes, err := h.twoFactorService.Confirm(r.Context(), session.UserId, body.Code)
+	if err != nil {
+		h.writeTwoFactorError(w, err)
+		return
+	}
+	token, err := h.rotateSession(w, r, session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes, bearerTokenResponse: token})
+}
+
+// DisableTwoFactor turns two-factor authentication off and moves the session
+// to a new id, returned to bearer clients.
+func (h AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, ok := currentSession(w, r)
+	if !ok {
+		return
+	}
+	body, err := JSONFromReaderTo[twoFactorCode](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := h.twoFactorService.Disable(r.Context(), session.UserId, body.Code); err != nil {
+		h.writeTwoFactorError(w, err)
+		return
+	}
+	token, err := h.rotateSession(w, r, session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if token != nil {
+		writeJSON(w, h.logger, http.StatusOK, token)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	body, err := JSONFromReaderTo[twoFactorCode](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(r.Context(), user.Id, body.Code)
+	if err != nil {
+		h.writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyTwoFactor completes a sign in challenged by Login with a code from
+// the authenticator app or a recovery code.
+func (h AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	body, err := JSONFromReaderTo[twoFactorVerify](r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := h.twoFactorService.CompleteChallenge(r.Context(), body.Challenge, body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, twofactor.ErrInvalidChallenge), errors.Is(err, twofactor.ErrNotEnabled):
+			writeJSON(w, h.logger, http.StatusUnauthorized, BadRequest{Message: twofactor.ErrInvalidChallenge.Error()})
+		case errors.Is(err, twofactor.ErrInvalidCode):
+			writeJSON(w, h.logger, http.StatusUnauthorized, BadRequest{Message: err.Error()})
+		default:
+			h.writeTwoFactorError(w, err)
+		}
+		return
+	}
+	h.startSession(w, r, user, body.Bearer)
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/internal/uploads"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion              = "1.0.0"
+	tusExtensions           = "creation,termination,expiration"
+	tusResumableHeader      = "Tus-Resumable"
+	tusVersionHeader        = "Tus-Version"
+	tusExtensionHeader      = "Tus-Extension"
+	tusMaxSizeHeader        = "Tus-Max-Size"
+	uploadOffsetHeader      = "Upload-Offset"
+	uploadLengthHeader      = "Upload-Length"
+	uploadMetadataHeader    = "Upload-Metadata"
+	uploadDeferLengthHeader = "Upload-Defer-Length"
+	uploadExpiresHeader     = "Upload-Expires"
+	// imageLocationHeader points finished uploads to the image they became,
+	// tus itself has no way to report what a completed upload produced.
+	imageLocationHeader  = "Image-Location"
+	tusChunkContentType  = "application/offset+octet-stream"
+	uploadIdURLParamName = "id"
+	uploadsPathPrefix    = "/api/uploads"
+)
+
+// UploadsHandler implements the core tus 1.0 protocol with the creation,
+// termination and expiration extensions. Finished uploads are turned into
+// images.
+type UploadsHandler struct {
+	uploadsService uploads.Service
+	logger         *slog.Logger
+}
+
+func NewUploadsHandler(
+	uploadsService uploads.Service,
+	logger *slog.Logger,
+) UploadsHandler {
+	return UploadsHandler{
+		uploadsService: uploadsService,
+		logger:         logger,
+	}
+}
+
+// TusResumable sets the Tus-Resumable header on every response and rejects
+// requests speaking another protocol version. OPTIONS is exempt so that
+// clients can discover the supported versions.
+func (h UploadsHandler) TusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(tusResumableHeader, tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get(tusResumableHeader) != tusVersion {
+			w.Header().Set(tusVersionHeader, tusVersion)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h UploadsHandler) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(tusVersionHeader, tusVersion)
+	w.Header().Set(tusExtensionHeader, tusExtensions)
+	w.Header().Set(tusMaxSizeHeader, strconv.FormatInt(h.uploadsService.MaxSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h UploadsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get(uploadDeferLengthHeader) != "" {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "deferred upload length is not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get(uploadLengthHeader), 10, 64)
+	if err != nil {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid Upload-Length header"})
+		return
+	}
+	upload, err := h.uploadsService.Create(r.Context(), user.Id, length, r.Header.Get(uploadMetadataHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrUploadTooLarge):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		case errors.Is(err, uploads.ErrInvalidUploadLength):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid Upload-Length header"})
+		case errors.Is(err, uploads.ErrInvalidMetadata):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid Upload-Metadata header"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", uploadsPathPrefix, upload.Id))
+	w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ownUpload resolves the upload from the URL and makes sure it belongs to the
+// session user. It writes the error response itself and reports false if the
+// request should not continue.
+func (h UploadsHandler) ownUpload(w http.ResponseWriter, r *http.Request) (uploads.Upload, bool) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return uploads.Upload{}, false
+	}
+	id, err := uuid.FromString(chi.URLParam(r, uploadIdURLParamName))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return uploads.Upload{}, false
+	}
+	upload, err := h.uploadsService.GetUploadById(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return uploads.Upload{}, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return uploads.Upload{}, false
+	}
+	if upload.OwnerId != user.Id {
+		w.WriteHeader(http.StatusNotFound)
+		return uploads.Upload{}, false
+	}
+	return upload, true
+}
+
+func (h UploadsHandler) setUploadHeaders(w http.ResponseWriter, upload uploads.Upload) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	if upload.ImageId.Valid {
+		w.Header().Set(imageLocationHeader, fmt.Sprintf("%s/%s", imagesPathPrefix, upload.ImageId.UUID))
+	} else {
+		w.Header().Set(uploadExpiresHeader, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (h UploadsHandler) Head(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.ownUpload(w, r)
+	if !ok {
+		return
+	}
+	h.setUploadHeaders(w, upload)
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set(uploadMetadataHeader, upload.Metadata)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h UploadsHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.ownUpload(w, r)
+	if !ok {
+		return
+	}
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	if strings.TrimSpace(contentType) != tusChunkContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "invalid Upload-Offset header"})
+		return
+	}
+	if r.ContentLength > upload.Length-offset {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "chunk exceeds the upload length"})
+		return
+	}
+	upload, err = h.uploadsService.WriteChunk(r.Context(), upload, offset, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrOffsetMismatch), errors.Is(err, uploads.ErrUploadAlreadyFinished):
+			w.WriteHeader(http.StatusConflict)
+		case errors.Is(err, uploads.ErrChunkTooLarge):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "chunk exceeds the upload length"})
+		case errors.Is(err, images.ErrUnsupportedFormat):
+			writeJSON(w, h.logger, http.StatusUnsupportedMediaType, BadRequest{Message: "only jpeg, png, gif and webp images are supported"})
+		case errors.Is(err, images.ErrInvalidImageUpload):
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: "cannot read uploaded image"})
+		default:
+			// most likely the client went away mid chunk, it can resume
+			// from the offset reported by HEAD
+			h.logger.Warn("cannot write upload chunk", "upload_id", upload.Id, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	h.setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h UploadsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.ownUpload(w, r)
+	if !ok {
+		return
+	}
+	if err := h.uploadsService.Delete(r.Context(), upload); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
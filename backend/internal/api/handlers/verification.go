@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/plinkplenk/img-share/internal/verification"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const verificationTokenQueryParamName = "token"
+
+// Verify activates the account of the emailed verification link.
+func (h AuthHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get(verificationTokenQueryParamName)
+	if token == "" {
+		writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: verification.ErrInvalidToken.Error()})
+		return
+	}
+	user, err := h.verificationService.Verify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, verification.ErrInvalidToken) {
+			writeJSON(w, h.logger, http.StatusBadRequest, BadRequest{Message: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, h.logger, http.StatusOK, newUserResponse(user))
+}
+
+func (h AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.verificationService.Send(r.Context(), user, requestLocale(r)); err != nil {
+		var throttled verification.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			writeJSON(w, h.logger, http.StatusTooManyRequests, BadRequest{Message: verification.ErrResendThrottled.Error()})
+		case errors.Is(err, verification.ErrAlreadyVerified):
+			writeJSON(w, h.logger, http.StatusConflict, BadRequest{Message: err.Error()})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,105 @@
+package middlewares
+
+import (
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/pkg/cookies"
+	"net/http"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+type authenticator struct {
+	authService  auth.Service
+	usersService users.Service
+	cookieName   string
+}
+
+// sessionId reads the session id of r from a bearer token or, when there is
+// none, from the session cookie, reporting whether it came from the cookie.
+// Other authorization schemes, such as the basic auth of share link
+// passwords, are not ours and leave the cookie in charge.
+func (a authenticator) sessionId(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) >= len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return strings.TrimSpace(header[len(bearerPrefix):]), false
+	}
+	cookie, err := r.Cookie(a.cookieName)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// authenticate resolves the session of r once per request, renewing it on
+// the way, and returns r with the session and its user in the context.
+func (a authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if _, ok := auth.SessionFromContext(r.Context()); ok {
+		return r, true
+	}
+	id, fromCookie := a.sessionId(r)
+	if id == "" {
+		return r, false
+	}
+	ctx := r.Context()
+	session, renewed, err := a.authService.RenewSession(ctx, id, auth.DeviceFromRequest(r))
+	if err != nil {
+		return r, false
+	}
+	user, err := a.usersService.GetUserById(ctx, session.UserId)
+	if err != nil {
+		return r, false
+	}
+	if renewed && fromCookie {
+		cookies.Set(a.cookieName, session.Id, session.ExpiresOn, w)
+	}
+	return r.WithContext(auth.WithSession(ctx, session, user)), true
+}
+
+// OptionalAuth puts the session of the request and its user in the context
+// when there is a valid one and lets anonymous requests through.
+func OptionalAuth(authService auth.Service, usersService users.Service, cookieName string) func(http.Handler) http.Handler {
+	a := authenticator{authService: authService, usersService: usersService, cookieName: cookieName}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, _ = a.authenticate(w, r)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAuth is OptionalAuth answering 401 to anonymous requests.
+func RequireAuth(authService auth.Service, usersService users.Service, cookieName string) func(http.Handler) http.Handler {
+	a := authenticator{authService: authService, usersService: usersService, cookieName: cookieName}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := a.authenticate(w, r)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerified is RequireAuth answering 403 to users who did not verify
+// their email address yet.
+func RequireVerified(authService auth.Service, usersService users.Service, cookieName string) func(http.Handler) http.Handler {
+	a := authenticator{authService: authService, usersService: usersService, cookieName: cookieName}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := a.authenticate(w, r)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if user, _ := auth.UserFromContext(r.Context()); !user.IsActive {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
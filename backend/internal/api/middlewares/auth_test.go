@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionId(t *testing.T) {
+	tests := []struct {
+		name           string
+		authorization  string
+		cookie         string
+		wantId         string
+		wantFromCookie bool
+	}{
+		{name: "nothing"},
+		{name: "bearer", authorization: "Bearer token", wantId: "token"},
+		{name: "bearer over cookie", authorization: "Bearer token", cookie: "cookie", wantId: "token"},
+		{name: "scheme is case insensitive", authorization: "bearer token", wantId: "token"},
+		{name: "cookie", cookie: "cookie", wantId: "cookie", wantFromCookie: true},
+		{name: "basic auth falls back to the cookie", authorization: "Basic dTpw", cookie: "cookie", wantId: "cookie", wantFromCookie: true},
+		{name: "basic auth without cookie", authorization: "Basic dTpw"},
+		{name: "empty bearer", authorization: "Bearer ", cookie: "cookie"},
+	}
+	a := authenticator{cookieName: "session-id"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: a.cookieName, Value: tt.cookie})
+			}
+			id, fromCookie := a.sessionId(r)
+			if id != tt.wantId || fromCookie != tt.wantFromCookie {
+				t.Fatalf("sessionId = %q, %v, want %q, %v", id, fromCookie, tt.wantId, tt.wantFromCookie)
+			}
+		})
+	}
+}
@@ -1,29 +1,62 @@
 package middlewares
 
 import (
-	"github.com/go-chi/chi/v5"
-	"github.com/plinkplenk/img-share/internal/api"
 	"net/http"
 )
 
-type writer struct {
+// redirectWriter forwards the response unless the handler succeeds and a
+// redirect target was given, in which case the body is replaced by a redirect.
+// Headers set by the handler, such as cookies, are kept either way.
+type redirectWriter struct {
 	http.ResponseWriter
-	code int
+	r          *http.Request
+	target     string
+	code       int
+	redirected bool
 }
 
-func (w *writer) WriteHeader(statusCode int) {
+func (w *redirectWriter) WriteHeader(statusCode int) {
+	if w.code != 0 {
+		return
+	}
 	w.code = statusCode
+	// 202 is an unfinished sign-in waiting for a second factor, its body is
+	// what the client needs to go on.
+	if w.target != "" && statusCode >= 200 && statusCode < 300 && statusCode != http.StatusAccepted {
+		w.redirected = true
+		w.ResponseWriter.Header().Del("Content-Type")
+		w.ResponseWriter.Header().Del("Content-Length")
+		http.Redirect(w.ResponseWriter, w.r, w.target, http.StatusFound)
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
 }
 
-func Redirect(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		writer := &writer{ResponseWriter: w}
-		redirectValue := chi.URLParam(r, api.RedirectUrlParamName)
-		next.ServeHTTP(writer, r)
-		if redirectValue != "" {
-			http.Redirect(w, r, redirectValue, http.StatusFound)
-			return
-		}
-		w.WriteHeader(writer.code)
-	})
+func (w *redirectWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.redirected {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Redirect sends the client to the url given in the paramName query parameter
+// once the handler has responded with a success status. Other responses are
+// forwarded untouched.
+func Redirect(paramName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writer := &redirectWriter{
+				ResponseWriter: w,
+				r:              r,
+				target:         r.URL.Query().Get(paramName),
+			}
+			next.ServeHTTP(writer, r)
+			if writer.code == 0 {
+				writer.WriteHeader(http.StatusOK)
+			}
+		})
+	}
 }
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		code     int
+		wantCode int
+		wantBody string
+		wantTo   string
+	}{
+		{name: "success without target", code: http.StatusCreated, wantCode: http.StatusCreated, wantBody: "body"},
+		{name: "success with target", target: "/home", code: http.StatusOK, wantCode: http.StatusFound, wantTo: "/home"},
+		{name: "implicit success", target: "/home", wantCode: http.StatusFound, wantTo: "/home"},
+		{name: "challenge", target: "/home", code: http.StatusAccepted, wantCode: http.StatusAccepted, wantBody: "body"},
+		{name: "conflict", target: "/home", code: http.StatusConflict, wantCode: http.StatusConflict, wantBody: "body"},
+		{name: "unauthorized", target: "/home", code: http.StatusUnauthorized, wantCode: http.StatusUnauthorized, wantBody: "body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Redirect("redirect-url")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: "session-id", Value: "id"})
+				if tt.code != 0 {
+					w.WriteHeader(tt.code)
+				}
+				w.Write([]byte("body"))
+			}))
+			url := "/sign-in"
+			if tt.target != "" {
+				url += "?redirect-url=" + tt.target
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, url, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantTo {
+				t.Errorf("location = %q, want %q", got, tt.wantTo)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if len(rec.Result().Cookies()) != 1 {
+				t.Errorf("session cookie was not kept")
+			}
+		})
+	}
+}
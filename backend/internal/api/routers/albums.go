@@ -0,0 +1,27 @@
+package routers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/api/handlers"
+)
+
+func NewAlbumsRoute(handler handlers.AlbumsHandler, guards Guards) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/public", handler.Public)
+	r.Get("/{id}", handler.Get)
+	r.Get("/{id}/images", handler.Images)
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireAuth)
+		r.Get("/", handler.List)
+		r.Delete("/{id}", handler.Delete)
+		r.Post("/{id}/images", handler.AddImage)
+		r.Put("/{id}/images/{imageId}/position", handler.MoveImage)
+		r.Delete("/{id}/images/{imageId}", handler.RemoveImage)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireVerified)
+		r.Post("/", handler.Create)
+		r.Put("/{id}", handler.Update)
+	})
+	return r
+}
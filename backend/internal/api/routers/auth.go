@@ -6,10 +6,35 @@ import (
 	"github.com/plinkplenk/img-share/internal/api/middlewares"
 )
 
-func NewAuthRoute(handler handlers.AuthHandler) chi.Router {
+func NewAuthRoute(
+	handler handlers.AuthHandler,
+	redirectParamName string,
+	guards Guards,
+) chi.Router {
 	r := chi.NewRouter()
-	r.With(middlewares.Redirect).Post("/sign-up", handler.Register)
-	r.With(middlewares.Redirect).Post("/sign-in", handler.Login)
-	r.Post("/sign-out", handler.Logout)
+	redirect := middlewares.Redirect(redirectParamName)
+	r.With(redirect).Post("/sign-up", handler.Register)
+	r.With(redirect).Post("/sign-in", handler.Login)
+	r.Get("/verify", handler.Verify)
+	r.Post("/password/forgot", handler.ForgotPassword)
+	r.Post("/password/reset", handler.ResetPassword)
+	r.Get("/email/confirm", handler.ConfirmEmailChange)
+	r.Post("/2fa/verify", handler.VerifyTwoFactor)
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireAuth)
+		r.Post("/sign-out", handler.Logout)
+		r.Post("/verify/resend", handler.ResendVerification)
+		r.Post("/password/change", handler.ChangePassword)
+		r.Post("/email/change", handler.ChangeEmail)
+		r.Get("/2fa", handler.TwoFactorStatus)
+		r.Post("/2fa/enroll", handler.EnrollTwoFactor)
+		r.Get("/2fa/enroll/qr", handler.TwoFactorQRCode)
+		r.Post("/2fa/confirm", handler.ConfirmTwoFactor)
+		r.Post("/2fa/disable", handler.DisableTwoFactor)
+		r.Post("/2fa/recovery-codes", handler.RegenerateRecoveryCodes)
+		r.Get("/sessions", handler.Sessions)
+		r.Delete("/sessions/{id}", handler.DeleteSession)
+		r.Post("/sessions/revoke-others", handler.RevokeOtherSessions)
+	})
 	return r
 }
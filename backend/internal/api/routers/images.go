@@ -0,0 +1,26 @@
+package routers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/api/handlers"
+)
+
+func NewImagesRoute(handler handlers.ImagesHandler, guards Guards) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/public", handler.Public)
+	r.Get("/{id}", handler.Get)
+	r.Get("/{id}/file", handler.File)
+	r.Get("/{id}/derivatives/{name}", handler.Derivative)
+	r.Get("/{id}/render", handler.Render)
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireAuth)
+		r.Get("/", handler.List)
+		r.Delete("/{id}", handler.Delete)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireVerified)
+		r.Post("/", handler.Upload)
+		r.Patch("/{id}", handler.Update)
+	})
+	return r
+}
@@ -2,27 +2,99 @@ package routers
 
 import (
 	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/albums"
 	"github.com/plinkplenk/img-share/internal/api/handlers"
 	"github.com/plinkplenk/img-share/internal/api/middlewares"
 	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/emailchange"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/internal/passwordreset"
+	"github.com/plinkplenk/img-share/internal/shares"
+	"github.com/plinkplenk/img-share/internal/twofactor"
+	"github.com/plinkplenk/img-share/internal/uploads"
 	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/internal/verification"
 	"log/slog"
+	"net/http"
 )
 
 type Opts struct {
-	UsersService users.Service
-	AuthService  auth.Service
-	Logger       *slog.Logger
+	UsersService         users.Service
+	AuthService          auth.Service
+	ImagesService        images.Service
+	UploadsService       uploads.Service
+	SharesService        shares.Service
+	AlbumsService        albums.Service
+	VerificationService  verification.Service
+	PasswordResetService passwordreset.Service
+	EmailChangeService   emailchange.Service
+	TwoFactorService     twofactor.Service
+	MaxUploadSize        int64
+	SessionCookieName    string
+	RedirectParamName    string
+	Logger               *slog.Logger
+}
+
+// Guards are the middlewares protecting route groups. Every request is
+// authenticated once before reaching them, they only decide who gets in.
+type Guards struct {
+	RequireAuth func(http.Handler) http.Handler
+	// RequireVerified lets in users who verified their email address only.
+	RequireVerified func(http.Handler) http.Handler
 }
 
 func SetupAPIRouter(parent chi.Router, opts Opts) {
 	logger := opts.Logger
 	r := chi.NewRouter()
 	r.Use(middlewares.Logger(logger))
+	r.Use(middlewares.OptionalAuth(opts.AuthService, opts.UsersService, opts.SessionCookieName))
+	guards := Guards{
+		RequireAuth:     middlewares.RequireAuth(opts.AuthService, opts.UsersService, opts.SessionCookieName),
+		RequireVerified: middlewares.RequireVerified(opts.AuthService, opts.UsersService, opts.SessionCookieName),
+	}
+
+	authHandler := handlers.NewAuthHandler(
+		opts.AuthService,
+		opts.UsersService,
+		opts.VerificationService,
+		opts.PasswordResetService,
+		opts.EmailChangeService,
+		opts.TwoFactorService,
+		opts.SessionCookieName,
+		logger,
+	)
+
+	imagesHandler := handlers.NewImagesHandler(
+		opts.ImagesService,
+		opts.MaxUploadSize,
+		logger,
+	)
 
-	authHandler := handlers.NewAuthHandler(opts.AuthService, opts.UsersService, logger)
+	uploadsHandler := handlers.NewUploadsHandler(
+		opts.UploadsService,
+		logger,
+	)
 
-	r.Mount("/auth", NewAuthRoute(authHandler))
+	albumsHandler := handlers.NewAlbumsHandler(
+		opts.AlbumsService,
+		opts.ImagesService,
+		logger,
+	)
+
+	sharesHandler := handlers.NewSharesHandler(
+		opts.SharesService,
+		opts.ImagesService,
+		opts.AlbumsService,
+		logger,
+	)
+
+	r.Mount("/auth", NewAuthRoute(authHandler, opts.RedirectParamName, guards))
+	r.Mount("/images", NewImagesRoute(imagesHandler, guards))
+	r.Mount("/uploads", NewUploadsRoute(uploadsHandler, guards))
+	r.Mount("/albums", NewAlbumsRoute(albumsHandler, guards))
+	r.Mount("/shares", NewSharesRoute(sharesHandler, guards))
 
 	parent.Mount("/api", r)
+
+	parent.With(middlewares.Logger(logger)).Mount("/s", NewShareLinksRoute(sharesHandler))
 }
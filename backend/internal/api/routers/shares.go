@@ -0,0 +1,26 @@
+package routers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/api/handlers"
+)
+
+func NewSharesRoute(handler handlers.SharesHandler, guards Guards) chi.Router {
+	r := chi.NewRouter()
+	r.With(guards.RequireVerified).Post("/", handler.Create)
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireAuth)
+		r.Get("/", handler.List)
+		r.Delete("/{token}", handler.Delete)
+	})
+	return r
+}
+
+// NewShareLinksRoute serves the public side of share links, outside of /api.
+func NewShareLinksRoute(handler handlers.SharesHandler) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/{token}", handler.Resolve)
+	r.Get("/{token}/images/{id}", handler.AlbumImage)
+	r.Get("/{token}/images/{id}/derivatives/{name}", handler.AlbumImageDerivative)
+	return r
+}
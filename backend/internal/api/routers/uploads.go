@@ -0,0 +1,20 @@
+package routers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/plinkplenk/img-share/internal/api/handlers"
+)
+
+func NewUploadsRoute(handler handlers.UploadsHandler, guards Guards) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.TusResumable)
+	r.Options("/", handler.Options)
+	r.Group(func(r chi.Router) {
+		r.Use(guards.RequireVerified)
+		r.Post("/", handler.Create)
+		r.Head("/{id}", handler.Head)
+		r.Patch("/{id}", handler.Patch)
+		r.Delete("/{id}", handler.Delete)
+	})
+	return r
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/plinkplenk/img-share/internal/albums"
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/config"
+	"github.com/plinkplenk/img-share/internal/emailchange"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"github.com/plinkplenk/img-share/internal/mailer"
+	"github.com/plinkplenk/img-share/internal/passwordreset"
+	"github.com/plinkplenk/img-share/internal/shares"
+	"github.com/plinkplenk/img-share/internal/storage"
+	"github.com/plinkplenk/img-share/internal/tokens"
+	"github.com/plinkplenk/img-share/internal/twofactor"
+	"github.com/plinkplenk/img-share/internal/uploads"
+	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/internal/verification"
+	"log/slog"
+	"net/url"
+)
+
+// Services are the services shared by the server and the worker.
+type Services struct {
+	Users         users.Service
+	Auth          auth.Service
+	Images        images.Service
+	Uploads       uploads.Service
+	Shares        shares.Service
+	Albums        albums.Service
+	Jobs          jobs.Service
+	Tokens        tokens.Service
+	Mailer        mailer.Service
+	Verification  verification.Service
+	PasswordReset passwordreset.Service
+	EmailChange   emailchange.Service
+	TwoFactor     twofactor.Service
+}
+
+func NewServices(ctx context.Context, cfg config.Config, pool *pgxpool.Pool, logger *slog.Logger) (Services, error) {
+	blobStorage, err := newStorage(ctx, cfg.Storage)
+	if err != nil {
+		return Services{}, fmt.Errorf("cannot create storage: %w", err)
+	}
+
+	usersRepository := users.NewPostgresRepository(pool)
+	authRepository := auth.NewPostgresRepository(pool)
+	imagesRepository := images.NewPostgresRepository(pool)
+	uploadsRepository := uploads.NewPostgresRepository(pool)
+	sharesRepository := shares.NewPostgresRepository(pool)
+	albumsRepository := albums.NewPostgresRepository(pool)
+	jobsRepository := jobs.NewPostgresRepository(pool)
+	jobsService := jobs.NewService(
+		jobsRepository,
+		cfg.Jobs.MaxAttempts,
+		cfg.Jobs.Timeout,
+		logger.With("service", "jobs"),
+	)
+	usersService := users.NewService(usersRepository, cfg.Users.Timeout, logger.With("service", "users"))
+	tokensService := tokens.NewService(
+		tokens.NewPostgresRepository(pool),
+		cfg.Tokens.Timeout,
+		logger.With("service", "tokens"),
+	)
+	mailerService, err := newMailerService(cfg, pool, jobsService, logger)
+	if err != nil {
+		return Services{}, err
+	}
+	verifyURL, err := url.Parse(cfg.Verification.URL)
+	if err != nil {
+		return Services{}, fmt.Errorf("invalid verification url: %w", err)
+	}
+	verificationService := verification.NewService(
+		tokensService,
+		usersService,
+		mailerService,
+		*verifyURL,
+		cfg.Verification.TokenTTL,
+		cfg.Verification.ResendInterval,
+		logger.With("service", "verification"),
+	)
+	authService := auth.NewService(
+		authRepository,
+		usersRepository,
+		cfg.Auth.SessionLifetime,
+		cfg.Auth.SessionMaxLifetime,
+		cfg.Auth.Timeout,
+		logger.With("service", "auth"),
+	)
+	resetURL, err := url.Parse(cfg.PasswordReset.URL)
+	if err != nil {
+		return Services{}, fmt.Errorf("invalid password reset url: %w", err)
+	}
+	passwordResetService := passwordreset.NewService(
+		tokensService,
+		usersService,
+		authService,
+		mailerService,
+		jobsService,
+		*resetURL,
+		cfg.PasswordReset.TokenTTL,
+		cfg.PasswordReset.ResendInterval,
+		logger.With("service", "passwordreset"),
+	)
+	confirmURL, err := url.Parse(cfg.EmailChange.URL)
+	if err != nil {
+		return Services{}, fmt.Errorf("invalid email change url: %w", err)
+	}
+	emailChangeService := emailchange.NewService(
+		tokensService,
+		usersService,
+		mailerService,
+		*confirmURL,
+		cfg.EmailChange.TokenTTL,
+		cfg.EmailChange.ResendInterval,
+		logger.With("service", "emailchange"),
+	)
+	twoFactorKey, err := cfg.TwoFactor.Key()
+	if err != nil {
+		return Services{}, fmt.Errorf("invalid two-factor encryption key: %w", err)
+	}
+	twoFactorService, err := twofactor.NewService(
+		twofactor.NewPostgresRepository(pool),
+		tokensService,
+		usersService,
+		twofactor.Options{
+			Issuer:        cfg.TwoFactor.Issuer,
+			EncryptionKey: twoFactorKey,
+			ChallengeTTL:  cfg.TwoFactor.ChallengeTTL,
+			MaxFailures:   cfg.TwoFactor.MaxFailures,
+			Lockout:       cfg.TwoFactor.Lockout,
+		},
+		cfg.TwoFactor.Timeout,
+		logger.With("service", "twofactor"),
+	)
+	if err != nil {
+		return Services{}, fmt.Errorf("cannot create two-factor service: %w", err)
+	}
+	imagesService := images.NewService(
+		imagesRepository,
+		blobStorage,
+		jobsService,
+		cfg.Storage.S3.PresignExpiry,
+		images.RenderLimits{
+			MaxDimension:  cfg.Images.Render.MaxDimension,
+			DimensionStep: cfg.Images.Render.DimensionStep,
+		},
+		cfg.Images.MaxPixels,
+		cfg.Images.Expiry.MaxTTL,
+		cfg.Images.Timeout,
+		logger.With("service", "images"),
+	)
+	uploadsService := uploads.NewService(
+		uploadsRepository,
+		blobStorage,
+		imagesService,
+		cfg.Images.MaxUploadSize,
+		cfg.Uploads.Expiry,
+		cfg.Uploads.Timeout,
+		logger.With("service", "uploads"),
+	)
+	albumsService := albums.NewService(
+		albumsRepository,
+		imagesService,
+		cfg.Albums.Timeout,
+		logger.With("service", "albums"),
+	)
+	sharesService := shares.NewService(
+		sharesRepository,
+		imagesService,
+		albumsService,
+		shares.Options{
+			MaxFailures: cfg.Shares.MaxPasswordFailures,
+			Lockout:     cfg.Shares.PasswordLockout,
+		},
+		cfg.Shares.Timeout,
+		logger.With("service", "shares"),
+	)
+	return Services{
+		Users:         usersService,
+		Auth:          authService,
+		Images:        imagesService,
+		Uploads:       uploadsService,
+		Shares:        sharesService,
+		Albums:        albumsService,
+		Jobs:          jobsService,
+		Tokens:        tokensService,
+		Mailer:        mailerService,
+		Verification:  verificationService,
+		PasswordReset: passwordResetService,
+		EmailChange:   emailChangeService,
+		TwoFactor:     twoFactorService,
+	}, nil
+}
+
+// NewRunner creates the job runner with the handlers and schedules of every
+// service registered.
+func NewRunner(cfg config.Config, pool *pgxpool.Pool, services Services, logger *slog.Logger) (*jobs.Runner, error) {
+	runner := jobs.NewRunner(
+		jobs.NewPostgresRepository(pool),
+		jobs.RunnerOptions{
+			Workers:      cfg.Jobs.Workers,
+			PollInterval: cfg.Jobs.PollInterval,
+			Lease:        cfg.Jobs.Lease,
+			MaxAttempts:  cfg.Jobs.MaxAttempts,
+			BackoffBase:  cfg.Jobs.BackoffBase,
+			BackoffMax:   cfg.Jobs.BackoffMax,
+		},
+		cfg.Jobs.Timeout,
+		logger,
+	)
+	if err := auth.RegisterJobs(runner, services.Auth, cfg.Auth.CleanupSchedule); err != nil {
+		return nil, err
+	}
+	if err := tokens.RegisterJobs(runner, services.Tokens); err != nil {
+		return nil, err
+	}
+	if err := mailer.RegisterJobs(runner, services.Mailer); err != nil {
+		return nil, err
+	}
+	if err := passwordreset.RegisterJobs(runner, services.PasswordReset); err != nil {
+		return nil, err
+	}
+	if err := images.RegisterJobs(runner, services.Images, cfg.Images.Expiry.PurgeSchedule); err != nil {
+		return nil, err
+	}
+	if err := uploads.RegisterJobs(runner, services.Uploads); err != nil {
+		return nil, err
+	}
+	return runner, nil
+}
+
+func newMailerService(
+	cfg config.Config,
+	pool *pgxpool.Pool,
+	jobsService jobs.Service,
+	logger *slog.Logger,
+) (mailer.Service, error) {
+	templates, err := mailer.NewTemplates(cfg.Mailer.DefaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load email templates: %w", err)
+	}
+	var m mailer.Mailer
+	switch cfg.Mailer.Driver {
+	case mailer.DriverLog:
+		m = mailer.NewLogMailer(logger.With("mailer", "log"))
+	case mailer.DriverSMTP:
+		m, err = mailer.NewSMTPMailer(mailer.SMTPOptions{
+			Host:     cfg.Mailer.SMTP.Host,
+			Port:     cfg.Mailer.SMTP.Port,
+			Username: cfg.Mailer.SMTP.Username,
+			Password: cfg.Mailer.SMTP.Password,
+			Security: cfg.Mailer.SMTP.Security,
+			From:     cfg.Mailer.From,
+			Timeout:  cfg.Mailer.SMTP.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create smtp mailer: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", cfg.Mailer.Driver)
+	}
+	return mailer.NewService(
+		mailer.NewPostgresRepository(pool),
+		m,
+		templates,
+		jobsService,
+		cfg.Mailer.MaxAttempts,
+		cfg.Mailer.Timeout,
+		logger.With("service", "mailer"),
+	), nil
+}
+
+func newStorage(ctx context.Context, cfg config.Storage) (storage.Storage, error) {
+	switch cfg.Driver {
+	case storage.DriverLocal:
+		return storage.NewLocalStorage(cfg.Local.Dir)
+	case storage.DriverS3:
+		return storage.NewS3Storage(ctx, storage.S3Options{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyId:     cfg.S3.AccessKeyId,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			UseSSL:          cfg.S3.UseSSL,
+			PathStyle:       cfg.S3.PathStyle,
+			PartSize:        uint64(cfg.S3.PartSize),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
@@ -2,20 +2,72 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"github.com/gofrs/uuid/v5"
+	"net"
+	"net/http"
 	"time"
 )
 
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
 type Session struct {
 	Id        string
 	UserId    uuid.UUID
 	ExpiresOn time.Time
+	// CreatedAt is when the user signed in, renewals and rotations keep it
+	// so that a session never outlives the maximum session lifetime.
+	CreatedAt time.Time
+	// LastSeenAt is when the session was last used, to the resolution of
+	// lastSeenResolution.
+	LastSeenAt time.Time
+	// Device is the client the session was last used from.
+	Device Device
+}
+
+// Device describes the client behind a session so that users can tell
+// their sessions apart.
+type Device struct {
+	IP        string
+	UserAgent string
+}
+
+// DeviceFromRequest describes the client that sent r.
+func DeviceFromRequest(r *http.Request) Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return Device{IP: ip, UserAgent: r.UserAgent()}
+}
+
+// PublicId identifies the session to its user without revealing the
+// session id, which is as good as the password of the user.
+func (s Session) PublicId() string {
+	sum := sha256.Sum256([]byte(s.Id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Expired reports whether the session is no longer valid at now. Expired
+// sessions are treated as missing until the cleanup deletes them.
+func (s Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresOn)
 }
 
 type Repository interface {
 	CreateSession(ctx context.Context, session Session) (Session, error)
 	GetSessionById(ctx context.Context, value string) (Session, error)
 	GetSessionsByUserId(ctx context.Context, userId uuid.UUID) ([]Session, error)
+	// UpdateSession replaces the id and expiry of the session id with those
+	// of session.
+	UpdateSession(ctx context.Context, id string, session Session) (Session, error)
 	DeleteSession(ctx context.Context, sessionValue string) error
 	DeleteSessionsByUserId(ctx context.Context, userId uuid.UUID, exceptIds ...string) error
+	// DeleteExpiredSessions deletes the sessions expired at now and returns
+	// how many were deleted.
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
 }
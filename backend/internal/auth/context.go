@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/users"
+)
+
+type contextKey int
+
+const (
+	sessionContextKey contextKey = iota
+	userContextKey
+)
+
+// WithSession returns a copy of ctx carrying the authenticated session and
+// its user.
+func WithSession(ctx context.Context, session Session, user users.User) context.Context {
+	ctx = context.WithValue(ctx, sessionContextKey, session)
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+func SessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(Session)
+	return session, ok
+}
+
+func UserFromContext(ctx context.Context) (users.User, bool) {
+	user, ok := ctx.Value(userContextKey).(users.User)
+	return user, ok
+}
+
+// UserIdFromContext returns the id of the authenticated user, invalid for
+// anonymous requests.
+func UserIdFromContext(ctx context.Context) uuid.NullUUID {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: user.Id, Valid: true}
+}
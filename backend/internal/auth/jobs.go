@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/plinkplenk/img-share/internal/jobs"
+)
+
+const JobDeleteExpiredSessions = "auth.delete_expired_sessions"
+
+// RegisterJobs makes runner execute the auth jobs and schedules the cleanup
+// of expired sessions by the cron expression cleanupSchedule.
+func RegisterJobs(runner *jobs.Runner, service Service, cleanupSchedule string) error {
+	runner.Handle(JobDeleteExpiredSessions, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.DeleteExpiredSessions(ctx)
+		return err
+	})
+	if err := runner.Schedule(JobDeleteExpiredSessions, cleanupSchedule, JobDeleteExpiredSessions, nil); err != nil {
+		return fmt.Errorf("invalid session cleanup schedule: %w", err)
+	}
+	return nil
+}
@@ -2,23 +2,35 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"strings"
+	"time"
 )
 
 const postgresRepositorySource = "auth.repo.pg"
 
+const sessionColumns = `id, user_id, expires_on, created_at, last_seen_at, ip, user_agent`
+
 type postgresRepository struct {
 	db *pgxpool.Pool
 }
 
 type pgSession struct {
-	id        string
-	userId    pgtype.UUID
-	expiresOn pgtype.Timestamp
+	id         string
+	userId     pgtype.UUID
+	expiresOn  pgtype.Timestamp
+	createdAt  pgtype.Timestamp
+	lastSeenAt pgtype.Timestamp
+	ip         string
+	userAgent  string
+}
+
+func (s *pgSession) scanTargets() []any {
+	return []any{&s.id, &s.userId, &s.expiresOn, &s.createdAt, &s.lastSeenAt, &s.ip, &s.userAgent}
 }
 
 func fromPGSession(session pgSession) (Session, error) {
@@ -27,9 +39,15 @@ func fromPGSession(session pgSession) (Session, error) {
 		return Session{}, err
 	}
 	return Session{
-		Id:        session.id,
-		UserId:    userId,
-		ExpiresOn: session.expiresOn.Time,
+		Id:         session.id,
+		UserId:     userId,
+		ExpiresOn:  session.expiresOn.Time,
+		CreatedAt:  session.createdAt.Time,
+		LastSeenAt: session.lastSeenAt.Time,
+		Device: Device{
+			IP:        session.ip,
+			UserAgent: session.userAgent,
+		},
 	}, nil
 }
 
@@ -38,10 +56,22 @@ func toPGSession(session Session) pgSession {
 		id: session.Id,
 		userId: pgtype.UUID{
 			Bytes: [16]byte(session.UserId.Bytes()),
+			Valid: true,
 		},
 		expiresOn: pgtype.Timestamp{
-			Time: session.ExpiresOn.UTC(),
+			Time:  session.ExpiresOn.UTC(),
+			Valid: true,
+		},
+		createdAt: pgtype.Timestamp{
+			Time:  session.CreatedAt.UTC(),
+			Valid: true,
 		},
+		lastSeenAt: pgtype.Timestamp{
+			Time:  session.LastSeenAt.UTC(),
+			Valid: true,
+		},
+		ip:        session.Device.IP,
+		userAgent: session.Device.UserAgent,
 	}
 }
 
@@ -55,18 +85,23 @@ func (r *postgresRepository) CreateSession(ctx context.Context, session Session)
 	const op = postgresRepositorySource + ".CreateSession"
 	query := `
 INSERT INTO auth_sessions
-	(id, user_id, expires_on) 
-	VALUES ($1, $2, $3)
-	RETURNING id, user_id, expires_on;
-`
+	(` + sessionColumns + `) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + sessionColumns
 
 	sessionToCreate := toPGSession(session)
 	var createdSession pgSession
-	if err := r.db.QueryRow(ctx, query, sessionToCreate.id, sessionToCreate.userId, sessionToCreate.expiresOn).Scan(
-		&createdSession.id,
-		&createdSession.userId,
-		&createdSession.expiresOn,
-	); err != nil {
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		sessionToCreate.id,
+		sessionToCreate.userId,
+		sessionToCreate.expiresOn,
+		sessionToCreate.createdAt,
+		sessionToCreate.lastSeenAt,
+		sessionToCreate.ip,
+		sessionToCreate.userAgent,
+	).Scan(createdSession.scanTargets()...); err != nil {
 		return Session{}, fmt.Errorf("[%s]: %w", op, err)
 	}
 	return fromPGSession(createdSession)
@@ -76,7 +111,8 @@ func (r *postgresRepository) getSessionsByField(ctx context.Context, field strin
 	const op = postgresRepositorySource + ".getSessionsByField"
 	query := fmt.Sprintf(
 		`
-SELECT id, user_id, expires_on FROM auth_sessions WHERE %s = $1`,
+SELECT %s FROM auth_sessions WHERE %s = $1`,
+		sessionColumns,
 		field,
 	)
 	var sessions []Session
@@ -90,7 +126,7 @@ SELECT id, user_id, expires_on FROM auth_sessions WHERE %s = $1`,
 			return []Session{}, rows.Err()
 		}
 		var pgSession pgSession
-		if err := rows.Scan(&pgSession.id, &pgSession.userId, &pgSession.expiresOn); err != nil {
+		if err := rows.Scan(pgSession.scanTargets()...); err != nil {
 			return []Session{}, err
 		}
 		session, err := fromPGSession(pgSession)
@@ -104,9 +140,12 @@ SELECT id, user_id, expires_on FROM auth_sessions WHERE %s = $1`,
 
 func (r *postgresRepository) GetSessionById(ctx context.Context, value string) (Session, error) {
 	const op = postgresRepositorySource + ".GetSessionById"
-	sessions, err := r.getSessionsByField(ctx, "value", value)
+	sessions, err := r.getSessionsByField(ctx, "id", value)
 	if err != nil {
-		return Session{}, fmt.Errorf("[%s]: %e", op, err)
+		return Session{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if len(sessions) == 0 {
+		return Session{}, ErrSessionNotFound
 	}
 	return sessions[0], nil
 }
@@ -115,6 +154,33 @@ func (r *postgresRepository) GetSessionsByUserId(ctx context.Context, userId uui
 	return r.getSessionsByField(ctx, "user_id", userId)
 }
 
+func (r *postgresRepository) UpdateSession(ctx context.Context, id string, session Session) (Session, error) {
+	const op = postgresRepositorySource + ".UpdateSession"
+	query := `
+UPDATE auth_sessions SET id = $2, expires_on = $3, last_seen_at = $4, ip = $5, user_agent = $6
+	WHERE id = $1
+	RETURNING ` + sessionColumns
+
+	toUpdate := toPGSession(session)
+	var updated pgSession
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		id,
+		toUpdate.id,
+		toUpdate.expiresOn,
+		toUpdate.lastSeenAt,
+		toUpdate.ip,
+		toUpdate.userAgent,
+	).Scan(updated.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGSession(updated)
+}
+
 func (r *postgresRepository) DeleteSession(ctx context.Context, sessionValue string) error {
 	const op = postgresRepositorySource + ".DeleteSession"
 	query := `DELETE FROM auth_sessions WHERE id = $1`
@@ -127,14 +193,9 @@ func (r *postgresRepository) DeleteSession(ctx context.Context, sessionValue str
 
 func (r *postgresRepository) DeleteSessionsByUserId(ctx context.Context, userId uuid.UUID, exceptValues ...string) error {
 	const op = postgresRepositorySource + ".DeleteSessionsByUserId"
-	query := `DELETE FROM auth_sessions WHERE user_id = $1`
-	if len(exceptValues) > 0 {
-		// adding placeholders
-		placeholders := make([]string, len(exceptValues))
-		for i := 1; i <= len(exceptValues); i++ {
-			placeholders[i-1] = fmt.Sprintf("$%d", i)
-		}
-		query = fmt.Sprintf("[%s] AND value NOT IN (%s)", query, strings.Join(placeholders, ", "))
+	query := `DELETE FROM auth_sessions WHERE user_id = $1 AND NOT (id = ANY($2))`
+	if exceptValues == nil {
+		exceptValues = []string{}
 	}
 	_, err := r.db.Exec(ctx, query, userId, exceptValues)
 	if err != nil {
@@ -143,12 +204,13 @@ func (r *postgresRepository) DeleteSessionsByUserId(ctx context.Context, userId
 	return nil
 }
 
-func (r *postgresRepository) DeleteExpiredSessions(ctx context.Context) error {
+func (r *postgresRepository) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
 	const op = postgresRepositorySource + ".DeleteExpiredSessions"
-	query := `DELETE FROM auth_sessions WHERE expires_on < CURRENT_TIMESTAMP`
-	_, err := r.db.Exec(ctx, query)
+	// sessions created without an expiry never were valid
+	query := `DELETE FROM auth_sessions WHERE expires_on <= $1 OR expires_on IS NULL`
+	tag, err := r.db.Exec(ctx, query, pgtype.Timestamp{Time: now.UTC(), Valid: true})
 	if err != nil {
-		return fmt.Errorf("[%s]: %w", op, err)
+		return 0, fmt.Errorf("[%s]: %w", op, err)
 	}
-	return nil
+	return tag.RowsAffected(), nil
 }
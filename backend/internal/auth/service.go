@@ -4,28 +4,52 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gofrs/uuid/v5"
 	"github.com/plinkplenk/img-share/internal/users"
 	"log/slog"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-const sessionIdSize = 24
+const (
+	sessionIdSize = 24
+	// lastSeenResolution throttles the writes recording that a session was
+	// used.
+	lastSeenResolution = 5 * time.Minute
+	maxIPLength        = 64
+	maxUserAgentLength = 512
+)
 
 type Service interface {
-	CreateSession(ctx context.Context, userId uuid.UUID) (Session, error)
+	CreateSession(ctx context.Context, userId uuid.UUID, device Device) (Session, error)
 	GetSessionById(ctx context.Context, id string) (Session, error)
 	GetSessionsByUserId(ctx context.Context, userId uuid.UUID) ([]Session, error)
+	// RenewSession records that the session was used from device and slides
+	// the expiry of a session used past half of its lifetime, reporting
+	// whether it did so that the cookie can follow. Both are throttled to
+	// spare a write per request.
+	RenewSession(ctx context.Context, id string, device Device) (Session, bool, error)
+	// RotateSession moves the session to a new id, to be called whenever
+	// the privileges of its user change so that an id planted before the
+	// change cannot be used after it.
+	RotateSession(ctx context.Context, id string) (Session, error)
 	DeleteSessionByUserId(ctx context.Context, userId uuid.UUID, exceptIds ...string) error
 	DeleteSessionById(ctx context.Context, id string) error
+	// DeleteUserSession deletes the session of userId with the public id
+	// publicId, see Session.PublicId.
+	DeleteUserSession(ctx context.Context, userId uuid.UUID, publicId string) (Session, error)
 	GetUserBySessionId(ctx context.Context, id string) (users.User, error)
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
 }
 
 type service struct {
 	sessionRepository Repository
 	usersRepository   users.Repository
 	sessionLifeTime   time.Duration
+	sessionMaxLife    time.Duration
 	timeout           time.Duration
 	logger            *slog.Logger
 }
@@ -34,12 +58,14 @@ func NewService(
 	authRepository Repository,
 	usersRepository users.Repository,
 	sessionLifeTime time.Duration,
+	sessionMaxLife time.Duration,
 	timeout time.Duration, logger *slog.Logger,
 ) Service {
 	return service{
 		sessionRepository: authRepository,
 		usersRepository:   usersRepository,
 		sessionLifeTime:   sessionLifeTime,
+		sessionMaxLife:    sessionMaxLife,
 		timeout:           timeout,
 		logger:            logger,
 	}
@@ -61,17 +87,57 @@ func (s service) sessionIdBytesToHexString(sessionIdBytes [sessionIdSize]byte) s
 	return hex.EncodeToString(sessionIdBytes[:])
 }
 
-func (s service) CreateSession(ctx context.Context, userId uuid.UUID) (Session, error) {
+func (s service) newSessionId() (string, error) {
 	sessionIdBytes, err := s.generateSessionIdBytes()
 	if err != nil {
 		s.logger.Error("unable to generate session id", "error", err)
+		return "", err
+	}
+	return s.sessionIdBytesToHexString(sessionIdBytes), nil
+}
+
+// expiresOn is the expiry of a session created at createdAt and used at
+// now, capped by the maximum session lifetime.
+func (s service) expiresOn(createdAt time.Time, now time.Time) time.Time {
+	expiresOn := now.Add(s.sessionLifeTime)
+	if limit := createdAt.Add(s.sessionMaxLife); expiresOn.After(limit) {
+		return limit
+	}
+	return expiresOn
+}
+
+func (s service) device(device Device) Device {
+	device.IP = truncate(device.IP, maxIPLength)
+	device.UserAgent = truncate(device.UserAgent, maxUserAgentLength)
+	return device
+}
+
+// truncate cuts value to at most n bytes of valid UTF-8, without splitting
+// a rune, since postgres refuses invalid text.
+func truncate(value string, n int) string {
+	value = strings.ToValidUTF8(value, "\uFFFD")
+	if len(value) <= n {
+		return value
+	}
+	for n > 0 && !utf8.RuneStart(value[n]) {
+		n--
+	}
+	return value[:n]
+}
+
+func (s service) CreateSession(ctx context.Context, userId uuid.UUID, device Device) (Session, error) {
+	sessionId, err := s.newSessionId()
+	if err != nil {
 		return Session{}, err
 	}
-	sessionId := s.sessionIdBytesToHexString(sessionIdBytes)
+	now := time.Now()
 	session := Session{
-		Id:        sessionId,
-		UserId:    userId,
-		ExpiresOn: time.Now().Add(s.sessionLifeTime),
+		Id:         sessionId,
+		UserId:     userId,
+		ExpiresOn:  s.expiresOn(now, now),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		Device:     s.device(device),
 	}
 	c, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
@@ -88,9 +154,14 @@ func (s service) GetSessionById(ctx context.Context, id string) (Session, error)
 	defer cancel()
 	session, err := s.sessionRepository.GetSessionById(c, id)
 	if err != nil {
-		s.logger.Error("unable to get session by id", "error", err)
+		if !errors.Is(err, ErrSessionNotFound) {
+			s.logger.Error("unable to get session by id", "error", err)
+		}
 		return Session{}, err
 	}
+	if session.Expired(time.Now()) {
+		return Session{}, ErrSessionNotFound
+	}
 	return session, nil
 }
 func (s service) GetSessionsByUserId(ctx context.Context, userId uuid.UUID) ([]Session, error) {
@@ -101,12 +172,93 @@ func (s service) GetSessionsByUserId(ctx context.Context, userId uuid.UUID) ([]S
 		s.logger.Error("unable to get sessions", "error", err)
 		return []Session{}, err
 	}
-	return sessions, nil
+	now := time.Now()
+	valid := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.Expired(now) {
+			valid = append(valid, session)
+		}
+	}
+	return valid, nil
+}
+
+func (s service) RenewSession(ctx context.Context, id string, device Device) (Session, bool, error) {
+	session, err := s.GetSessionById(ctx, id)
+	if err != nil {
+		return Session{}, false, err
+	}
+	now := time.Now()
+	extend := false
+	if session.ExpiresOn.Sub(now) <= s.sessionLifeTime/2 {
+		// the expiry stops moving once the session reached its maximum
+		// lifetime
+		expiresOn := s.expiresOn(session.CreatedAt, now)
+		extend = expiresOn.After(session.ExpiresOn)
+		session.ExpiresOn = expiresOn
+	}
+	if !extend && now.Sub(session.LastSeenAt) < lastSeenResolution {
+		return session, false, nil
+	}
+	session.LastSeenAt = now
+	session.Device = s.device(device)
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	renewed, err := s.sessionRepository.UpdateSession(c, id, session)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			s.logger.Error("unable to renew session", "error", err)
+		}
+		return Session{}, false, err
+	}
+	return renewed, extend, nil
+}
+
+func (s service) RotateSession(ctx context.Context, id string) (Session, error) {
+	session, err := s.GetSessionById(ctx, id)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.Id, err = s.newSessionId(); err != nil {
+		return Session{}, err
+	}
+	session.ExpiresOn = s.expiresOn(session.CreatedAt, time.Now())
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	rotated, err := s.sessionRepository.UpdateSession(c, id, session)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			s.logger.Error("unable to rotate session", "error", err)
+		}
+		return Session{}, err
+	}
+	return rotated, nil
 }
 
 func (s service) DeleteSessionByUserId(ctx context.Context, userId uuid.UUID, exceptIds ...string) error {
-	//TODO implement me
-	panic("implement me")
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.sessionRepository.DeleteSessionsByUserId(c, userId, exceptIds...); err != nil {
+		s.logger.Error("unable to delete sessions of user", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) DeleteUserSession(ctx context.Context, userId uuid.UUID, publicId string) (Session, error) {
+	sessions, err := s.GetSessionsByUserId(ctx, userId)
+	if err != nil {
+		return Session{}, err
+	}
+	for _, session := range sessions {
+		if session.PublicId() != publicId {
+			continue
+		}
+		if err := s.DeleteSessionById(ctx, session.Id); err != nil {
+			return Session{}, err
+		}
+		return session, nil
+	}
+	return Session{}, ErrSessionNotFound
 }
 
 func (s service) DeleteSessionById(ctx context.Context, sessionId string) error {
@@ -121,11 +273,25 @@ func (s service) DeleteSessionById(ctx context.Context, sessionId string) error
 
 func (s service) GetUserBySessionId(ctx context.Context, sessionId string) (users.User, error) {
 	// TODO maybe implement this in auth repository
-	c, cancel := context.WithTimeout(ctx, s.timeout)
-	defer cancel()
-	session, err := s.sessionRepository.GetSessionById(c, sessionId)
+	session, err := s.GetSessionById(ctx, sessionId)
 	if err != nil {
 		return users.User{}, err
 	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
 	return s.usersRepository.GetUserById(c, session.UserId)
 }
+
+func (s service) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	deleted, err := s.sessionRepository.DeleteExpiredSessions(c, time.Now())
+	if err != nil {
+		s.logger.Error("unable to delete expired sessions", "error", err)
+		return 0, err
+	}
+	if deleted > 0 {
+		s.logger.Info("deleted expired sessions", "count", deleted)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		n     int
+		want  string
+	}{
+		{name: "short", value: "curl/8.0", n: 16, want: "curl/8.0"},
+		{name: "exact", value: "curl/8.0", n: 8, want: "curl/8.0"},
+		{name: "ascii", value: "curl/8.0", n: 4, want: "curl"},
+		{name: "before a rune", value: "abпр", n: 3, want: "ab"},
+		{name: "on a rune boundary", value: "abпр", n: 4, want: "abп"},
+		{name: "inside a four byte rune", value: "a😀", n: 4, want: "a"},
+		{name: "invalid bytes replaced", value: "a\xffb", n: 16, want: "a�b"},
+		{name: "zero", value: "п", n: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.value, tt.n)
+			if got != tt.want {
+				t.Fatalf("truncate(%q, %d) = %q, want %q", tt.value, tt.n, got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > tt.n {
+				t.Fatalf("truncate(%q, %d) = %q is not valid text of at most %d bytes", tt.value, tt.n, got, tt.n)
+			}
+		})
+	}
+}
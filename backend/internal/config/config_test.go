@@ -0,0 +1,175 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load runs Load with its own flag set, the YAML file, if any, passed in
+// the environment.
+func load(t *testing.T, file string, env map[string]string, args ...string) (Config, error) {
+	t.Helper()
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(configEnvName, path)
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = `
+server:
+  addr: ":9000"
+auth:
+  session_lifetime: 48h
+mailer:
+  smtp:
+    port: 2525
+jobs:
+  in_server: false
+`
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		wantAddr string
+		wantLife time.Duration
+		wantPort int
+		wantJobs bool
+	}{
+		{
+			name:     "defaults",
+			wantAddr: ":8080",
+			wantLife: 7 * 24 * time.Hour,
+			wantPort: Default().Mailer.SMTP.Port,
+			wantJobs: true,
+		},
+		{
+			name:     "file over defaults",
+			file:     file,
+			wantAddr: ":9000",
+			wantLife: 48 * time.Hour,
+			wantPort: 2525,
+			wantJobs: false,
+		},
+		{
+			name: "env over file",
+			file: file,
+			env: map[string]string{
+				"IMGSHARE_SERVER_ADDR":      ":9100",
+				"IMGSHARE_MAILER_SMTP_PORT": "2626",
+			},
+			wantAddr: ":9100",
+			wantLife: 48 * time.Hour,
+			wantPort: 2626,
+			wantJobs: false,
+		},
+		{
+			name: "flags over env",
+			file: file,
+			env: map[string]string{
+				"IMGSHARE_SERVER_ADDR":    ":9100",
+				"IMGSHARE_JOBS_IN_SERVER": "false",
+			},
+			args:     []string{"-server.addr", ":9200", "-auth.session_lifetime", "72h", "-jobs.in_server=true"},
+			wantAddr: ":9200",
+			wantLife: 72 * time.Hour,
+			wantPort: 2525,
+			wantJobs: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Addr != tt.wantAddr {
+				t.Errorf("server.addr = %q, want %q", cfg.Server.Addr, tt.wantAddr)
+			}
+			if cfg.Auth.SessionLifetime != tt.wantLife {
+				t.Errorf("auth.session_lifetime = %s, want %s", cfg.Auth.SessionLifetime, tt.wantLife)
+			}
+			if cfg.Mailer.SMTP.Port != tt.wantPort {
+				t.Errorf("mailer.smtp.port = %d, want %d", cfg.Mailer.SMTP.Port, tt.wantPort)
+			}
+			if cfg.Jobs.InServer != tt.wantJobs {
+				t.Errorf("jobs.in_server = %v, want %v", cfg.Jobs.InServer, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlagOverridesEnv(t *testing.T) {
+	envPath := filepath.Join(t.TempDir(), "env.yaml")
+	flagPath := filepath.Join(t.TempDir(), "flag.yaml")
+	if err := os.WriteFile(envPath, []byte("server:\n  addr: \":9000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(flagPath, []byte("server:\n  addr: \":9001\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := load(t, "", map[string]string{configEnvName: envPath}, "-config", flagPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Addr != ":9001" {
+		t.Fatalf("server.addr = %q, want the one of the -config file", cfg.Server.Addr)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown file field", file: "server:\n  port: 80\n", wantErr: "field port not found"},
+		{name: "invalid env duration", env: map[string]string{"IMGSHARE_AUTH_TIMEOUT": "soon"}, wantErr: "IMGSHARE_AUTH_TIMEOUT"},
+		{name: "invalid flag number", args: []string{"-mailer.smtp.port", "smtp"}, wantErr: "mailer.smtp.port"},
+		{name: "unknown flag", args: []string{"-server.port", "80"}, wantErr: "server.port"},
+		{name: "validation", env: map[string]string{"IMGSHARE_SERVER_ADDR": " "}, wantErr: "server.addr is required"},
+		{name: "validation of a later source", file: "auth:\n  timeout: 1s\n", args: []string{"-auth.timeout", "0s"}, wantErr: "auth.timeout must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.file, tt.env, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSkipsValidation(t *testing.T) {
+	t.Setenv("IMGSHARE_SERVER_ADDR", "")
+	t.Setenv("IMGSHARE_DATABASE_URL", "postgresql://db/app")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg, err := Read(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		t.Fatalf("database section refused: %v", err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("empty server.addr accepted")
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type field struct {
+	path  []string
+	usage string
+	value reflect.Value
+}
+
+func (f field) flagName() string {
+	return strings.Join(f.path, ".")
+}
+
+func (f field) envName() string {
+	return envPrefix + "_" + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case f.value.CanInt():
+		i, err := strconv.ParseInt(raw, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(i)
+	case f.value.CanFloat():
+		x, err := strconv.ParseFloat(raw, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(x)
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config field type %s", f.value.Type())
+	}
+	return nil
+}
+
+// collectFields walks cfg and returns every leaf field addressable through
+// the yaml tags of the structs it is nested in.
+func collectFields(cfg *Config) []field {
+	var fields []field
+	var walk func(v reflect.Value, path []string)
+	walk = func(v reflect.Value, path []string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), name)
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+				walk(fv, fieldPath)
+				continue
+			}
+			fields = append(fields, field{
+				path:  fieldPath,
+				usage: sf.Tag.Get("usage"),
+				value: fv,
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), nil)
+	return fields
+}
+
+// flagValue keeps the raw flag value so that flags can be applied after
+// the config file and the environment.
+type flagValue struct {
+	field field
+	raw   string
+}
+
+func (v *flagValue) String() string {
+	if v == nil || !v.field.value.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(v.field.value.Interface())
+}
+
+func (v *flagValue) Set(raw string) error {
+	probe := field{value: reflect.New(v.field.value.Type()).Elem()}
+	if err := probe.set(raw); err != nil {
+		return err
+	}
+	v.raw = raw
+	return nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package emailchange
+
+import "errors"
+
+var (
+	ErrSameEmail       = errors.New("new email address is the current one")
+	ErrEmailTaken      = errors.New("email address is used by another account")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrInvalidToken    = errors.New("invalid or expired email change token")
+	ErrThrottled       = errors.New("email change was asked for recently")
+)
@@ -0,0 +1,154 @@
+package emailchange
+
+import (
+	"context"
+	"errors"
+	"github.com/plinkplenk/img-share/internal/mailer"
+	"github.com/plinkplenk/img-share/internal/tokens"
+	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/pkg/password"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const tokenQueryParamName = "token"
+
+type Service interface {
+	// Request mails a confirmation link to newEmail and a notice to the
+	// current address of user, whose password has to be given again. The
+	// address changes once the link is followed.
+	Request(ctx context.Context, user users.User, newEmail string, currentPassword string, locale string) error
+	// Confirm consumes the token of a confirmation link and moves its user
+	// to the address it was sent to.
+	Confirm(ctx context.Context, token string) (users.User, error)
+}
+
+type service struct {
+	tokensService  tokens.Service
+	usersService   users.Service
+	mailerService  mailer.Service
+	confirmURL     url.URL
+	tokenTTL       time.Duration
+	resendInterval time.Duration
+	logger         *slog.Logger
+}
+
+func NewService(
+	tokensService tokens.Service,
+	usersService users.Service,
+	mailerService mailer.Service,
+	confirmURL url.URL,
+	tokenTTL time.Duration,
+	resendInterval time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		tokensService:  tokensService,
+		usersService:   usersService,
+		mailerService:  mailerService,
+		confirmURL:     confirmURL,
+		tokenTTL:       tokenTTL,
+		resendInterval: resendInterval,
+		logger:         logger,
+	}
+}
+
+func (s service) link(token string) string {
+	link := s.confirmURL
+	query := link.Query()
+	query.Set(tokenQueryParamName, token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// ensureAvailable fails with ErrEmailTaken when an account uses email.
+func (s service) ensureAvailable(ctx context.Context, email string) error {
+	_, err := s.usersService.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		return ErrEmailTaken
+	case errors.Is(err, users.ErrUserNotFound):
+		return nil
+	default:
+		return err
+	}
+}
+
+func (s service) Request(
+	ctx context.Context,
+	user users.User,
+	newEmail string,
+	currentPassword string,
+	locale string,
+) error {
+	if !password.Compare(currentPassword, user.Password) {
+		return ErrInvalidPassword
+	}
+	newEmail, err := users.NormalizeEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if newEmail == user.Email {
+		return ErrSameEmail
+	}
+	if err := s.ensureAvailable(ctx, newEmail); err != nil {
+		return err
+	}
+	lastSent, err := s.tokensService.LastIssuedAt(ctx, user.Id, tokens.PurposeChangeEmail)
+	if err != nil {
+		return err
+	}
+	if !lastSent.IsZero() && time.Since(lastSent) < s.resendInterval {
+		return ErrThrottled
+	}
+	token, _, err := s.tokensService.Issue(ctx, user.Id, tokens.PurposeChangeEmail, newEmail, s.tokenTTL)
+	if err != nil {
+		return err
+	}
+	confirmData := mailer.ConfirmEmailChangeData{Link: s.link(token), ValidFor: int(s.tokenTTL.Minutes())}
+	if err := s.mailerService.Queue(ctx, newEmail, mailer.TemplateConfirmEmailChange, locale, confirmData); err != nil {
+		s.logger.Error("cannot send email change confirmation", "error", err)
+		return err
+	}
+	noticeData := mailer.EmailChangeRequestedData{NewEmail: newEmail}
+	if err := s.mailerService.Queue(ctx, user.Email, mailer.TemplateEmailChangeRequested, locale, noticeData); err != nil {
+		s.logger.Error("cannot send email change notice", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) Confirm(ctx context.Context, token string) (users.User, error) {
+	consumed, err := s.tokensService.Consume(ctx, tokens.PurposeChangeEmail, token)
+	if err != nil {
+		if errors.Is(err, tokens.ErrTokenNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	// the address may have been taken since the link was sent, the unique
+	// index catches whoever takes it in the meantime
+	if err := s.ensureAvailable(ctx, consumed.Email); err != nil {
+		return users.User{}, err
+	}
+	// following the link proves the new address, it counts as verified
+	user, err := s.usersService.UpdateUser(ctx, consumed.UserId, map[string]any{
+		"email":     consumed.Email,
+		"is_active": true,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrUserNotFound):
+			return users.User{}, ErrInvalidToken
+		case errors.Is(err, users.ErrUserExists):
+			return users.User{}, ErrEmailTaken
+		}
+		return users.User{}, err
+	}
+	// links sent to the previous address are no use anymore
+	if err := s.tokensService.Revoke(ctx, user.Id, tokens.PurposeVerifyEmail); err != nil {
+		return users.User{}, err
+	}
+	return user, nil
+}
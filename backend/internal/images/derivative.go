@@ -0,0 +1,138 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/storage"
+	"image"
+	"io"
+	"time"
+)
+
+const derivativeJPEGQuality = 85
+
+// decodeOriginal returns the pixels of the original already rotated and
+// flipped according to its EXIF orientation. Everything encoded from them is
+// written without metadata, so derivatives display upright everywhere.
+func (s service) decodeOriginal(ctx context.Context, img Image) (image.Image, error) {
+	file, _, err := s.storage.Get(ctx, img.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// the header is checked again before decoding, originals stored before
+	// the pixel limit or under a higher one are not trusted either
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(file, &header))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	if err := s.checkPixels(config); err != nil {
+		return nil, err
+	}
+	decoded, _, err := image.Decode(io.MultiReader(&header, file))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	return orient(decoded, img.Metadata.Orientation), nil
+}
+
+// GenerateDerivatives renders every DerivativeSpec from the original, stores
+// the results next to it and records them in the repository.
+func (s service) GenerateDerivatives(ctx context.Context, img Image) ([]Derivative, error) {
+	original, err := s.decodeOriginal(ctx, img)
+	if err != nil {
+		s.logger.Error("cannot decode original image", "image_id", img.Id, "error", err)
+		return nil, err
+	}
+	format := formatJPEG
+	if !isOpaque(original) {
+		format = formatPNG
+	}
+	derivatives := make([]Derivative, 0, len(DerivativeSpecs))
+	for _, spec := range DerivativeSpecs {
+		derivative, err := s.storeDerivative(ctx, img, original, spec, format)
+		if err != nil {
+			s.logger.Error("cannot generate derivative", "image_id", img.Id, "name", spec.Name, "error", err)
+			return derivatives, err
+		}
+		derivatives = append(derivatives, derivative)
+	}
+	return derivatives, nil
+}
+
+func (s service) storeDerivative(
+	ctx context.Context,
+	img Image,
+	original image.Image,
+	spec DerivativeSpec,
+	format outputFormat,
+) (Derivative, error) {
+	resized := resize(original, spec.Width, spec.Height, spec.Mode)
+	content, err := encode(resized, format, derivativeJPEGQuality)
+	if err != nil {
+		return Derivative{}, err
+	}
+	derivative := Derivative{
+		ImageId:     img.Id,
+		Name:        spec.Name,
+		StorageKey:  derivativeKey(img.Id, spec.Name, format),
+		ContentType: format.contentType(),
+		Size:        int64(len(content)),
+		Width:       resized.Bounds().Dx(),
+		Height:      resized.Bounds().Dy(),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if _, err := s.storage.Put(ctx, derivative.StorageKey, bytes.NewReader(content), storage.PutOptions{
+		ContentType: derivative.ContentType,
+		Size:        derivative.Size,
+	}); err != nil {
+		return Derivative{}, err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.repository.CreateDerivative(c, derivative)
+}
+
+func (s service) GetDerivatives(ctx context.Context, imageIds []uuid.UUID) (map[uuid.UUID][]Derivative, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	derivatives, err := s.repository.GetDerivativesByImageIds(c, imageIds)
+	if err != nil {
+		s.logger.Error("cannot get derivatives", "error", err)
+		return nil, err
+	}
+	byImage := make(map[uuid.UUID][]Derivative, len(imageIds))
+	for _, derivative := range derivatives {
+		byImage[derivative.ImageId] = append(byImage[derivative.ImageId], derivative)
+	}
+	return byImage, nil
+}
+
+func (s service) GetDerivative(ctx context.Context, imageId uuid.UUID, name string) (Derivative, error) {
+	byImage, err := s.GetDerivatives(ctx, []uuid.UUID{imageId})
+	if err != nil {
+		return Derivative{}, err
+	}
+	for _, derivative := range byImage[imageId] {
+		if derivative.Name == name {
+			return derivative, nil
+		}
+	}
+	return Derivative{}, ErrDerivativeNotFound
+}
+
+func (s service) OpenDerivative(ctx context.Context, derivative Derivative) (io.ReadCloser, error) {
+	file, _, err := s.storage.Get(ctx, derivative.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, ErrDerivativeNotFound
+		}
+		s.logger.Error("cannot open stored derivative", "error", err)
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,85 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"time"
+)
+
+const (
+	// burnedRetention keeps a burned image around long enough for the one
+	// view it was burned by to finish streaming.
+	burnedRetention = 10 * time.Minute
+	reaperBatchSize = 100
+)
+
+var ErrInvalidTTL = errors.New("invalid ttl")
+
+// Gone reports whether the image expired or was burned by its view. Gone
+// images are treated as deleted until the reaper purges them.
+func (i Image) Gone(now time.Time) bool {
+	return !i.BurnedAt.IsZero() || (!i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt))
+}
+
+func live(imgs []Image, now time.Time) []Image {
+	alive := imgs[:0]
+	for _, img := range imgs {
+		if !img.Gone(now) {
+			alive = append(alive, img)
+		}
+	}
+	return alive
+}
+
+// ConsumeView records a view of the image file by viewerId. Burn after
+// reading images are burned by the first view of anyone but their owner;
+// when two viewers race exactly one of them gets the image and the other
+// ErrImageNotFound. The view counts once the image is returned, whether or
+// not the caller manages to deliver it.
+func (s service) ConsumeView(ctx context.Context, viewerId uuid.NullUUID, img Image) (Image, error) {
+	if !img.BurnAfterReading || (viewerId.Valid && viewerId.UUID == img.OwnerId) {
+		return img, nil
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	burned, err := s.repository.ConsumeImage(c, img.Id, time.Now().UTC())
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) {
+			s.logger.Error("cannot consume image view", "error", err)
+		}
+		return Image{}, err
+	}
+	return burned, nil
+}
+
+// PurgeExpired deletes expired and burned images together with their blobs,
+// derivatives and renditions and returns how many were deleted.
+func (s service) PurgeExpired(ctx context.Context) (int, error) {
+	purged := 0
+	for {
+		now := time.Now().UTC()
+		c, cancel := context.WithTimeout(ctx, s.timeout)
+		ids, err := s.repository.GetGoneImageIds(c, now, now.Add(-burnedRetention), reaperBatchSize)
+		cancel()
+		if err != nil {
+			s.logger.Error("cannot get expired images", "error", err)
+			return purged, err
+		}
+		for _, id := range ids {
+			if err := s.DeleteImage(ctx, id); err != nil {
+				if errors.Is(err, ErrImageNotFound) {
+					continue
+				}
+				return purged, err
+			}
+			purged++
+		}
+		if len(ids) < reaperBatchSize {
+			if purged > 0 {
+				s.logger.Info("purged expired images", "count", purged)
+			}
+			return purged, nil
+		}
+	}
+}
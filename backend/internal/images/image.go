@@ -0,0 +1,155 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrImageNotFound      = errors.New("image not found")
+	ErrUnsupportedFormat  = errors.New("unsupported image format")
+	ErrImageAccessDenied  = errors.New("image access denied")
+	ErrInvalidImageUpload = errors.New("invalid image upload")
+	ErrDerivativeNotFound = errors.New("derivative not found")
+	ErrTooManyPixels      = errors.New("image has too many pixels")
+)
+
+// allowedContentTypes maps the sniffed content types accepted for upload
+// to the file extension used in storage keys.
+var allowedContentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// originalKey addresses originals by the SHA-256 of their stored bytes so
+// that identical uploads share a single blob.
+func originalKey(sha256 string, contentType string) string {
+	return fmt.Sprintf("originals/%s.%s", sha256, allowedContentTypes[contentType])
+}
+
+// pendingKey is where an upload is streamed to before its hash, and so its
+// final key, is known.
+func pendingKey(id uuid.UUID) string {
+	return fmt.Sprintf("pending/%s", id)
+}
+
+type Image struct {
+	Id           uuid.UUID
+	OwnerId      uuid.UUID
+	StorageKey   string
+	ContentType  string
+	Size         int64
+	Width        int
+	Height       int
+	OriginalName string
+	CreatedAt    time.Time
+	Metadata     Metadata
+	Visibility   Visibility
+	// ExpiresAt is zero for images that do not expire.
+	ExpiresAt        time.Time
+	BurnAfterReading bool
+	// BurnedAt is when a burn after reading image was viewed, zero before.
+	BurnedAt time.Time
+}
+
+// Blob is a stored original, shared by every image with the same content and
+// removed from the storage once the last of them is deleted.
+type Blob struct {
+	StorageKey  string
+	ContentType string
+	Size        int64
+	RefCount    int
+	CreatedAt   time.Time
+}
+
+// Metadata is what is kept from the EXIF block of an upload. The block
+// itself, including GPS coordinates, is removed from the stored original
+// unless the owner asked to keep it.
+type Metadata struct {
+	CameraMake  string
+	CameraModel string
+	// TakenAt is zero when unknown.
+	TakenAt     time.Time
+	Orientation int
+	Stripped    bool
+}
+
+type UploadOptions struct {
+	KeepMetadata bool
+	// Visibility defaults to private.
+	Visibility Visibility
+	// TTL is how long the image is kept, zero keeps it until deleted.
+	TTL time.Duration
+	// BurnAfterReading deletes the image once anyone but its owner viewed
+	// it. No derivatives are generated for such images.
+	BurnAfterReading bool
+}
+
+// DerivativeSpec describes one of the fixed sizes generated for every
+// uploaded image.
+type DerivativeSpec struct {
+	Name   string
+	Width  int
+	Height int
+	Mode   fitMode
+}
+
+var DerivativeSpecs = []DerivativeSpec{
+	{Name: "thumb", Width: 150, Height: 150, Mode: fitCover},
+	{Name: "medium", Width: 640, Height: 640, Mode: fitContain},
+	{Name: "large", Width: 1280, Height: 1280, Mode: fitContain},
+}
+
+func derivativeKey(imageId uuid.UUID, name string, format outputFormat) string {
+	return fmt.Sprintf("derivatives/%s/%s.%s", imageId, name, format.extension())
+}
+
+type Derivative struct {
+	ImageId     uuid.UUID
+	Name        string
+	StorageKey  string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	CreatedAt   time.Time
+}
+
+type Repository interface {
+	// CreateImage inserts image and takes a reference on the blob under its
+	// storage key in one transaction. When the blob is new, store is called
+	// before the transaction commits to put its content in place.
+	CreateImage(ctx context.Context, image Image, store func(ctx context.Context) error) (Image, error)
+	GetImageById(ctx context.Context, id uuid.UUID) (Image, error)
+	GetImagesByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Image, error)
+	// GetImagesByIds returns the images that exist among ids, in no
+	// particular order.
+	GetImagesByIds(ctx context.Context, ids []uuid.UUID) ([]Image, error)
+	// GetPublicImages returns up to limit public images created before the
+	// cursor that are not gone at now, newest first.
+	GetPublicImages(ctx context.Context, before FeedCursor, now time.Time, limit int) ([]Image, error)
+	UpdateImageVisibility(ctx context.Context, id uuid.UUID, visibility Visibility) (Image, error)
+	// DeleteImage removes the image and drops its blob reference. When that
+	// was the last reference, release is called before the transaction
+	// commits to remove the blob content.
+	DeleteImage(ctx context.Context, id uuid.UUID, release func(ctx context.Context, blob Blob) error) error
+	// ConsumeImage burns a burn after reading image at now. It fails with
+	// ErrImageNotFound unless the image was neither burned nor expired yet,
+	// so only one caller can ever burn an image.
+	ConsumeImage(ctx context.Context, id uuid.UUID, now time.Time) (Image, error)
+	// GetGoneImageIds returns up to limit images that expired at now or were
+	// burned before burnedBefore.
+	GetGoneImageIds(ctx context.Context, now time.Time, burnedBefore time.Time, limit int) ([]uuid.UUID, error)
+	CreateDerivative(ctx context.Context, derivative Derivative) (Derivative, error)
+	GetDerivativesByImageIds(ctx context.Context, imageIds []uuid.UUID) ([]Derivative, error)
+	// CreateRendition records a rendition stored for an image. Recording the
+	// same storage key again is a no-op.
+	CreateRendition(ctx context.Context, rendition Rendition) error
+	GetRenditionsByImageId(ctx context.Context, imageId uuid.UUID) ([]Rendition, error)
+}
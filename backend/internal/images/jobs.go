@@ -0,0 +1,106 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"strings"
+	"time"
+)
+
+const (
+	JobGenerateDerivatives = "images.generate_derivatives"
+	JobPurgeExpired        = "images.purge_expired"
+	JobPurgePendingUploads = "images.purge_pending_uploads"
+)
+
+// pendingUploadRetention is how long a pending upload may sit in the storage
+// before it is considered abandoned. Uploads are moved out of pending/ as
+// soon as they are stored, whatever is left behind belongs to a crashed
+// upload.
+const pendingUploadRetention = 24 * time.Hour
+
+type derivativesPayload struct {
+	ImageId uuid.UUID `json:"image_id"`
+}
+
+func (s service) enqueueDerivatives(ctx context.Context, imageId uuid.UUID) error {
+	_, err := s.jobs.Enqueue(
+		ctx,
+		JobGenerateDerivatives,
+		derivativesPayload{ImageId: imageId},
+		jobs.EnqueueOptions{Key: "derivatives:" + imageId.String()},
+	)
+	if err != nil && !errors.Is(err, jobs.ErrDuplicateJob) {
+		return err
+	}
+	return nil
+}
+
+// PurgePendingUploads deletes the pending uploads abandoned by crashed or
+// interrupted uploads and returns how many were deleted.
+func (s service) PurgePendingUploads(ctx context.Context) (int, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	objects, err := s.storage.List(c, "pending/")
+	if err != nil {
+		s.logger.Error("cannot list pending uploads", "error", err)
+		return 0, err
+	}
+	cutoff := time.Now().UTC().Add(-pendingUploadRetention)
+	purged := 0
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, "pending/") || object.ModifiedAt.After(cutoff) {
+			continue
+		}
+		if err := s.storage.Delete(ctx, object.Key); err != nil {
+			s.logger.Error("cannot remove pending upload", "key", object.Key, "error", err)
+			return purged, err
+		}
+		purged++
+	}
+	if purged > 0 {
+		s.logger.Info("purged pending uploads", "count", purged)
+	}
+	return purged, nil
+}
+
+// RegisterJobs makes runner execute the images jobs and schedules the purge
+// of expired images by the cron expression purgeSchedule.
+func RegisterJobs(runner *jobs.Runner, service Service, purgeSchedule string) error {
+	runner.Handle(JobGenerateDerivatives, func(ctx context.Context, job jobs.Job) error {
+		payload, err := jobs.DecodePayload[derivativesPayload](job)
+		if err != nil {
+			return err
+		}
+		img, err := service.GetImageById(ctx, payload.ImageId)
+		if err != nil {
+			// the image was deleted before its derivatives were made
+			if errors.Is(err, ErrImageNotFound) {
+				return nil
+			}
+			return err
+		}
+		if _, err := service.GenerateDerivatives(ctx, img); err != nil {
+			if errors.Is(err, ErrUnsupportedFormat) {
+				return jobs.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+	runner.Handle(JobPurgeExpired, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.PurgeExpired(ctx)
+		return err
+	})
+	runner.Handle(JobPurgePendingUploads, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.PurgePendingUploads(ctx)
+		return err
+	})
+	if err := runner.Schedule(JobPurgeExpired, purgeSchedule, JobPurgeExpired, nil); err != nil {
+		return fmt.Errorf("invalid purge schedule: %w", err)
+	}
+	return runner.Schedule(JobPurgePendingUploads, "@hourly", JobPurgePendingUploads, nil)
+}
@@ -0,0 +1,75 @@
+package images
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// labelled returns a 3x2 image whose pixels are labelled a to f row by row:
+//
+//	a b c
+//	d e f
+func labelled(rect image.Rectangle) image.Image {
+	img := image.NewGray(rect)
+	label := byte('a')
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetGray(x, y, color.Gray{Y: label})
+			label++
+		}
+	}
+	return img
+}
+
+// labels reads the labels of img row by row.
+func labels(img image.Image) []string {
+	bounds := img.Bounds()
+	var rows []string
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var row []byte
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			row = append(row, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{orientation: 0, want: []string{"abc", "def"}},
+		{orientation: 1, want: []string{"abc", "def"}},
+		{orientation: 2, want: []string{"cba", "fed"}},
+		{orientation: 3, want: []string{"fed", "cba"}},
+		{orientation: 4, want: []string{"def", "abc"}},
+		{orientation: 5, want: []string{"ad", "be", "cf"}},
+		{orientation: 6, want: []string{"da", "eb", "fc"}},
+		{orientation: 7, want: []string{"fc", "eb", "da"}},
+		{orientation: 8, want: []string{"cf", "be", "ad"}},
+		{orientation: 9, want: []string{"abc", "def"}},
+	}
+	rects := map[string]image.Rectangle{
+		"origin": image.Rect(0, 0, 3, 2),
+		"offset": image.Rect(5, 7, 8, 9),
+	}
+	for name, rect := range rects {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d", name, tt.orientation), func(t *testing.T) {
+				got := labels(orient(labelled(rect), tt.orientation))
+				if len(got) != len(tt.want) {
+					t.Fatalf("orientation %d: got %q, want %q", tt.orientation, got, tt.want)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("orientation %d: got %q, want %q", tt.orientation, got, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
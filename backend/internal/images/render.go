@@ -0,0 +1,272 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/storage"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const renderDefaultQuality = 80
+
+// renderQualities are the only jpeg qualities renders are encoded with,
+// requested ones are snapped to the nearest of them.
+var renderQualities = []int{40, 60, renderDefaultQuality, 90}
+
+var ErrInvalidRenderParams = errors.New("invalid render parameters")
+
+var renderFormats = map[string]outputFormat{
+	string(formatJPEG): formatJPEG,
+	string(formatPNG):  formatPNG,
+	string(formatWebP): formatWebP,
+}
+
+var renderFitModes = map[string]fitMode{
+	string(fitCover):   fitCover,
+	string(fitContain): fitContain,
+}
+
+// RenderLimits bounds the parameter space of on the fly renders so that
+// clients cannot fill the cache with arbitrary variants of the same image.
+type RenderLimits struct {
+	MaxDimension  int
+	DimensionStep int
+}
+
+type RenderParams struct {
+	Width   int
+	Height  int
+	Fit     fitMode
+	Format  outputFormat
+	Quality int
+}
+
+type Rendition struct {
+	ImageId     uuid.UUID
+	StorageKey  string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+}
+
+// ParseRenderParams reads w, h, fit, fmt and q from query and snaps them to
+// the canonical values allowed by the render limits: dimensions are rounded
+// up to the next step and quality to the nearest allowed one. Callers should
+// redirect to Query() when it differs from the request so that every variant
+// has exactly one URL.
+func (s service) ParseRenderParams(query url.Values, img Image) (RenderParams, error) {
+	return parseRenderParams(query, s.renderLimits, img)
+}
+
+func parseRenderParams(query url.Values, limits RenderLimits, img Image) (RenderParams, error) {
+	params := RenderParams{
+		Fit:     fitContain,
+		Format:  defaultRenderFormat(img.ContentType),
+		Quality: renderDefaultQuality,
+	}
+	var err error
+	if params.Width, err = parseDimension(query.Get("w"), limits); err != nil {
+		return RenderParams{}, fmt.Errorf("%w: w %w", ErrInvalidRenderParams, err)
+	}
+	if params.Height, err = parseDimension(query.Get("h"), limits); err != nil {
+		return RenderParams{}, fmt.Errorf("%w: h %w", ErrInvalidRenderParams, err)
+	}
+	if raw := query.Get("fit"); raw != "" {
+		mode, ok := renderFitModes[raw]
+		if !ok {
+			return RenderParams{}, fmt.Errorf("%w: fit must be cover or contain", ErrInvalidRenderParams)
+		}
+		params.Fit = mode
+	}
+	if raw := query.Get("fmt"); raw != "" {
+		format, ok := renderFormats[raw]
+		if !ok {
+			return RenderParams{}, fmt.Errorf("%w: fmt must be webp, jpeg or png", ErrInvalidRenderParams)
+		}
+		params.Format = format
+	}
+	if raw := query.Get("q"); raw != "" {
+		quality, err := strconv.Atoi(raw)
+		if err != nil || quality < 1 || quality > 100 {
+			return RenderParams{}, fmt.Errorf("%w: q must be between 1 and 100", ErrInvalidRenderParams)
+		}
+		params.Quality = nearestQuality(quality)
+	}
+	if params.Format != formatJPEG {
+		// png and webp are encoded losslessly
+		params.Quality = 0
+	}
+	if params.Fit == fitCover && (params.Width == 0 || params.Height == 0) {
+		params.Fit = fitContain
+	}
+	return params, nil
+}
+
+func nearestQuality(quality int) int {
+	nearest := renderQualities[0]
+	for _, q := range renderQualities[1:] {
+		if abs(quality-q) <= abs(quality-nearest) {
+			nearest = q
+		}
+	}
+	return nearest
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func parseDimension(raw string, limits RenderLimits) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 || value > limits.MaxDimension {
+		return 0, fmt.Errorf("must be between 1 and %d", limits.MaxDimension)
+	}
+	if step := limits.DimensionStep; step > 1 {
+		value = min((value+step-1)/step*step, limits.MaxDimension)
+	}
+	return value, nil
+}
+
+func defaultRenderFormat(contentType string) outputFormat {
+	switch contentType {
+	case "image/png", "image/gif":
+		return formatPNG
+	case "image/webp":
+		return formatWebP
+	default:
+		return formatJPEG
+	}
+}
+
+// Query returns the canonical query string of params.
+func (p RenderParams) Query() url.Values {
+	query := url.Values{}
+	if p.Width > 0 {
+		query.Set("w", strconv.Itoa(p.Width))
+	}
+	if p.Height > 0 {
+		query.Set("h", strconv.Itoa(p.Height))
+	}
+	query.Set("fit", string(p.Fit))
+	query.Set("fmt", string(p.Format))
+	if p.Quality > 0 {
+		query.Set("q", strconv.Itoa(p.Quality))
+	}
+	return query
+}
+
+func (p RenderParams) cacheKey(imageId uuid.UUID) string {
+	sum := sha256.Sum256([]byte(p.Query().Encode()))
+	return fmt.Sprintf("%s%s.%s", renderPrefix(imageId), hex.EncodeToString(sum[:16]), p.Format.extension())
+}
+
+func renderPrefix(imageId uuid.UUID) string {
+	return fmt.Sprintf("renders/%s/", imageId)
+}
+
+// Render returns the cached rendition of img for params, rendering and
+// storing it first if it does not exist yet. Concurrent requests for the
+// same missing rendition share a single render. Burn after reading images
+// are never rendered, renditions would outlive them.
+func (s service) Render(ctx context.Context, img Image, params RenderParams) (Rendition, error) {
+	if img.BurnAfterReading {
+		return Rendition{}, ErrImageNotFound
+	}
+	key := params.cacheKey(img.Id)
+	object, err := s.storage.Stat(ctx, key)
+	if err == nil {
+		return Rendition{
+			ImageId:     img.Id,
+			StorageKey:  key,
+			ContentType: params.Format.contentType(),
+			Size:        object.Size,
+			CreatedAt:   object.ModifiedAt,
+		}, nil
+	}
+	if !errors.Is(err, storage.ErrObjectNotFound) {
+		s.logger.Error("cannot stat rendition", "key", key, "error", err)
+		return Rendition{}, err
+	}
+
+	// the render is shared, one caller going away must not fail the others
+	rendered, err, _ := s.renders.Do(key, func() (any, error) {
+		return s.render(context.WithoutCancel(ctx), img, params, key)
+	})
+	if err != nil {
+		return Rendition{}, err
+	}
+	return rendered.(Rendition), nil
+}
+
+func (s service) render(ctx context.Context, img Image, params RenderParams, key string) (Rendition, error) {
+	original, err := s.decodeOriginal(ctx, img)
+	if err != nil {
+		s.logger.Error("cannot decode original image", "image_id", img.Id, "error", err)
+		return Rendition{}, err
+	}
+	// a missing dimension is bounded by the limit too, so that no render is
+	// ever larger than MaxDimension on either side
+	width, height := params.Width, params.Height
+	if width == 0 {
+		width = s.renderLimits.MaxDimension
+	}
+	if height == 0 {
+		height = s.renderLimits.MaxDimension
+	}
+	rendered := resize(original, width, height, params.Fit)
+	content, err := encode(rendered, params.Format, params.Quality)
+	if err != nil {
+		s.logger.Error("cannot encode rendition", "image_id", img.Id, "error", err)
+		return Rendition{}, err
+	}
+	rendition := Rendition{
+		ImageId:     img.Id,
+		StorageKey:  key,
+		ContentType: params.Format.contentType(),
+		Size:        int64(len(content)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if _, err := s.storage.Put(ctx, key, bytes.NewReader(content), storage.PutOptions{
+		ContentType: rendition.ContentType,
+		Size:        rendition.Size,
+	}); err != nil {
+		s.logger.Error("cannot store rendition", "key", key, "error", err)
+		return Rendition{}, err
+	}
+	// renditions are recorded so that DeleteImage finds them without listing
+	// the storage; if the image is gone by now the insert fails and the
+	// object must not be left behind
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.CreateRendition(c, rendition); err != nil {
+		s.logger.Error("cannot record rendition", "key", key, "error", err)
+		if err := s.storage.Delete(ctx, key); err != nil {
+			s.logger.Error("cannot remove stored object", "key", key, "error", err)
+		}
+		return Rendition{}, err
+	}
+	return rendition, nil
+}
+
+func (s service) OpenRendition(ctx context.Context, rendition Rendition) (io.ReadCloser, error) {
+	file, _, err := s.storage.Get(ctx, rendition.StorageKey)
+	if err != nil {
+		s.logger.Error("cannot open stored rendition", "error", err)
+		return nil, err
+	}
+	return file, nil
+}
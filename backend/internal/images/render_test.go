@@ -0,0 +1,112 @@
+package images
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseRenderParams(t *testing.T) {
+	limits := RenderLimits{MaxDimension: 2048, DimensionStep: 256}
+	jpeg := Image{ContentType: "image/jpeg"}
+	png := Image{ContentType: "image/png"}
+	tests := []struct {
+		name    string
+		query   string
+		img     Image
+		want    RenderParams
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			img:  jpeg,
+			want: RenderParams{Fit: fitContain, Format: formatJPEG, Quality: renderDefaultQuality},
+		},
+		{
+			name: "default format of png",
+			img:  png,
+			want: RenderParams{Fit: fitContain, Format: formatPNG},
+		},
+		{
+			name:  "dimensions rounded up to the step",
+			img:   jpeg,
+			query: "w=1&h=257",
+			want:  RenderParams{Width: 256, Height: 512, Fit: fitContain, Format: formatJPEG, Quality: 80},
+		},
+		{
+			name:  "dimensions on the step kept",
+			img:   jpeg,
+			query: "w=512&h=2048&fit=cover",
+			want:  RenderParams{Width: 512, Height: 2048, Fit: fitCover, Format: formatJPEG, Quality: 80},
+		},
+		{
+			name:  "cover needs both dimensions",
+			img:   jpeg,
+			query: "w=300&fit=cover",
+			want:  RenderParams{Width: 512, Fit: fitContain, Format: formatJPEG, Quality: 80},
+		},
+		{
+			name:  "quality snapped down",
+			img:   jpeg,
+			query: "q=1",
+			want:  RenderParams{Fit: fitContain, Format: formatJPEG, Quality: 40},
+		},
+		{
+			name:  "quality snapped to nearest",
+			img:   jpeg,
+			query: "q=73",
+			want:  RenderParams{Fit: fitContain, Format: formatJPEG, Quality: 80},
+		},
+		{
+			name:  "quality tie goes up",
+			img:   jpeg,
+			query: "q=70",
+			want:  RenderParams{Fit: fitContain, Format: formatJPEG, Quality: 80},
+		},
+		{
+			name:  "quality snapped up",
+			img:   jpeg,
+			query: "q=100",
+			want:  RenderParams{Fit: fitContain, Format: formatJPEG, Quality: 90},
+		},
+		{
+			name:  "quality dropped for lossless formats",
+			img:   jpeg,
+			query: "fmt=webp&q=60",
+			want:  RenderParams{Fit: fitContain, Format: formatWebP},
+		},
+		{name: "width too large", img: jpeg, query: "w=2049", wantErr: true},
+		{name: "height not a number", img: jpeg, query: "h=big", wantErr: true},
+		{name: "zero width", img: jpeg, query: "w=0", wantErr: true},
+		{name: "unknown fit", img: jpeg, query: "fit=fill", wantErr: true},
+		{name: "unknown format", img: jpeg, query: "fmt=gif", wantErr: true},
+		{name: "quality out of range", img: jpeg, query: "q=101", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseRenderParams(query, limits, tt.img)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRenderParams) {
+					t.Fatalf("err = %v, want %v", err, ErrInvalidRenderParams)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("params = %+v, want %+v", got, tt.want)
+			}
+
+			// the canonical query parses to itself
+			again, err := parseRenderParams(got.Query(), limits, tt.img)
+			if err != nil || again != got {
+				t.Fatalf("canonical query %q parsed to %+v, %v", got.Query().Encode(), again, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,636 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "images.repo.pg"
+
+const imageColumns = `id, owner_id, storage_key, content_type, size, width, height, original_name, created_at,
+	camera_make, camera_model, taken_at, orientation, metadata_stripped, visibility,
+	expires_at, burn_after_reading, burned_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgImage struct {
+	id           pgtype.UUID
+	ownerId      pgtype.UUID
+	storageKey   string
+	contentType  string
+	size         int64
+	width        int32
+	height       int32
+	originalName string
+	createdAt    pgtype.Timestamp
+	cameraMake   string
+	cameraModel  string
+	takenAt      pgtype.Timestamp
+	orientation  int16
+	stripped     bool
+	visibility   string
+	expiresAt    pgtype.Timestamp
+	burn         bool
+	burnedAt     pgtype.Timestamp
+}
+
+func (i *pgImage) scanTargets() []any {
+	return []any{
+		&i.id,
+		&i.ownerId,
+		&i.storageKey,
+		&i.contentType,
+		&i.size,
+		&i.width,
+		&i.height,
+		&i.originalName,
+		&i.createdAt,
+		&i.cameraMake,
+		&i.cameraModel,
+		&i.takenAt,
+		&i.orientation,
+		&i.stripped,
+		&i.visibility,
+		&i.expiresAt,
+		&i.burn,
+		&i.burnedAt,
+	}
+}
+
+func fromPGImage(image pgImage) (Image, error) {
+	id, err := uuid.FromBytes(image.id.Bytes[:])
+	if err != nil {
+		return Image{}, err
+	}
+	ownerId, err := uuid.FromBytes(image.ownerId.Bytes[:])
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{
+		Id:           id,
+		OwnerId:      ownerId,
+		StorageKey:   image.storageKey,
+		ContentType:  image.contentType,
+		Size:         image.size,
+		Width:        int(image.width),
+		Height:       int(image.height),
+		OriginalName: image.originalName,
+		CreatedAt:    image.createdAt.Time,
+		Metadata: Metadata{
+			CameraMake:  image.cameraMake,
+			CameraModel: image.cameraModel,
+			TakenAt:     image.takenAt.Time,
+			Orientation: int(image.orientation),
+			Stripped:    image.stripped,
+		},
+		Visibility:       Visibility(image.visibility),
+		ExpiresAt:        image.expiresAt.Time,
+		BurnAfterReading: image.burn,
+		BurnedAt:         image.burnedAt.Time,
+	}, nil
+}
+
+func toPGImage(image Image) pgImage {
+	return pgImage{
+		id:           pgtype.UUID{Bytes: [16]byte(image.Id.Bytes()), Valid: true},
+		ownerId:      pgtype.UUID{Bytes: [16]byte(image.OwnerId.Bytes()), Valid: true},
+		storageKey:   image.StorageKey,
+		contentType:  image.ContentType,
+		size:         image.Size,
+		width:        int32(image.Width),
+		height:       int32(image.Height),
+		originalName: image.OriginalName,
+		createdAt:    pgtype.Timestamp{Time: image.CreatedAt.UTC(), Valid: true},
+		cameraMake:   image.Metadata.CameraMake,
+		cameraModel:  image.Metadata.CameraModel,
+		takenAt: pgtype.Timestamp{
+			Time:  image.Metadata.TakenAt,
+			Valid: !image.Metadata.TakenAt.IsZero(),
+		},
+		orientation: int16(image.Metadata.Orientation),
+		stripped:    image.Metadata.Stripped,
+		visibility:  string(image.Visibility),
+		expiresAt: pgtype.Timestamp{
+			Time:  image.ExpiresAt.UTC(),
+			Valid: !image.ExpiresAt.IsZero(),
+		},
+		burn: image.BurnAfterReading,
+		burnedAt: pgtype.Timestamp{
+			Time:  image.BurnedAt.UTC(),
+			Valid: !image.BurnedAt.IsZero(),
+		},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateImage(
+	ctx context.Context,
+	image Image,
+	store func(ctx context.Context) error,
+) (Image, error) {
+	const op = postgresRepositorySource + ".CreateImage"
+	blobQuery := `
+INSERT INTO image_blobs (storage_key, content_type, size, ref_count, created_at)
+	VALUES ($1, $2, $3, 1, $4)
+	ON CONFLICT (storage_key) DO UPDATE SET ref_count = image_blobs.ref_count + 1
+	RETURNING ref_count`
+	query := `
+INSERT INTO images (` + imageColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+	RETURNING ` + imageColumns
+
+	toCreate := toPGImage(image)
+	var created pgImage
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var refCount int32
+		if err := tx.QueryRow(
+			ctx,
+			blobQuery,
+			toCreate.storageKey,
+			toCreate.contentType,
+			toCreate.size,
+			toCreate.createdAt,
+		).Scan(&refCount); err != nil {
+			return err
+		}
+		// blobs without references are deleted, so a count of one means the
+		// row was just inserted
+		if refCount == 1 {
+			if err := store(ctx); err != nil {
+				return err
+			}
+		}
+		return tx.QueryRow(
+			ctx,
+			query,
+			toCreate.id,
+			toCreate.ownerId,
+			toCreate.storageKey,
+			toCreate.contentType,
+			toCreate.size,
+			toCreate.width,
+			toCreate.height,
+			toCreate.originalName,
+			toCreate.createdAt,
+			toCreate.cameraMake,
+			toCreate.cameraModel,
+			toCreate.takenAt,
+			toCreate.orientation,
+			toCreate.stripped,
+			toCreate.visibility,
+			toCreate.expiresAt,
+			toCreate.burn,
+			toCreate.burnedAt,
+		).Scan(created.scanTargets()...)
+	})
+	if err != nil {
+		return Image{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGImage(created)
+}
+
+func (r *postgresRepository) GetImageById(ctx context.Context, id uuid.UUID) (Image, error) {
+	const op = postgresRepositorySource + ".GetImageById"
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = $1`
+	var image pgImage
+	if err := r.db.QueryRow(ctx, query, id).Scan(image.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Image{}, ErrImageNotFound
+		}
+		return Image{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGImage(image)
+}
+
+func (r *postgresRepository) GetImagesByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Image, error) {
+	const op = postgresRepositorySource + ".GetImagesByOwnerId"
+	query := `SELECT ` + imageColumns + ` FROM images WHERE owner_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, ownerId)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var images []Image
+	for rows.Next() {
+		var pgImage pgImage
+		if err := rows.Scan(pgImage.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		image, err := fromPGImage(pgImage)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return images, nil
+}
+
+func (r *postgresRepository) GetImagesByIds(ctx context.Context, ids []uuid.UUID) ([]Image, error) {
+	const op = postgresRepositorySource + ".GetImagesByIds"
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	stringIds := make([]string, len(ids))
+	for i, id := range ids {
+		stringIds[i] = id.String()
+	}
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = ANY($1::uuid[])`
+	rows, err := r.db.Query(ctx, query, stringIds)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var images []Image
+	for rows.Next() {
+		var pgImage pgImage
+		if err := rows.Scan(pgImage.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		image, err := fromPGImage(pgImage)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return images, nil
+}
+
+func (r *postgresRepository) GetPublicImages(
+	ctx context.Context,
+	before FeedCursor,
+	now time.Time,
+	limit int,
+) ([]Image, error) {
+	const op = postgresRepositorySource + ".GetPublicImages"
+	query := `
+SELECT ` + imageColumns + ` FROM images
+	WHERE visibility = 'public' AND ($1::timestamp IS NULL OR (created_at, id) < ($1, $2))
+		AND burned_at IS NULL AND (expires_at IS NULL OR expires_at > $3)
+	ORDER BY created_at DESC, id DESC
+	LIMIT $4`
+	createdAt := pgtype.Timestamp{Time: before.CreatedAt.UTC(), Valid: !before.CreatedAt.IsZero()}
+	rows, err := r.db.Query(
+		ctx,
+		query,
+		createdAt,
+		before.Id,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var images []Image
+	for rows.Next() {
+		var pgImage pgImage
+		if err := rows.Scan(pgImage.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		image, err := fromPGImage(pgImage)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return images, nil
+}
+
+func (r *postgresRepository) UpdateImageVisibility(
+	ctx context.Context,
+	id uuid.UUID,
+	visibility Visibility,
+) (Image, error) {
+	const op = postgresRepositorySource + ".UpdateImageVisibility"
+	query := `UPDATE images SET visibility = $2 WHERE id = $1 RETURNING ` + imageColumns
+	var image pgImage
+	if err := r.db.QueryRow(ctx, query, id, string(visibility)).Scan(image.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Image{}, ErrImageNotFound
+		}
+		return Image{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGImage(image)
+}
+
+func (r *postgresRepository) ConsumeImage(ctx context.Context, id uuid.UUID, now time.Time) (Image, error) {
+	const op = postgresRepositorySource + ".ConsumeImage"
+	query := `
+UPDATE images SET burned_at = $2
+	WHERE id = $1
+		AND burn_after_reading
+		AND burned_at IS NULL
+		AND (expires_at IS NULL OR expires_at > $2)
+	RETURNING ` + imageColumns
+
+	var image pgImage
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		id,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	).Scan(image.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Image{}, ErrImageNotFound
+		}
+		return Image{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGImage(image)
+}
+
+func (r *postgresRepository) GetGoneImageIds(
+	ctx context.Context,
+	now time.Time,
+	burnedBefore time.Time,
+	limit int,
+) ([]uuid.UUID, error) {
+	const op = postgresRepositorySource + ".GetGoneImageIds"
+	query := `
+SELECT id FROM images WHERE expires_at <= $1
+UNION
+SELECT id FROM images WHERE burned_at <= $2
+LIMIT $3`
+	rows, err := r.db.Query(
+		ctx,
+		query,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		pgtype.Timestamp{Time: burnedBefore.UTC(), Valid: true},
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id pgtype.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		ids = append(ids, uuid.UUID(id.Bytes))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return ids, nil
+}
+
+func (r *postgresRepository) DeleteImage(
+	ctx context.Context,
+	id uuid.UUID,
+	release func(ctx context.Context, blob Blob) error,
+) error {
+	const op = postgresRepositorySource + ".DeleteImage"
+	query := `DELETE FROM images WHERE id = $1 RETURNING storage_key`
+	blobQuery := `
+UPDATE image_blobs SET ref_count = ref_count - 1
+	WHERE storage_key = $1
+	RETURNING storage_key, content_type, size, ref_count, created_at`
+	deleteBlobQuery := `DELETE FROM image_blobs WHERE storage_key = $1`
+
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var storageKey string
+		if err := tx.QueryRow(ctx, query, id).Scan(&storageKey); err != nil {
+			return err
+		}
+		var blob Blob
+		var refCount int32
+		var createdAt pgtype.Timestamp
+		if err := tx.QueryRow(ctx, blobQuery, storageKey).Scan(
+			&blob.StorageKey,
+			&blob.ContentType,
+			&blob.Size,
+			&refCount,
+			&createdAt,
+		); err != nil {
+			return err
+		}
+		blob.RefCount = int(refCount)
+		blob.CreatedAt = createdAt.Time
+		if blob.RefCount > 0 {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, deleteBlobQuery, storageKey); err != nil {
+			return err
+		}
+		return release(ctx, blob)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrImageNotFound
+		}
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+const derivativeColumns = `image_id, name, storage_key, content_type, size, width, height, created_at`
+
+type pgDerivative struct {
+	imageId     pgtype.UUID
+	name        string
+	storageKey  string
+	contentType string
+	size        int64
+	width       int32
+	height      int32
+	createdAt   pgtype.Timestamp
+}
+
+func (d *pgDerivative) scanTargets() []any {
+	return []any{
+		&d.imageId,
+		&d.name,
+		&d.storageKey,
+		&d.contentType,
+		&d.size,
+		&d.width,
+		&d.height,
+		&d.createdAt,
+	}
+}
+
+func fromPGDerivative(derivative pgDerivative) (Derivative, error) {
+	imageId, err := uuid.FromBytes(derivative.imageId.Bytes[:])
+	if err != nil {
+		return Derivative{}, err
+	}
+	return Derivative{
+		ImageId:     imageId,
+		Name:        derivative.name,
+		StorageKey:  derivative.storageKey,
+		ContentType: derivative.contentType,
+		Size:        derivative.size,
+		Width:       int(derivative.width),
+		Height:      int(derivative.height),
+		CreatedAt:   derivative.createdAt.Time,
+	}, nil
+}
+
+func (r *postgresRepository) CreateDerivative(ctx context.Context, derivative Derivative) (Derivative, error) {
+	const op = postgresRepositorySource + ".CreateDerivative"
+	query := `
+INSERT INTO image_derivatives (` + derivativeColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (image_id, name) DO UPDATE SET
+		storage_key = EXCLUDED.storage_key,
+		content_type = EXCLUDED.content_type,
+		size = EXCLUDED.size,
+		width = EXCLUDED.width,
+		height = EXCLUDED.height,
+		created_at = EXCLUDED.created_at
+	RETURNING ` + derivativeColumns
+
+	var created pgDerivative
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		derivative.ImageId,
+		derivative.Name,
+		derivative.StorageKey,
+		derivative.ContentType,
+		derivative.Size,
+		int32(derivative.Width),
+		int32(derivative.Height),
+		pgtype.Timestamp{Time: derivative.CreatedAt.UTC(), Valid: true},
+	).Scan(created.scanTargets()...); err != nil {
+		return Derivative{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGDerivative(created)
+}
+
+func (r *postgresRepository) GetDerivativesByImageIds(ctx context.Context, imageIds []uuid.UUID) ([]Derivative, error) {
+	const op = postgresRepositorySource + ".GetDerivativesByImageIds"
+	if len(imageIds) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(imageIds))
+	for i, id := range imageIds {
+		ids[i] = id.String()
+	}
+	query := `SELECT ` + derivativeColumns + ` FROM image_derivatives WHERE image_id = ANY($1::uuid[])`
+	rows, err := r.db.Query(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var derivatives []Derivative
+	for rows.Next() {
+		var pgDerivative pgDerivative
+		if err := rows.Scan(pgDerivative.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		derivative, err := fromPGDerivative(pgDerivative)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		derivatives = append(derivatives, derivative)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return derivatives, nil
+}
+
+const renditionColumns = `image_id, storage_key, content_type, size, created_at`
+
+type pgRendition struct {
+	imageId     pgtype.UUID
+	storageKey  string
+	contentType string
+	size        int64
+	createdAt   pgtype.Timestamp
+}
+
+func (r *pgRendition) scanTargets() []any {
+	return []any{
+		&r.imageId,
+		&r.storageKey,
+		&r.contentType,
+		&r.size,
+		&r.createdAt,
+	}
+}
+
+func fromPGRendition(rendition pgRendition) (Rendition, error) {
+	imageId, err := uuid.FromBytes(rendition.imageId.Bytes[:])
+	if err != nil {
+		return Rendition{}, err
+	}
+	return Rendition{
+		ImageId:     imageId,
+		StorageKey:  rendition.storageKey,
+		ContentType: rendition.contentType,
+		Size:        rendition.size,
+		CreatedAt:   rendition.createdAt.Time,
+	}, nil
+}
+
+func (r *postgresRepository) CreateRendition(ctx context.Context, rendition Rendition) error {
+	const op = postgresRepositorySource + ".CreateRendition"
+	query := `
+INSERT INTO image_renditions (` + renditionColumns + `)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (storage_key) DO NOTHING`
+
+	if _, err := r.db.Exec(
+		ctx,
+		query,
+		rendition.ImageId,
+		rendition.StorageKey,
+		rendition.ContentType,
+		rendition.Size,
+		pgtype.Timestamp{Time: rendition.CreatedAt.UTC(), Valid: true},
+	); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) GetRenditionsByImageId(ctx context.Context, imageId uuid.UUID) ([]Rendition, error) {
+	const op = postgresRepositorySource + ".GetRenditionsByImageId"
+	query := `SELECT ` + renditionColumns + ` FROM image_renditions WHERE image_id = $1`
+	rows, err := r.db.Query(ctx, query, imageId)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var renditions []Rendition
+	for rows.Next() {
+		var pgRendition pgRendition
+		if err := rows.Scan(pgRendition.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		rendition, err := fromPGRendition(pgRendition)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		renditions = append(renditions, rendition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return renditions, nil
+}
@@ -0,0 +1,113 @@
+package images
+
+import (
+	"bytes"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+type fitMode string
+
+const (
+	// fitContain scales the image down to fit into the box keeping its
+	// aspect ratio.
+	fitContain fitMode = "contain"
+	// fitCover scales the image to fill the whole box and crops whatever
+	// overflows around the center.
+	fitCover fitMode = "cover"
+)
+
+// resize never upscales: boxes larger than the source produce a copy of the
+// source, cropped to the box aspect ratio for fitCover.
+func resize(src image.Image, width, height int, mode fitMode) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if width <= 0 {
+		width = srcW
+	}
+	if height <= 0 {
+		height = srcH
+	}
+
+	srcRect := bounds
+	var dstW, dstH int
+	switch mode {
+	case fitCover:
+		// crop the source to the box aspect ratio first
+		if srcW*height > srcH*width {
+			cropW := srcH * width / height
+			x := bounds.Min.X + (srcW-cropW)/2
+			srcRect = image.Rect(x, bounds.Min.Y, x+cropW, bounds.Max.Y)
+		} else {
+			cropH := srcW * height / width
+			y := bounds.Min.Y + (srcH-cropH)/2
+			srcRect = image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropH)
+		}
+		dstW, dstH = width, height
+		if srcRect.Dx() < width {
+			dstW, dstH = srcRect.Dx(), srcRect.Dy()
+		}
+	default:
+		dstW, dstH = srcW, srcH
+		if dstW > width {
+			dstH = dstH * width / dstW
+			dstW = width
+		}
+		if dstH > height {
+			dstW = dstW * height / dstH
+			dstH = height
+		}
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
+
+// isOpaque reports whether img has no transparent pixels, in which case it
+// can be stored as JPEG without losing anything but compression artifacts.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+type outputFormat string
+
+const (
+	formatJPEG outputFormat = "jpeg"
+	formatPNG  outputFormat = "png"
+	// formatWebP is always encoded losslessly, there is no pure Go lossy
+	// webp encoder.
+	formatWebP outputFormat = "webp"
+)
+
+func (f outputFormat) contentType() string {
+	return "image/" + string(f)
+}
+
+func (f outputFormat) extension() string {
+	return allowedContentTypes[f.contentType()]
+}
+
+func encode(img image.Image, format outputFormat, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case formatPNG:
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img)
+	case formatWebP:
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
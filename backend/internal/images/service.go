@@ -0,0 +1,336 @@
+package images
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"github.com/plinkplenk/img-share/internal/storage"
+	"github.com/plinkplenk/img-share/pkg/exif"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+const (
+	sniffLen    = 512
+	MaxFeedSize = 100
+)
+
+type Service interface {
+	Upload(ctx context.Context, ownerId uuid.UUID, originalName string, data io.Reader, opts UploadOptions) (Image, error)
+	// ValidateUploadOptions fails with the error Upload would return for
+	// opts, for callers that receive the image data later.
+	ValidateUploadOptions(opts UploadOptions) error
+	GetImageById(ctx context.Context, id uuid.UUID) (Image, error)
+	GetImagesByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Image, error)
+	GetImagesByIds(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]Image, error)
+	GetPublicImages(ctx context.Context, before FeedCursor, limit int) ([]Image, error)
+	SetVisibility(ctx context.Context, id uuid.UUID, visibility Visibility) (Image, error)
+	Authorize(viewerId uuid.NullUUID, resource Resource, action Action) error
+	ConsumeView(ctx context.Context, viewerId uuid.NullUUID, image Image) (Image, error)
+	PurgeExpired(ctx context.Context) (int, error)
+	PurgePendingUploads(ctx context.Context) (int, error)
+	Open(ctx context.Context, image Image) (io.ReadCloser, error)
+	PresignedURL(ctx context.Context, storageKey string) (string, error)
+	GenerateDerivatives(ctx context.Context, image Image) ([]Derivative, error)
+	GetDerivatives(ctx context.Context, imageIds []uuid.UUID) (map[uuid.UUID][]Derivative, error)
+	GetDerivative(ctx context.Context, imageId uuid.UUID, name string) (Derivative, error)
+	OpenDerivative(ctx context.Context, derivative Derivative) (io.ReadCloser, error)
+	ParseRenderParams(query url.Values, image Image) (RenderParams, error)
+	Render(ctx context.Context, image Image, params RenderParams) (Rendition, error)
+	OpenRendition(ctx context.Context, rendition Rendition) (io.ReadCloser, error)
+	DeleteImage(ctx context.Context, id uuid.UUID) error
+}
+
+type service struct {
+	repository    Repository
+	storage       storage.Storage
+	jobs          jobs.Service
+	presignExpiry time.Duration
+	renderLimits  RenderLimits
+	renders       *singleflight.Group
+	maxPixels     int64
+	maxTTL        time.Duration
+	timeout       time.Duration
+	logger        *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	storage storage.Storage,
+	jobsService jobs.Service,
+	presignExpiry time.Duration,
+	renderLimits RenderLimits,
+	maxPixels int64,
+	maxTTL time.Duration,
+	timeout time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		repository:    repository,
+		storage:       storage,
+		jobs:          jobsService,
+		presignExpiry: presignExpiry,
+		renderLimits:  renderLimits,
+		renders:       &singleflight.Group{},
+		maxPixels:     maxPixels,
+		maxTTL:        maxTTL,
+		timeout:       timeout,
+		logger:        logger,
+	}
+}
+
+// sniff detects the content type of the upload from its leading bytes,
+// ignoring whatever the client claims in the file name or part headers.
+func (s service) sniff(data *bufio.Reader) (string, error) {
+	head, err := data.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("%w: %w", ErrInvalidImageUpload, err)
+	}
+	contentType := http.DetectContentType(head)
+	if _, ok := allowedContentTypes[contentType]; !ok {
+		return "", ErrUnsupportedFormat
+	}
+	return contentType, nil
+}
+
+func (s service) ValidateUploadOptions(opts UploadOptions) error {
+	if _, err := ParseVisibility(string(opts.Visibility)); err != nil {
+		return err
+	}
+	if opts.TTL < 0 || opts.TTL > s.maxTTL {
+		return fmt.Errorf("%w: ttl must be between 0 and %s", ErrInvalidTTL, s.maxTTL)
+	}
+	return nil
+}
+
+func (s service) Upload(
+	ctx context.Context,
+	ownerId uuid.UUID,
+	originalName string,
+	data io.Reader,
+	opts UploadOptions,
+) (Image, error) {
+	if err := s.ValidateUploadOptions(opts); err != nil {
+		return Image{}, err
+	}
+	visibility, _ := ParseVisibility(string(opts.Visibility))
+	buffered := bufio.NewReaderSize(data, sniffLen)
+	contentType, err := s.sniff(buffered)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// metadata is extracted, and stripped unless asked otherwise, while the
+	// upload streams through to the storage
+	filtered, filteredWriter := io.Pipe()
+	metadataResult := make(chan exif.Metadata, 1)
+	go func() {
+		metadata, err := exif.Filter(filteredWriter, buffered, contentType, !opts.KeepMetadata)
+		filteredWriter.CloseWithError(err)
+		metadataResult <- metadata
+	}()
+
+	id := uuid.Must(uuid.NewV4())
+	pending := pendingKey(id)
+	stored, err := s.storeOriginal(ctx, pending, contentType, filtered)
+	_ = filtered.Close()
+	metadata := <-metadataResult
+	if err != nil {
+		if !errors.Is(err, ErrUnsupportedFormat) && !errors.Is(err, ErrInvalidImageUpload) {
+			s.logger.Error("cannot store image", "error", err)
+		}
+		return Image{}, err
+	}
+	img := Image{
+		Id:           id,
+		OwnerId:      ownerId,
+		StorageKey:   originalKey(stored.sha256, contentType),
+		ContentType:  contentType,
+		Size:         stored.size,
+		Width:        stored.config.Width,
+		Height:       stored.config.Height,
+		OriginalName: path.Base(originalName),
+		CreatedAt:    time.Now().UTC(),
+		Metadata: Metadata{
+			CameraMake:  metadata.CameraMake,
+			CameraModel: metadata.CameraModel,
+			TakenAt:     metadata.TakenAt,
+			Orientation: metadata.Orientation,
+			Stripped:    metadata.Stripped,
+		},
+		Visibility:       visibility,
+		BurnAfterReading: opts.BurnAfterReading,
+	}
+	if opts.TTL > 0 {
+		img.ExpiresAt = img.CreatedAt.Add(opts.TTL)
+	}
+
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	moved := false
+	created, err := s.repository.CreateImage(c, img, func(ctx context.Context) error {
+		_, err := s.storage.Move(ctx, pending, img.StorageKey)
+		moved = err == nil
+		return err
+	})
+	if err != nil {
+		s.logger.Error("cannot create image", "error", err)
+	}
+	if !moved {
+		// either the content was already stored by an earlier upload or the
+		// image could not be created
+		if err := s.storage.Delete(ctx, pending); err != nil {
+			s.logger.Error("cannot remove pending upload", "key", pending, "error", err)
+		}
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	if created.BurnAfterReading {
+		// derivatives would leave copies around after the image is burned
+		return created, nil
+	}
+	// derivatives are a convenience, the upload succeeds without them
+	_ = s.enqueueDerivatives(ctx, created.Id)
+	return created, nil
+}
+
+func (s service) GetImageById(ctx context.Context, id uuid.UUID) (Image, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	img, err := s.repository.GetImageById(c, id)
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) {
+			s.logger.Error("cannot get image by id", "error", err)
+		}
+		return Image{}, err
+	}
+	if img.Gone(time.Now().UTC()) {
+		return Image{}, ErrImageNotFound
+	}
+	return img, nil
+}
+
+func (s service) GetImagesByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Image, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	imgs, err := s.repository.GetImagesByOwnerId(c, ownerId)
+	if err != nil {
+		s.logger.Error("cannot get images by owner id", "error", err)
+		return []Image{}, err
+	}
+	return live(imgs, time.Now().UTC()), nil
+}
+
+func (s service) GetImagesByIds(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]Image, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	imgs, err := s.repository.GetImagesByIds(c, ids)
+	if err != nil {
+		s.logger.Error("cannot get images by ids", "error", err)
+		return nil, err
+	}
+	byId := make(map[uuid.UUID]Image, len(imgs))
+	for _, img := range live(imgs, time.Now().UTC()) {
+		byId[img.Id] = img
+	}
+	return byId, nil
+}
+
+func (s service) GetPublicImages(ctx context.Context, before FeedCursor, limit int) ([]Image, error) {
+	limit = min(max(limit, 1), MaxFeedSize)
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	imgs, err := s.repository.GetPublicImages(c, before, time.Now().UTC(), limit)
+	if err != nil {
+		s.logger.Error("cannot get public images", "error", err)
+		return []Image{}, err
+	}
+	return imgs, nil
+}
+
+func (s service) SetVisibility(ctx context.Context, id uuid.UUID, visibility Visibility) (Image, error) {
+	if _, err := ParseVisibility(string(visibility)); err != nil || visibility == "" {
+		return Image{}, ErrInvalidVisibility
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	img, err := s.repository.UpdateImageVisibility(c, id, visibility)
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) {
+			s.logger.Error("cannot update image visibility", "error", err)
+		}
+		return Image{}, err
+	}
+	return img, nil
+}
+
+func (s service) Open(ctx context.Context, img Image) (io.ReadCloser, error) {
+	file, _, err := s.storage.Get(ctx, img.StorageKey)
+	if err != nil {
+		s.logger.Error("cannot open stored image", "error", err)
+		return nil, err
+	}
+	return file, nil
+}
+
+// PresignedURL returns an empty string when the storage cannot presign URLs
+// and objects have to be streamed through the API.
+func (s service) PresignedURL(ctx context.Context, storageKey string) (string, error) {
+	presigner, ok := s.storage.(storage.Presigner)
+	if !ok || s.presignExpiry <= 0 {
+		return "", nil
+	}
+	url, err := presigner.PresignGet(ctx, storageKey, s.presignExpiry)
+	if err != nil {
+		s.logger.Error("cannot presign image url", "error", err)
+		return "", err
+	}
+	return url, nil
+}
+
+func (s service) DeleteImage(ctx context.Context, id uuid.UUID) error {
+	derivatives, err := s.GetDerivatives(ctx, []uuid.UUID{id})
+	if err != nil {
+		return err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	renditions, err := s.repository.GetRenditionsByImageId(c, id)
+	if err != nil {
+		s.logger.Error("cannot get renditions", "image_id", id, "error", err)
+		return err
+	}
+	err = s.repository.DeleteImage(c, id, func(ctx context.Context, blob Blob) error {
+		// the blob row is locked until this returns, so no upload can take
+		// a new reference on content that is about to disappear
+		return s.storage.Delete(ctx, blob.StorageKey)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrImageNotFound) {
+			s.logger.Error("cannot delete image", "error", err)
+		}
+		return err
+	}
+	var keys []string
+	for _, derivative := range derivatives[id] {
+		keys = append(keys, derivative.StorageKey)
+	}
+	for _, rendition := range renditions {
+		keys = append(keys, rendition.StorageKey)
+	}
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			s.logger.Error("cannot remove stored object", "key", key, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/plinkplenk/img-share/internal/storage"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+type storedOriginal struct {
+	config image.Config
+	size   int64
+	sha256 string
+}
+
+// storeOriginal streams data into the storage under key while decoding the
+// image header and hashing the same bytes, so the upload is never held in
+// memory as a whole. If data turns out not to be a decodable image the
+// storage write is aborted and nothing is kept.
+func (s service) storeOriginal(
+	ctx context.Context,
+	key string,
+	contentType string,
+	data io.Reader,
+) (storedOriginal, error) {
+	pr, pw := io.Pipe()
+	type putResult struct {
+		object storage.Object
+		err    error
+	}
+	done := make(chan putResult, 1)
+	go func() {
+		object, err := s.storage.Put(ctx, key, pr, storage.PutOptions{ContentType: contentType})
+		// unblock the writer if the storage gave up before reading everything
+		pr.CloseWithError(err)
+		done <- putResult{object: object, err: err}
+	}()
+
+	hash := sha256.New()
+	src := &recordingReader{r: data}
+	dst := &countingWriter{w: io.MultiWriter(pw, hash)}
+	config, _, decodeErr := image.DecodeConfig(io.TeeReader(src, dst))
+	var pixelsErr error
+	if decodeErr == nil {
+		pixelsErr = s.checkPixels(config)
+	}
+	if decodeErr == nil && pixelsErr == nil {
+		_, _ = io.Copy(dst, src)
+	}
+	switch {
+	case src.err != nil:
+		pw.CloseWithError(src.err)
+	case decodeErr != nil:
+		pw.CloseWithError(decodeErr)
+	case pixelsErr != nil:
+		pw.CloseWithError(pixelsErr)
+	default:
+		_ = pw.Close()
+	}
+	result := <-done
+
+	switch {
+	case src.err != nil:
+		return storedOriginal{}, fmt.Errorf("%w: %w", ErrInvalidImageUpload, src.err)
+	case dst.err != nil:
+		if result.err != nil {
+			return storedOriginal{}, result.err
+		}
+		return storedOriginal{}, dst.err
+	case decodeErr != nil:
+		return storedOriginal{}, fmt.Errorf("%w: %w", ErrUnsupportedFormat, decodeErr)
+	case pixelsErr != nil:
+		return storedOriginal{}, fmt.Errorf("%w: %w", ErrInvalidImageUpload, pixelsErr)
+	case result.err != nil:
+		return storedOriginal{}, result.err
+	}
+	return storedOriginal{
+		config: config,
+		size:   dst.n,
+		sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// checkPixels refuses images whose declared dimensions would take more
+// memory to decode than allowed. Headers are cheap to forge, a tiny file
+// may claim billions of pixels.
+func (s service) checkPixels(config image.Config) error {
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > s.maxPixels {
+		return fmt.Errorf("%w: %dx%d, at most %d allowed", ErrTooManyPixels, config.Width, config.Height, s.maxPixels)
+	}
+	return nil
+}
+
+// recordingReader remembers the first error returned by r other than io.EOF.
+type recordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+package images
+
+import (
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"time"
+)
+
+type Visibility string
+
+const (
+	// VisibilityPrivate resources are only accessible to their owner.
+	VisibilityPrivate Visibility = "private"
+	// VisibilityUnlisted resources are accessible to anyone with their URL.
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPublic resources are accessible to anyone and listed in
+	// feeds.
+	VisibilityPublic Visibility = "public"
+)
+
+var ErrInvalidVisibility = errors.New("visibility must be private, unlisted or public")
+
+// ParseVisibility reads a visibility, the empty string being private.
+func ParseVisibility(raw string) (Visibility, error) {
+	switch visibility := Visibility(raw); visibility {
+	case "":
+		return VisibilityPrivate, nil
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return visibility, nil
+	default:
+		return "", ErrInvalidVisibility
+	}
+}
+
+type Action int
+
+const (
+	// ActionView reads the resource and its files.
+	ActionView Action = iota
+	// ActionList shows the resource to users who did not ask for it by id.
+	ActionList
+	// ActionManage changes or deletes the resource.
+	ActionManage
+)
+
+// Resource is what access decisions are made on, images and albums alike.
+type Resource struct {
+	OwnerId    uuid.UUID
+	Visibility Visibility
+}
+
+func (i Image) Resource() Resource {
+	return Resource{OwnerId: i.OwnerId, Visibility: i.Visibility}
+}
+
+// FeedCursor points into a feed ordered from the newest entry, the zero
+// value being its start.
+type FeedCursor struct {
+	CreatedAt time.Time
+	Id        uuid.UUID
+}
+
+// Authorize decides whether viewerId, invalid for anonymous users, may
+// perform action on resource. It is the only place access rules live, every
+// handler serving images or albums goes through it.
+func (s service) Authorize(viewerId uuid.NullUUID, resource Resource, action Action) error {
+	if viewerId.Valid && viewerId.UUID == resource.OwnerId {
+		return nil
+	}
+	switch action {
+	case ActionView:
+		if resource.Visibility == VisibilityUnlisted || resource.Visibility == VisibilityPublic {
+			return nil
+		}
+	case ActionList:
+		if resource.Visibility == VisibilityPublic {
+			return nil
+		}
+	}
+	return ErrImageAccessDenied
+}
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week. Fields accept *, numbers, ranges, lists and steps
+// such as */15 or 1-5. As in cron, when both day fields are restricted a
+// day matching either of them matches. Times are evaluated in UTC.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record which day fields were *
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func ParseCron(spec string) (Cron, error) {
+	if expanded, ok := cronDescriptors[spec]; ok {
+		spec = expanded
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return Cron{}, fmt.Errorf("%w: %q must have %d fields", ErrInvalidCron, spec, len(cronFields))
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return Cron{}, fmt.Errorf("%w: %q: %w", ErrInvalidCron, spec, err)
+		}
+		sets[i] = set
+	}
+	return Cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func parseCronField(raw string, field cronField) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(raw, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", field.name, stepPart)
+			}
+		}
+		low, high := field.min, field.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", field.name, item)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return 0, fmt.Errorf("invalid %s %q", field.name, item)
+				}
+			} else if hasStep {
+				// 5/15 means from 5 to the end in steps of 15
+				high = field.max
+			}
+		}
+		if low < field.min || high > field.max || low > high {
+			return 0, fmt.Errorf("%s %q is out of range %d-%d", field.name, item, field.min, field.max)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after after that matches the expression, or
+// the zero time when none does within five years.
+func (c Cron) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			// jump straight to the next allowed minute of this hour if any
+			rest := c.minute >> t.Minute()
+			if rest == 0 {
+				t = t.Truncate(time.Hour).Add(time.Hour)
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)) * time.Minute)
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrNoJobDue     = errors.New("no job is due")
+	ErrDuplicateJob = errors.New("a job with the same key is already queued")
+	ErrInvalidCron  = errors.New("invalid cron expression")
+	// ErrLeaseLost is returned when recording the outcome of an attempt
+	// whose lease expired and whose job was claimed again since.
+	ErrLeaseLost = errors.New("job lease was lost")
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	// StatusDead jobs failed every attempt and are kept for inspection and
+	// manual requeueing, they are never picked up again.
+	StatusDead Status = "dead"
+)
+
+type Job struct {
+	Id   uuid.UUID
+	Kind string
+	// Payload is the JSON encoded argument of the job.
+	Payload []byte
+	// Key deduplicates queued jobs, empty when any number of equal jobs may
+	// be queued.
+	Key         string
+	Status      Status
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	// LockedUntil is when a running job is considered abandoned by its
+	// worker and handed to another one.
+	LockedUntil time.Time
+	LastError   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type Repository interface {
+	// CreateJob queues job, failing with ErrDuplicateJob when a job with the
+	// same key is queued already.
+	CreateJob(ctx context.Context, job Job) (Job, error)
+	// ClaimJob marks the earliest pending job of one of kinds due at now, or
+	// a running one whose lock expired, as running until lockedUntil and
+	// counts the attempt. Concurrent workers never claim the same job.
+	// Running jobs whose lock expired after their last attempt are moved to
+	// the dead letters instead.
+	ClaimJob(ctx context.Context, kinds []string, now time.Time, lockedUntil time.Time) (Job, error)
+	// CompleteJob removes a job that ran successfully. Like RetryJob and
+	// BuryJob it only touches the job while it is still running attempt;
+	// once the lease expired and another worker claimed the job it fails
+	// with ErrLeaseLost, the outcome belongs to the new attempt.
+	CompleteJob(ctx context.Context, id uuid.UUID, attempt int) error
+	// RetryJob puts a failed job back in the queue to run at runAt.
+	RetryJob(ctx context.Context, id uuid.UUID, attempt int, runAt time.Time, lastError string) error
+	// BuryJob moves a job that failed for good to the dead letters.
+	BuryJob(ctx context.Context, id uuid.UUID, attempt int, lastError string) error
+	// EnqueueScheduled queues job if the schedule named name is due at now
+	// and moves the schedule on to next, both in one transaction, so that
+	// each run is queued once however many schedulers are running. A new
+	// schedule is created due at next. It reports whether job was queued.
+	EnqueueScheduled(ctx context.Context, name string, now time.Time, next time.Time, job Job) (bool, error)
+}
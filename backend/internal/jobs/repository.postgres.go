@@ -0,0 +1,295 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "jobs.repo.pg"
+
+const jobColumns = `id, kind, payload, key, status, attempts, max_attempts, run_at, locked_until, last_error,
+	created_at, updated_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgJob struct {
+	id          pgtype.UUID
+	kind        string
+	payload     []byte
+	key         pgtype.Text
+	status      string
+	attempts    int32
+	maxAttempts int32
+	runAt       pgtype.Timestamp
+	lockedUntil pgtype.Timestamp
+	lastError   string
+	createdAt   pgtype.Timestamp
+	updatedAt   pgtype.Timestamp
+}
+
+func (j *pgJob) scanTargets() []any {
+	return []any{
+		&j.id,
+		&j.kind,
+		&j.payload,
+		&j.key,
+		&j.status,
+		&j.attempts,
+		&j.maxAttempts,
+		&j.runAt,
+		&j.lockedUntil,
+		&j.lastError,
+		&j.createdAt,
+		&j.updatedAt,
+	}
+}
+
+func fromPGJob(job pgJob) Job {
+	return Job{
+		Id:          uuid.UUID(job.id.Bytes),
+		Kind:        job.kind,
+		Payload:     job.payload,
+		Key:         job.key.String,
+		Status:      Status(job.status),
+		Attempts:    int(job.attempts),
+		MaxAttempts: int(job.maxAttempts),
+		RunAt:       job.runAt.Time,
+		LockedUntil: job.lockedUntil.Time,
+		LastError:   job.lastError,
+		CreatedAt:   job.createdAt.Time,
+		UpdatedAt:   job.updatedAt.Time,
+	}
+}
+
+func toPGJob(job Job) pgJob {
+	return pgJob{
+		id:          pgtype.UUID{Bytes: [16]byte(job.Id.Bytes()), Valid: true},
+		kind:        job.Kind,
+		payload:     job.Payload,
+		key:         pgtype.Text{String: job.Key, Valid: job.Key != ""},
+		status:      string(job.Status),
+		attempts:    int32(job.Attempts),
+		maxAttempts: int32(job.MaxAttempts),
+		runAt:       pgtype.Timestamp{Time: job.RunAt.UTC(), Valid: true},
+		lockedUntil: pgtype.Timestamp{Time: job.LockedUntil.UTC(), Valid: !job.LockedUntil.IsZero()},
+		lastError:   job.LastError,
+		createdAt:   pgtype.Timestamp{Time: job.CreatedAt.UTC(), Valid: true},
+		updatedAt:   pgtype.Timestamp{Time: job.UpdatedAt.UTC(), Valid: true},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+// rowQuerier is either the pool or a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func createJob(ctx context.Context, db rowQuerier, job Job) (Job, error) {
+	query := `
+INSERT INTO jobs (` + jobColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (key) WHERE key IS NOT NULL DO NOTHING
+	RETURNING ` + jobColumns
+
+	toCreate := toPGJob(job)
+	var created pgJob
+	if err := db.QueryRow(
+		ctx,
+		query,
+		toCreate.id,
+		toCreate.kind,
+		toCreate.payload,
+		toCreate.key,
+		toCreate.status,
+		toCreate.attempts,
+		toCreate.maxAttempts,
+		toCreate.runAt,
+		toCreate.lockedUntil,
+		toCreate.lastError,
+		toCreate.createdAt,
+		toCreate.updatedAt,
+	).Scan(created.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Job{}, ErrDuplicateJob
+		}
+		return Job{}, err
+	}
+	return fromPGJob(created), nil
+}
+
+func (r *postgresRepository) CreateJob(ctx context.Context, job Job) (Job, error) {
+	const op = postgresRepositorySource + ".CreateJob"
+	created, err := createJob(ctx, r.db, job)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateJob) {
+			return Job{}, err
+		}
+		return Job{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return created, nil
+}
+
+func (r *postgresRepository) ClaimJob(
+	ctx context.Context,
+	kinds []string,
+	now time.Time,
+	lockedUntil time.Time,
+) (Job, error) {
+	const op = postgresRepositorySource + ".ClaimJob"
+	// a job whose worker died during its last attempt is buried instead of
+	// being run once more than it is allowed to
+	query := `
+WITH buried AS (
+	UPDATE jobs SET status = 'dead', key = NULL, locked_until = NULL, last_error = $4, updated_at = $2
+	WHERE kind = ANY($1) AND status = 'running' AND locked_until <= $2 AND attempts >= max_attempts
+)
+UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = $3, updated_at = $2
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE kind = ANY($1)
+			AND ((status = 'pending' AND run_at <= $2)
+				OR (status = 'running' AND locked_until <= $2 AND attempts < max_attempts))
+		ORDER BY run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + jobColumns
+
+	var job pgJob
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		kinds,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		pgtype.Timestamp{Time: lockedUntil.UTC(), Valid: true},
+		"lease expired during the last attempt",
+	).Scan(job.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Job{}, ErrNoJobDue
+		}
+		return Job{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGJob(job), nil
+}
+
+func (r *postgresRepository) CompleteJob(ctx context.Context, id uuid.UUID, attempt int) error {
+	const op = postgresRepositorySource + ".CompleteJob"
+	query := `DELETE FROM jobs WHERE id = $1 AND status = 'running' AND attempts = $2`
+	tag, err := r.db.Exec(ctx, query, id, int32(attempt))
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *postgresRepository) RetryJob(
+	ctx context.Context,
+	id uuid.UUID,
+	attempt int,
+	runAt time.Time,
+	lastError string,
+) error {
+	const op = postgresRepositorySource + ".RetryJob"
+	query := `
+UPDATE jobs SET status = 'pending', run_at = $3, locked_until = NULL, last_error = $4, updated_at = $5
+	WHERE id = $1 AND status = 'running' AND attempts = $2`
+	tag, err := r.db.Exec(
+		ctx,
+		query,
+		id,
+		int32(attempt),
+		pgtype.Timestamp{Time: runAt.UTC(), Valid: true},
+		lastError,
+		pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	)
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *postgresRepository) BuryJob(ctx context.Context, id uuid.UUID, attempt int, lastError string) error {
+	const op = postgresRepositorySource + ".BuryJob"
+	// the key is released so that the same work can be queued again
+	query := `
+UPDATE jobs SET status = 'dead', key = NULL, locked_until = NULL, last_error = $3, updated_at = $4
+	WHERE id = $1 AND status = 'running' AND attempts = $2`
+	tag, err := r.db.Exec(
+		ctx,
+		query,
+		id,
+		int32(attempt),
+		lastError,
+		pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	)
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *postgresRepository) EnqueueScheduled(
+	ctx context.Context,
+	name string,
+	now time.Time,
+	next time.Time,
+	job Job,
+) (bool, error) {
+	const op = postgresRepositorySource + ".EnqueueScheduled"
+	createQuery := `
+INSERT INTO job_schedules (name, next_run_at) VALUES ($1, $2)
+	ON CONFLICT (name) DO NOTHING`
+	advanceQuery := `
+UPDATE job_schedules SET next_run_at = $3
+	WHERE name = $1 AND next_run_at <= $2`
+
+	queued := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		nextRunAt := pgtype.Timestamp{Time: next.UTC(), Valid: true}
+		if _, err := tx.Exec(ctx, createQuery, name, nextRunAt); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, advanceQuery, name, pgtype.Timestamp{Time: now.UTC(), Valid: true}, nextRunAt)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+		if _, err := createJob(ctx, tx, job); err != nil {
+			// the previous run is still queued, skip this one
+			if errors.Is(err, ErrDuplicateJob) {
+				return nil
+			}
+			return err
+		}
+		queued = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return queued, nil
+}
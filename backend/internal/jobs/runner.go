@@ -0,0 +1,240 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Handler runs a job. Jobs are delivered at least once, a job whose worker
+// died or timed out runs again, so handlers have to be idempotent. An error
+// schedules a retry with exponential backoff until the attempts run out and
+// the job is dead-lettered.
+type Handler func(ctx context.Context, job Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as one a retry cannot fix, the job is dead-lettered
+// right away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// DecodePayload unmarshals the payload of job into T, failing permanently
+// since a payload that cannot be decoded never will be.
+func DecodePayload[T any](job Job) (T, error) {
+	var payload T
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return payload, Permanent(fmt.Errorf("invalid %s job payload: %w", job.Kind, err))
+	}
+	return payload, nil
+}
+
+type RunnerOptions struct {
+	Workers      int
+	PollInterval time.Duration
+	// Lease is how long a job may run before it is cancelled and may be
+	// claimed by another worker.
+	Lease       time.Duration
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+type schedule struct {
+	name    string
+	cron    Cron
+	kind    string
+	payload any
+}
+
+// Runner executes queued jobs with a pool of workers and queues the runs of
+// scheduled jobs. Any number of runners, in the server or in separate
+// workers, can share one queue.
+type Runner struct {
+	repository Repository
+	opts       RunnerOptions
+	handlers   map[string]Handler
+	schedules  []schedule
+	timeout    time.Duration
+	logger     *slog.Logger
+}
+
+func NewRunner(repository Repository, opts RunnerOptions, timeout time.Duration, logger *slog.Logger) *Runner {
+	return &Runner{
+		repository: repository,
+		opts:       opts,
+		handlers:   make(map[string]Handler),
+		timeout:    timeout,
+		logger:     logger,
+	}
+}
+
+// Handle registers the handler of kind. Runners only claim the kinds they
+// have handlers for.
+func (r *Runner) Handle(kind string, handler Handler) {
+	r.handlers[kind] = handler
+}
+
+// Schedule queues a job of kind with payload at every time matching the
+// cron expression spec. name identifies the schedule across runners, a run
+// is skipped while the previous one is still queued.
+func (r *Runner) Schedule(name string, spec string, kind string, payload any) error {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	r.schedules = append(r.schedules, schedule{name: name, cron: cron, kind: kind, payload: payload})
+	return nil
+}
+
+// Run works on jobs until ctx is done and returns once every worker has
+// stopped. Jobs running at that point are cancelled and retried later.
+func (r *Runner) Run(ctx context.Context) {
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+	var wg sync.WaitGroup
+	for range r.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, kinds)
+		}()
+	}
+	if len(r.schedules) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.schedule(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Runner) wait(ctx context.Context) {
+	timer := time.NewTimer(r.opts.PollInterval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (r *Runner) work(ctx context.Context, kinds []string) {
+	for ctx.Err() == nil {
+		c, cancel := context.WithTimeout(ctx, r.timeout)
+		now := time.Now().UTC()
+		job, err := r.repository.ClaimJob(c, kinds, now, now.Add(r.opts.Lease))
+		cancel()
+		if err != nil {
+			if !errors.Is(err, ErrNoJobDue) && ctx.Err() == nil {
+				r.logger.Error("cannot claim job", "error", err)
+			}
+			r.wait(ctx)
+			continue
+		}
+		r.run(ctx, job)
+	}
+}
+
+func (r *Runner) call(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for %s jobs", job.Kind))
+	}
+	return handler(ctx, job)
+}
+
+func (r *Runner) run(ctx context.Context, job Job) {
+	logger := r.logger.With("job_id", job.Id, "kind", job.Kind, "attempt", job.Attempts)
+	runCtx, cancel := context.WithTimeout(ctx, r.opts.Lease)
+	err := r.call(runCtx, job)
+	cancel()
+
+	// the outcome is recorded even when shutting down, otherwise the job
+	// would wait for its lease to expire
+	c, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	defer cancel()
+	var permanent permanentError
+	switch {
+	case err == nil:
+		err = r.repository.CompleteJob(c, job.Id, job.Attempts)
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		logger.Error("job failed for good, moving it to dead letters", "error", err)
+		err = r.repository.BuryJob(c, job.Id, job.Attempts, err.Error())
+	default:
+		delay := r.backoff(job.Attempts)
+		logger.Warn("job failed, retrying", "error", err, "retry_in", delay)
+		err = r.repository.RetryJob(c, job.Id, job.Attempts, time.Now().UTC().Add(delay), err.Error())
+	}
+	switch {
+	case errors.Is(err, ErrLeaseLost):
+		// the attempt outlived its lease and the job was handed to another
+		// worker, whose outcome is the one that counts
+		logger.Warn("job lease was lost, dropping the outcome")
+	case err != nil:
+		logger.Error("cannot record job outcome", "error", err)
+	}
+}
+
+// backoff doubles the delay with every attempt up to BackoffMax and picks
+// a random point in its upper half so that failing jobs spread out.
+func (r *Runner) backoff(attempt int) time.Duration {
+	delay := r.opts.BackoffBase
+	for i := 1; i < attempt && delay < r.opts.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, r.opts.BackoffMax)
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+func (r *Runner) schedule(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now().UTC()
+		for _, s := range r.schedules {
+			job, err := newJob(s.kind, s.payload, EnqueueOptions{Key: "schedule:" + s.name}, r.opts.MaxAttempts)
+			if err != nil {
+				r.logger.Error("cannot create scheduled job", "schedule", s.name, "error", err)
+				continue
+			}
+			c, cancel := context.WithTimeout(ctx, r.timeout)
+			queued, err := r.repository.EnqueueScheduled(c, s.name, now, s.cron.Next(now), job)
+			cancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("cannot enqueue scheduled job", "schedule", s.name, "error", err)
+				}
+				continue
+			}
+			if queued {
+				r.logger.Debug("enqueued scheduled job", "schedule", s.name, "job_id", job.Id)
+			}
+		}
+		r.wait(ctx)
+	}
+}
@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"log/slog"
+	"time"
+)
+
+type EnqueueOptions struct {
+	// RunAt delays the job, it runs as soon as possible when zero.
+	RunAt time.Time
+	// Key deduplicates the job against queued jobs with the same key.
+	Key string
+	// MaxAttempts overrides the default number of attempts when positive.
+	MaxAttempts int
+}
+
+type Service interface {
+	// Enqueue queues a job of kind with payload encoded as JSON. It fails
+	// with ErrDuplicateJob when a job with the same key is queued already.
+	Enqueue(ctx context.Context, kind string, payload any, opts EnqueueOptions) (Job, error)
+}
+
+type service struct {
+	repository  Repository
+	maxAttempts int
+	timeout     time.Duration
+	logger      *slog.Logger
+}
+
+func NewService(repository Repository, maxAttempts int, timeout time.Duration, logger *slog.Logger) Service {
+	return service{
+		repository:  repository,
+		maxAttempts: maxAttempts,
+		timeout:     timeout,
+		logger:      logger,
+	}
+}
+
+func newJob(kind string, payload any, opts EnqueueOptions, maxAttempts int) (Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("cannot encode %s job payload: %w", kind, err)
+	}
+	now := time.Now().UTC()
+	job := Job{
+		Id:          uuid.Must(uuid.NewV4()),
+		Kind:        kind,
+		Payload:     encoded,
+		Key:         opts.Key,
+		Status:      StatusPending,
+		MaxAttempts: maxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if opts.MaxAttempts > 0 {
+		job.MaxAttempts = opts.MaxAttempts
+	}
+	if !opts.RunAt.IsZero() {
+		job.RunAt = opts.RunAt.UTC()
+	}
+	return job, nil
+}
+
+func (s service) Enqueue(ctx context.Context, kind string, payload any, opts EnqueueOptions) (Job, error) {
+	job, err := newJob(kind, payload, opts, s.maxAttempts)
+	if err != nil {
+		s.logger.Error("cannot create job", "kind", kind, "error", err)
+		return Job{}, err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	created, err := s.repository.CreateJob(c, job)
+	if err != nil {
+		if !errors.Is(err, ErrDuplicateJob) {
+			s.logger.Error("cannot enqueue job", "kind", kind, "error", err)
+		}
+		return Job{}, err
+	}
+	return created, nil
+}
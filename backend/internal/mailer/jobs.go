@@ -0,0 +1,19 @@
+package mailer
+
+import (
+	"context"
+	"github.com/plinkplenk/img-share/internal/jobs"
+)
+
+const JobDeliver = "mailer.deliver"
+
+// RegisterJobs makes runner execute the mailer jobs and schedules a
+// delivery every minute, which sends the messages whose delivery job was
+// lost and the retries.
+func RegisterJobs(runner *jobs.Runner, service Service) error {
+	runner.Handle(JobDeliver, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.Deliver(ctx)
+		return err
+	})
+	return runner.Schedule(JobDeliver, "* * * * *", JobDeliver, nil)
+}
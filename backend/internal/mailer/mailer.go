@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLog  = "log"
+	DriverSMTP = "smtp"
+)
+
+var (
+	ErrInvalidAddress   = errors.New("invalid email address")
+	ErrTemplateNotFound = errors.New("email template not found")
+)
+
+// Message is a rendered email. HTML is optional, messages without it are
+// sent as plain text only.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages right away, callers go through the outbox of
+// Service instead so that messages survive failures and restarts.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+type logMailer struct {
+	logger *slog.Logger
+}
+
+// NewLogMailer returns a Mailer that logs messages instead of sending them,
+// for development setups without a mail server.
+func NewLogMailer(logger *slog.Logger) Mailer {
+	return logMailer{logger: logger}
+}
+
+func (m logMailer) Send(ctx context.Context, message Message) error {
+	m.logger.Info("email", "to", message.To, "subject", message.Subject, "text", message.Text)
+	return nil
+}
+
+func writeQuotedPrintable(w *multipart.Writer, contentType string, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// encode builds the RFC 5322 representation of message sent by from.
+func encode(from *mail.Address, to *mail.Address, message Message, now time.Time) ([]byte, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	if err := writeQuotedPrintable(parts, "text/plain", message.Text); err != nil {
+		return nil, err
+	}
+	if message.HTML != "" {
+		if err := writeQuotedPrintable(parts, "text/html", message.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var encoded bytes.Buffer
+	// the subject is the only header with user controlled content, the
+	// encoding takes care of line breaks in it
+	fmt.Fprintf(&encoded, "From: %s\r\n", from.String())
+	fmt.Fprintf(&encoded, "To: %s\r\n", to.String())
+	fmt.Fprintf(&encoded, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&encoded, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&encoded, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(idBytes), domain)
+	fmt.Fprintf(&encoded, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&encoded, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	encoded.Write(body.Bytes())
+	return encoded.Bytes(), nil
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	// OutboxStatusFailed messages could not be sent in any attempt, they are
+	// kept for inspection and never picked up again.
+	OutboxStatusFailed OutboxStatus = "failed"
+)
+
+// OutboxMessage is a rendered email waiting to be sent. Sent messages are
+// deleted rather than kept, their bodies hold links with one-time secrets.
+type OutboxMessage struct {
+	Id            uuid.UUID
+	Message       Message
+	Status        OutboxStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	// LockedUntil is when a message being sent is considered abandoned by
+	// its worker and handed to another one.
+	LockedUntil time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
+
+type Repository interface {
+	CreateMessage(ctx context.Context, message OutboxMessage) (OutboxMessage, error)
+	// ClaimMessages locks up to limit pending messages due at now until
+	// lockedUntil and counts the attempt. Concurrent workers never claim
+	// the same message.
+	ClaimMessages(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]OutboxMessage, error)
+	// DeleteMessage removes a message that was sent.
+	DeleteMessage(ctx context.Context, id uuid.UUID) error
+	// RetryMessage releases a message that could not be sent to be tried
+	// again at nextAttemptAt.
+	RetryMessage(ctx context.Context, id uuid.UUID, nextAttemptAt time.Time, lastError string) error
+	// FailMessage gives up on a message.
+	FailMessage(ctx context.Context, id uuid.UUID, lastError string) error
+}
@@ -0,0 +1,209 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "mailer.repo.pg"
+
+const outboxColumns = `id, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, locked_until,
+	last_error, created_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgOutboxMessage struct {
+	id            pgtype.UUID
+	recipient     string
+	subject       string
+	textBody      string
+	htmlBody      string
+	status        string
+	attempts      int32
+	nextAttemptAt pgtype.Timestamp
+	lockedUntil   pgtype.Timestamp
+	lastError     string
+	createdAt     pgtype.Timestamp
+}
+
+func (m *pgOutboxMessage) scanTargets() []any {
+	return []any{
+		&m.id,
+		&m.recipient,
+		&m.subject,
+		&m.textBody,
+		&m.htmlBody,
+		&m.status,
+		&m.attempts,
+		&m.nextAttemptAt,
+		&m.lockedUntil,
+		&m.lastError,
+		&m.createdAt,
+	}
+}
+
+func fromPGOutboxMessage(message pgOutboxMessage) OutboxMessage {
+	return OutboxMessage{
+		Id: uuid.UUID(message.id.Bytes),
+		Message: Message{
+			To:      message.recipient,
+			Subject: message.subject,
+			Text:    message.textBody,
+			HTML:    message.htmlBody,
+		},
+		Status:        OutboxStatus(message.status),
+		Attempts:      int(message.attempts),
+		NextAttemptAt: message.nextAttemptAt.Time,
+		LockedUntil:   message.lockedUntil.Time,
+		LastError:     message.lastError,
+		CreatedAt:     message.createdAt.Time,
+	}
+}
+
+func toPGOutboxMessage(message OutboxMessage) pgOutboxMessage {
+	return pgOutboxMessage{
+		id:            pgtype.UUID{Bytes: [16]byte(message.Id.Bytes()), Valid: true},
+		recipient:     message.Message.To,
+		subject:       message.Message.Subject,
+		textBody:      message.Message.Text,
+		htmlBody:      message.Message.HTML,
+		status:        string(message.Status),
+		attempts:      int32(message.Attempts),
+		nextAttemptAt: pgtype.Timestamp{Time: message.NextAttemptAt.UTC(), Valid: true},
+		lockedUntil:   pgtype.Timestamp{Time: message.LockedUntil.UTC(), Valid: !message.LockedUntil.IsZero()},
+		lastError:     message.LastError,
+		createdAt:     pgtype.Timestamp{Time: message.CreatedAt.UTC(), Valid: true},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateMessage(ctx context.Context, message OutboxMessage) (OutboxMessage, error) {
+	const op = postgresRepositorySource + ".CreateMessage"
+	query := `
+INSERT INTO email_outbox (` + outboxColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING ` + outboxColumns
+
+	toCreate := toPGOutboxMessage(message)
+	var created pgOutboxMessage
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		toCreate.id,
+		toCreate.recipient,
+		toCreate.subject,
+		toCreate.textBody,
+		toCreate.htmlBody,
+		toCreate.status,
+		toCreate.attempts,
+		toCreate.nextAttemptAt,
+		toCreate.lockedUntil,
+		toCreate.lastError,
+		toCreate.createdAt,
+	).Scan(created.scanTargets()...); err != nil {
+		return OutboxMessage{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGOutboxMessage(created), nil
+}
+
+func (r *postgresRepository) ClaimMessages(
+	ctx context.Context,
+	now time.Time,
+	lockedUntil time.Time,
+	limit int,
+) ([]OutboxMessage, error) {
+	const op = postgresRepositorySource + ".ClaimMessages"
+	query := `
+UPDATE email_outbox SET attempts = attempts + 1, locked_until = $2
+	WHERE id IN (
+		SELECT id FROM email_outbox
+		WHERE status = 'pending' AND next_attempt_at <= $1 AND (locked_until IS NULL OR locked_until <= $1)
+		ORDER BY next_attempt_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + outboxColumns
+
+	rows, err := r.db.Query(
+		ctx,
+		query,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		pgtype.Timestamp{Time: lockedUntil.UTC(), Valid: true},
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message pgOutboxMessage
+		if err := rows.Scan(message.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		messages = append(messages, fromPGOutboxMessage(message))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return messages, nil
+}
+
+func (r *postgresRepository) DeleteMessage(ctx context.Context, id uuid.UUID) error {
+	const op = postgresRepositorySource + ".DeleteMessage"
+	query := `DELETE FROM email_outbox WHERE id = $1`
+	tag, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOutboxMessageNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) RetryMessage(
+	ctx context.Context,
+	id uuid.UUID,
+	nextAttemptAt time.Time,
+	lastError string,
+) error {
+	const op = postgresRepositorySource + ".RetryMessage"
+	query := `
+UPDATE email_outbox SET next_attempt_at = $2, locked_until = NULL, last_error = $3
+	WHERE id = $1`
+	tag, err := r.db.Exec(ctx, query, id, pgtype.Timestamp{Time: nextAttemptAt.UTC(), Valid: true}, lastError)
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOutboxMessageNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) FailMessage(ctx context.Context, id uuid.UUID, lastError string) error {
+	const op = postgresRepositorySource + ".FailMessage"
+	query := `
+UPDATE email_outbox SET status = 'failed', locked_until = NULL, last_error = $2
+	WHERE id = $1`
+	tag, err := r.db.Exec(ctx, query, id, lastError)
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOutboxMessageNotFound
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"log/slog"
+	"time"
+)
+
+const (
+	// deliveryBatchSize is how many messages a worker claims at once.
+	deliveryBatchSize = 20
+	// deliveryLease is how long claimed messages stay locked to their
+	// worker, it has to outlast sending a whole batch.
+	deliveryLease = 10 * time.Minute
+	retryBase     = time.Minute
+	retryMax      = 6 * time.Hour
+)
+
+type Service interface {
+	// Queue renders the template name in locale with data into the outbox,
+	// the email is sent to to by a background worker. An empty locale is
+	// the default one.
+	Queue(ctx context.Context, to string, name string, locale string, data any) error
+	// Deliver sends the outbox messages that are due and returns how many
+	// were sent.
+	Deliver(ctx context.Context) (int, error)
+}
+
+type service struct {
+	repository  Repository
+	mailer      Mailer
+	templates   *Templates
+	jobs        jobs.Service
+	maxAttempts int
+	timeout     time.Duration
+	logger      *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	mailer Mailer,
+	templates *Templates,
+	jobsService jobs.Service,
+	maxAttempts int,
+	timeout time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		repository:  repository,
+		mailer:      mailer,
+		templates:   templates,
+		jobs:        jobsService,
+		maxAttempts: maxAttempts,
+		timeout:     timeout,
+		logger:      logger,
+	}
+}
+
+func (s service) Queue(ctx context.Context, to string, name string, locale string, data any) error {
+	message, err := s.templates.Render(name, locale, to, data)
+	if err != nil {
+		s.logger.Error("cannot render email", "template", name, "locale", locale, "error", err)
+		return err
+	}
+	now := time.Now().UTC()
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err = s.repository.CreateMessage(c, OutboxMessage{
+		Id:            uuid.Must(uuid.NewV4()),
+		Message:       message,
+		Status:        OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		s.logger.Error("cannot queue email", "template", name, "error", err)
+		return err
+	}
+	// the scheduled delivery picks the message up anyway, the job only
+	// spares it the wait
+	_, err = s.jobs.Enqueue(ctx, JobDeliver, nil, jobs.EnqueueOptions{Key: JobDeliver})
+	if err != nil && !errors.Is(err, jobs.ErrDuplicateJob) {
+		s.logger.Warn("cannot enqueue email delivery", "error", err)
+	}
+	return nil
+}
+
+// retryDelay doubles the delay with every attempt up to retryMax.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempt && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
+
+func (s service) Deliver(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		now := time.Now().UTC()
+		c, cancel := context.WithTimeout(ctx, s.timeout)
+		messages, err := s.repository.ClaimMessages(c, now, now.Add(deliveryLease), deliveryBatchSize)
+		cancel()
+		if err != nil {
+			s.logger.Error("cannot claim outbox messages", "error", err)
+			return sent, err
+		}
+		for _, message := range messages {
+			ok, err := s.deliver(ctx, message)
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+		if len(messages) < deliveryBatchSize || ctx.Err() != nil {
+			break
+		}
+	}
+	if sent > 0 {
+		s.logger.Info("sent emails", "count", sent)
+	}
+	return sent, ctx.Err()
+}
+
+// deliver sends message and records the outcome, reporting whether it was
+// sent. Failing to send is not an error of the delivery, the message is
+// retried or given up on; only failing to record the outcome is.
+func (s service) deliver(ctx context.Context, message OutboxMessage) (bool, error) {
+	logger := s.logger.With("message_id", message.Id, "attempt", message.Attempts)
+	sendErr := s.mailer.Send(ctx, message.Message)
+
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var err error
+	switch {
+	case sendErr == nil:
+		err = s.repository.DeleteMessage(c, message.Id)
+	case errors.Is(sendErr, ErrInvalidAddress) || message.Attempts >= s.maxAttempts:
+		logger.Error("cannot send email, giving up", "error", sendErr)
+		err = s.repository.FailMessage(c, message.Id, sendErr.Error())
+	default:
+		logger.Warn("cannot send email, retrying", "error", sendErr)
+		nextAttemptAt := time.Now().UTC().Add(retryDelay(message.Attempts))
+		err = s.repository.RetryMessage(c, message.Id, nextAttemptAt, sendErr.Error())
+	}
+	if err != nil {
+		logger.Error("cannot record email delivery", "error", err)
+		return false, err
+	}
+	return sendErr == nil, nil
+}
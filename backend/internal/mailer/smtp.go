@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	SecurityNone     = "none"
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+)
+
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Security is SecurityNone for plain connections such as local sinks
+	// like MailHog, SecurityStartTLS to upgrade them or SecurityTLS for
+	// implicit TLS.
+	Security string
+	From     string
+	// Timeout bounds the whole conversation with the server.
+	Timeout time.Duration
+}
+
+type smtpMailer struct {
+	opts SMTPOptions
+	from *mail.Address
+}
+
+func NewSMTPMailer(opts SMTPOptions) (Mailer, error) {
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: from: %w", ErrInvalidAddress, err)
+	}
+	switch opts.Security {
+	case SecurityNone, SecurityStartTLS, SecurityTLS:
+	default:
+		return nil, fmt.Errorf("unknown smtp security %q", opts.Security)
+	}
+	return smtpMailer{opts: opts, from: from}, nil
+}
+
+func (m smtpMailer) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(m.opts.Host, strconv.Itoa(m.opts.Port))
+	dialer := &net.Dialer{}
+	if m.opts.Security == SecurityTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.opts.Host}}
+		return tlsDialer.DialContext(ctx, "tcp", addr)
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func (m smtpMailer) Send(ctx context.Context, message Message) error {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+	encoded, err := encode(m.from, to, message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+	defer cancel()
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.opts.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.opts.Security == SecurityStartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: m.opts.Host}); err != nil {
+			return err
+		}
+	}
+	if m.opts.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.opts.Username, m.opts.Password, m.opts.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
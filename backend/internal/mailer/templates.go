@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	// TemplateVerifyEmail is rendered with VerifyEmailData.
+	TemplateVerifyEmail = "verify_email"
+	// TemplateResetPassword is rendered with ResetPasswordData.
+	TemplateResetPassword = "reset_password"
+	// TemplateConfirmEmailChange is rendered with ConfirmEmailChangeData.
+	TemplateConfirmEmailChange = "confirm_email_change"
+	// TemplateEmailChangeRequested is rendered with EmailChangeRequestedData.
+	TemplateEmailChangeRequested = "email_change_requested"
+)
+
+type VerifyEmailData struct {
+	Link string
+}
+
+type ResetPasswordData struct {
+	Link string
+	// ValidFor is how many minutes the link works for.
+	ValidFor int
+}
+
+//go:embed templates
+var templatesFS embed.FS
+
+type ConfirmEmailChangeData struct {
+	Link     string
+	ValidFor int
+}
+
+type EmailChangeRequestedData struct {
+	NewEmail string
+}
+
+// template is one email in one locale. Templates live in
+// templates/<locale>/<name>.subject.txt, <name>.txt and the optional
+// <name>.html.
+type template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Templates renders the embedded emails in the locale closest to the one
+// asked for.
+type Templates struct {
+	byLocale      map[string]map[string]template
+	defaultLocale string
+}
+
+func NewTemplates(defaultLocale string) (*Templates, error) {
+	t := &Templates{
+		byLocale:      make(map[string]map[string]template),
+		defaultLocale: normalizeLocale(defaultLocale),
+	}
+	locales, err := fs.ReadDir(templatesFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		templates, err := parseLocale(path.Join("templates", locale.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("locale %s: %w", locale.Name(), err)
+		}
+		t.byLocale[normalizeLocale(locale.Name())] = templates
+	}
+	if _, ok := t.byLocale[t.defaultLocale]; !ok {
+		return nil, fmt.Errorf("no email templates for the default locale %q", defaultLocale)
+	}
+	return t, nil
+}
+
+func parseLocale(dir string) (map[string]template, error) {
+	templates := make(map[string]template)
+	subjects, err := fs.Glob(templatesFS, path.Join(dir, "*.subject.txt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, subjectPath := range subjects {
+		name := strings.TrimSuffix(path.Base(subjectPath), ".subject.txt")
+		var tmpl template
+		if tmpl.subject, err = texttemplate.ParseFS(templatesFS, subjectPath); err != nil {
+			return nil, err
+		}
+		if tmpl.text, err = texttemplate.ParseFS(templatesFS, path.Join(dir, name+".txt")); err != nil {
+			return nil, err
+		}
+		htmlPath := path.Join(dir, name+".html")
+		if _, err := fs.Stat(templatesFS, htmlPath); err == nil {
+			if tmpl.html, err = htmltemplate.ParseFS(templatesFS, htmlPath); err != nil {
+				return nil, err
+			}
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// lookup finds name in locale, then in its base language and finally in the
+// default locale, so pt-BR falls back to pt and then to the default.
+func (t *Templates) lookup(name string, locale string) (template, bool) {
+	locale = normalizeLocale(locale)
+	base, _, _ := strings.Cut(locale, "-")
+	for _, candidate := range []string{locale, base, t.defaultLocale} {
+		if tmpl, ok := t.byLocale[candidate][name]; ok {
+			return tmpl, true
+		}
+	}
+	return template{}, false
+}
+
+// Render renders the email name for to in locale with data.
+func (t *Templates) Render(name string, locale string, to string, data any) (Message, error) {
+	tmpl, ok := t.lookup(name, locale)
+	if !ok {
+		return Message{}, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			return Message{}, err
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
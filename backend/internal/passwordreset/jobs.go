@@ -0,0 +1,25 @@
+package passwordreset
+
+import (
+	"context"
+	"github.com/plinkplenk/img-share/internal/jobs"
+)
+
+const JobSend = "passwordreset.send"
+
+type sendPayload struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// RegisterJobs makes runner execute the password reset jobs.
+func RegisterJobs(runner *jobs.Runner, service Service) error {
+	runner.Handle(JobSend, func(ctx context.Context, job jobs.Job) error {
+		payload, err := jobs.DecodePayload[sendPayload](job)
+		if err != nil {
+			return err
+		}
+		return service.Send(ctx, payload.Email, payload.Locale)
+	})
+	return nil
+}
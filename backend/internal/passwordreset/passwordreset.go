@@ -0,0 +1,5 @@
+package passwordreset
+
+import "errors"
+
+var ErrInvalidToken = errors.New("invalid or expired password reset token")
@@ -0,0 +1,157 @@
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"github.com/plinkplenk/img-share/internal/auth"
+	"github.com/plinkplenk/img-share/internal/jobs"
+	"github.com/plinkplenk/img-share/internal/mailer"
+	"github.com/plinkplenk/img-share/internal/tokens"
+	"github.com/plinkplenk/img-share/internal/users"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const tokenQueryParamName = "token"
+
+type Service interface {
+	// Request asks for a reset link to be mailed to email, in locale when
+	// there are templates for it. It only queues the work and behaves the
+	// same whether an account with email exists or not, so that callers
+	// cannot probe for accounts.
+	Request(ctx context.Context, email string, locale string) error
+	// Send mails the reset link asked for with Request if an account with
+	// email exists. Links can only be asked for once per resend interval,
+	// further requests are ignored.
+	Send(ctx context.Context, email string, locale string) error
+	// Reset consumes token and sets the password of its user to
+	// newPassword, signing the user out everywhere.
+	Reset(ctx context.Context, token string, newPassword string) (users.User, error)
+}
+
+type service struct {
+	tokensService  tokens.Service
+	usersService   users.Service
+	authService    auth.Service
+	mailerService  mailer.Service
+	jobsService    jobs.Service
+	resetURL       url.URL
+	tokenTTL       time.Duration
+	resendInterval time.Duration
+	logger         *slog.Logger
+}
+
+func NewService(
+	tokensService tokens.Service,
+	usersService users.Service,
+	authService auth.Service,
+	mailerService mailer.Service,
+	jobsService jobs.Service,
+	resetURL url.URL,
+	tokenTTL time.Duration,
+	resendInterval time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		tokensService:  tokensService,
+		usersService:   usersService,
+		authService:    authService,
+		mailerService:  mailerService,
+		jobsService:    jobsService,
+		resetURL:       resetURL,
+		tokenTTL:       tokenTTL,
+		resendInterval: resendInterval,
+		logger:         logger,
+	}
+}
+
+func (s service) link(token string) string {
+	link := s.resetURL
+	query := link.Query()
+	query.Set(tokenQueryParamName, token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+func (s service) Request(ctx context.Context, email string, locale string) error {
+	email, err := users.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	// looking the account up and mailing it happen in a job, a request
+	// takes as long for unknown addresses as for known ones
+	_, err = s.jobsService.Enqueue(
+		ctx,
+		JobSend,
+		sendPayload{Email: email, Locale: locale},
+		jobs.EnqueueOptions{Key: "password_reset:" + email},
+	)
+	if err != nil && !errors.Is(err, jobs.ErrDuplicateJob) {
+		return err
+	}
+	return nil
+}
+
+func (s service) Send(ctx context.Context, email string, locale string) error {
+	user, err := s.usersService.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	lastSent, err := s.tokensService.LastIssuedAt(ctx, user.Id, tokens.PurposeResetPassword)
+	if err != nil {
+		return err
+	}
+	if !lastSent.IsZero() && time.Since(lastSent) < s.resendInterval {
+		return nil
+	}
+	token, _, err := s.tokensService.Issue(ctx, user.Id, tokens.PurposeResetPassword, user.Email, s.tokenTTL)
+	if err != nil {
+		return err
+	}
+	data := mailer.ResetPasswordData{Link: s.link(token), ValidFor: int(s.tokenTTL.Minutes())}
+	if err := s.mailerService.Queue(ctx, user.Email, mailer.TemplateResetPassword, locale, data); err != nil {
+		s.logger.Error("cannot send password reset email", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) Reset(ctx context.Context, token string, newPassword string) (users.User, error) {
+	// checked before the token is used up so that a rejected password does
+	// not cost the user their link
+	if len(newPassword) < users.MinPasswordLength {
+		return users.User{}, users.ErrPasswordTooShort
+	}
+	consumed, err := s.tokensService.Consume(ctx, tokens.PurposeResetPassword, token)
+	if err != nil {
+		if errors.Is(err, tokens.ErrTokenNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	user, err := s.usersService.GetUserById(ctx, consumed.UserId)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	if user.Email != consumed.Email {
+		return users.User{}, ErrInvalidToken
+	}
+	if err := s.usersService.SetPassword(ctx, user.Id, newPassword); err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	// whoever knew the old password is signed out as well
+	if err := s.authService.DeleteSessionByUserId(ctx, user.Id); err != nil {
+		return users.User{}, err
+	}
+	return user, nil
+}
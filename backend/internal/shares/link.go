@@ -0,0 +1,94 @@
+package shares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrLinkNotFound     = errors.New("share link not found")
+	ErrLinkExpired      = errors.New("share link expired")
+	ErrPasswordRequired = errors.New("share link password required")
+	ErrInvalidPassword  = errors.New("invalid share link password")
+	ErrInvalidLink      = errors.New("invalid share link")
+	ErrLocked           = errors.New("too many invalid share link passwords")
+)
+
+// LockedError is returned while passwords are refused after too many
+// invalid ones, it matches ErrLocked.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrLocked, e.RetryAfter)
+}
+
+func (e LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Link grants anonymous access to an image or an album to whoever knows its
+// token, for as long as it has not expired and has views left. Exactly one
+// of ImageId and AlbumId is valid.
+type Link struct {
+	Token   string
+	OwnerId uuid.UUID
+	ImageId uuid.NullUUID
+	AlbumId uuid.NullUUID
+	// ExpiresAt is zero for links that never expire.
+	ExpiresAt time.Time
+	// MaxViews is zero for links with unlimited views. Only resolving the
+	// link counts as a view, the images of a shared album are not counted.
+	MaxViews     int
+	Views        int
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+func (l Link) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// Valid reports whether the link can still be resolved at now.
+func (l Link) Valid(now time.Time) bool {
+	if l.Expired(now) {
+		return false
+	}
+	return l.MaxViews == 0 || l.Views < l.MaxViews
+}
+
+type CreateOptions struct {
+	ImageId   uuid.NullUUID
+	AlbumId   uuid.NullUUID
+	ExpiresAt time.Time
+	MaxViews  int
+	Password  string
+}
+
+type Repository interface {
+	CreateLink(ctx context.Context, link Link) (Link, error)
+	GetLinkByToken(ctx context.Context, token string) (Link, error)
+	GetLinksByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Link, error)
+	// ConsumeView counts a view of the link if it is still valid at now and
+	// returns ErrLinkExpired otherwise. The check and the increment are a
+	// single statement so that concurrent visitors cannot exceed MaxViews.
+	ConsumeView(ctx context.Context, token string, now time.Time) (Link, error)
+	// CountAttempt counts a password attempt on the link unless it is
+	// locked at now. Once more than maxFailures attempts are counted in a
+	// row the link is locked until lockedUntil. It returns when the link is
+	// locked until, which is not after now while attempts are allowed.
+	CountAttempt(ctx context.Context, token string, now time.Time, maxFailures int, lockedUntil time.Time) (time.Time, error)
+	// ResetAttempts forgets the attempts counted on the link after a valid
+	// password.
+	ResetAttempts(ctx context.Context, token string) error
+	DeleteLink(ctx context.Context, token string) error
+}
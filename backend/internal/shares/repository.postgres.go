@@ -0,0 +1,238 @@
+package shares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "shares.repo.pg"
+
+const linkColumns = `token, owner_id, image_id, album_id, expires_at, max_views, views, password_hash, created_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgLink struct {
+	token        string
+	ownerId      pgtype.UUID
+	imageId      pgtype.UUID
+	albumId      pgtype.UUID
+	expiresAt    pgtype.Timestamp
+	maxViews     int32
+	views        int32
+	passwordHash string
+	createdAt    pgtype.Timestamp
+}
+
+func (l *pgLink) scanTargets() []any {
+	return []any{
+		&l.token,
+		&l.ownerId,
+		&l.imageId,
+		&l.albumId,
+		&l.expiresAt,
+		&l.maxViews,
+		&l.views,
+		&l.passwordHash,
+		&l.createdAt,
+	}
+}
+
+func fromPGLink(link pgLink) (Link, error) {
+	ownerId, err := uuid.FromBytes(link.ownerId.Bytes[:])
+	if err != nil {
+		return Link{}, err
+	}
+	var imageId, albumId uuid.NullUUID
+	if link.imageId.Valid {
+		imageId = uuid.NullUUID{UUID: uuid.UUID(link.imageId.Bytes), Valid: true}
+	}
+	if link.albumId.Valid {
+		albumId = uuid.NullUUID{UUID: uuid.UUID(link.albumId.Bytes), Valid: true}
+	}
+	return Link{
+		Token:        link.token,
+		OwnerId:      ownerId,
+		ImageId:      imageId,
+		AlbumId:      albumId,
+		ExpiresAt:    link.expiresAt.Time,
+		MaxViews:     int(link.maxViews),
+		Views:        int(link.views),
+		PasswordHash: link.passwordHash,
+		CreatedAt:    link.createdAt.Time,
+	}, nil
+}
+
+func toPGLink(link Link) pgLink {
+	return pgLink{
+		token:        link.Token,
+		ownerId:      pgtype.UUID{Bytes: [16]byte(link.OwnerId.Bytes()), Valid: true},
+		imageId:      pgtype.UUID{Bytes: [16]byte(link.ImageId.UUID.Bytes()), Valid: link.ImageId.Valid},
+		albumId:      pgtype.UUID{Bytes: [16]byte(link.AlbumId.UUID.Bytes()), Valid: link.AlbumId.Valid},
+		expiresAt:    pgtype.Timestamp{Time: link.ExpiresAt.UTC(), Valid: !link.ExpiresAt.IsZero()},
+		maxViews:     int32(link.MaxViews),
+		views:        int32(link.Views),
+		passwordHash: link.PasswordHash,
+		createdAt:    pgtype.Timestamp{Time: link.CreatedAt.UTC(), Valid: true},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateLink(ctx context.Context, link Link) (Link, error) {
+	const op = postgresRepositorySource + ".CreateLink"
+	query := `
+INSERT INTO share_links (` + linkColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING ` + linkColumns
+
+	toCreate := toPGLink(link)
+	var created pgLink
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		toCreate.token,
+		toCreate.ownerId,
+		toCreate.imageId,
+		toCreate.albumId,
+		toCreate.expiresAt,
+		toCreate.maxViews,
+		toCreate.views,
+		toCreate.passwordHash,
+		toCreate.createdAt,
+	).Scan(created.scanTargets()...); err != nil {
+		return Link{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGLink(created)
+}
+
+func (r *postgresRepository) GetLinkByToken(ctx context.Context, token string) (Link, error) {
+	const op = postgresRepositorySource + ".GetLinkByToken"
+	query := `SELECT ` + linkColumns + ` FROM share_links WHERE token = $1`
+	var link pgLink
+	if err := r.db.QueryRow(ctx, query, token).Scan(link.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Link{}, ErrLinkNotFound
+		}
+		return Link{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGLink(link)
+}
+
+func (r *postgresRepository) GetLinksByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Link, error) {
+	const op = postgresRepositorySource + ".GetLinksByOwnerId"
+	query := `SELECT ` + linkColumns + ` FROM share_links WHERE owner_id = $1 ORDER BY created_at DESC`
+	rows, err := r.db.Query(ctx, query, ownerId)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var links []Link
+	for rows.Next() {
+		var pgLink pgLink
+		if err := rows.Scan(pgLink.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		link, err := fromPGLink(pgLink)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return links, nil
+}
+
+func (r *postgresRepository) ConsumeView(ctx context.Context, token string, now time.Time) (Link, error) {
+	const op = postgresRepositorySource + ".ConsumeView"
+	query := `
+UPDATE share_links SET views = views + 1
+	WHERE token = $1
+		AND (expires_at IS NULL OR expires_at > $2)
+		AND (max_views = 0 OR views < max_views)
+	RETURNING ` + linkColumns
+
+	var link pgLink
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		token,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	).Scan(link.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Link{}, ErrLinkExpired
+		}
+		return Link{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGLink(link)
+}
+
+func (r *postgresRepository) CountAttempt(
+	ctx context.Context,
+	token string,
+	now time.Time,
+	maxFailures int,
+	lockedUntil time.Time,
+) (time.Time, error) {
+	const op = postgresRepositorySource + ".CountAttempt"
+	// the row lock taken by the update serializes concurrent attempts
+	query := `
+UPDATE share_links SET
+	failed_attempts = CASE WHEN failed_attempts + 1 > $3 THEN 0 ELSE failed_attempts + 1 END,
+	locked_until = CASE WHEN failed_attempts + 1 > $3 THEN $4 ELSE NULL END
+	WHERE token = $1 AND (locked_until IS NULL OR locked_until <= $2)
+	RETURNING locked_until`
+	lockedQuery := `SELECT locked_until FROM share_links WHERE token = $1`
+
+	var locked pgtype.Timestamp
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		token,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		maxFailures,
+		pgtype.Timestamp{Time: lockedUntil.UTC(), Valid: true},
+	).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// locked already, or the link is gone
+		err = r.db.QueryRow(ctx, lockedQuery, token).Scan(&locked)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, ErrLinkNotFound
+		}
+		return time.Time{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return locked.Time, nil
+}
+
+func (r *postgresRepository) ResetAttempts(ctx context.Context, token string) error {
+	const op = postgresRepositorySource + ".ResetAttempts"
+	query := `UPDATE share_links SET failed_attempts = 0, locked_until = NULL WHERE token = $1 AND failed_attempts > 0`
+	if _, err := r.db.Exec(ctx, query, token); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) DeleteLink(ctx context.Context, token string) error {
+	const op = postgresRepositorySource + ".DeleteLink"
+	query := `DELETE FROM share_links WHERE token = $1`
+	if _, err := r.db.Exec(ctx, query, token); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
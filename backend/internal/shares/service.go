@@ -0,0 +1,271 @@
+package shares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/albums"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/pkg/password"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"time"
+)
+
+const tokenSize = 24
+
+type Service interface {
+	Create(ctx context.Context, ownerId uuid.UUID, opts CreateOptions) (Link, error)
+	GetLinksByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Link, error)
+	Delete(ctx context.Context, ownerId uuid.UUID, token string) error
+	// Resolve checks the password of the link and counts a view. It fails
+	// with ErrLinkExpired once the link is past its expiry or view limit.
+	Resolve(ctx context.Context, token string, password string) (Link, error)
+	// AuthorizeAlbumImage checks the expiry and password of an album link
+	// without counting a view, for the images listed on the album page. The
+	// page load is what counts as a view, so the view limit applies to the
+	// page only and its images stay available until the link expires. Image
+	// links fail with ErrLinkNotFound, their views are always counted.
+	AuthorizeAlbumImage(ctx context.Context, token string, password string) (Link, error)
+}
+
+type Options struct {
+	// MaxFailures passwords checked in a row without a valid one lock the
+	// link for Lockout.
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+type service struct {
+	repository    Repository
+	imagesService images.Service
+	albumsService albums.Service
+	opts          Options
+	timeout       time.Duration
+	logger        *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	imagesService images.Service,
+	albumsService albums.Service,
+	opts Options,
+	timeout time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		repository:    repository,
+		imagesService: imagesService,
+		albumsService: albumsService,
+		opts:          opts,
+		timeout:       timeout,
+		logger:        logger,
+	}
+}
+
+func (s service) generateTokenBytes() ([tokenSize]byte, error) {
+	token := [tokenSize]byte{}
+	n, err := rand.Read(token[:])
+	if err != nil {
+		return token, err
+	}
+	if n != tokenSize {
+		return token, fmt.Errorf("expected %d bytes, got %d", tokenSize, n)
+	}
+	return token, nil
+}
+
+func (s service) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (s service) Create(ctx context.Context, ownerId uuid.UUID, opts CreateOptions) (Link, error) {
+	now := time.Now().UTC()
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(now) {
+		return Link{}, fmt.Errorf("%w: expiry must be in the future", ErrInvalidLink)
+	}
+	if opts.MaxViews < 0 {
+		return Link{}, fmt.Errorf("%w: max views must not be negative", ErrInvalidLink)
+	}
+	if err := s.checkTarget(ctx, ownerId, opts); err != nil {
+		return Link{}, err
+	}
+
+	tokenBytes, err := s.generateTokenBytes()
+	if err != nil {
+		s.logger.Error("unable to generate share token", "error", err)
+		return Link{}, err
+	}
+	link := Link{
+		Token:     hex.EncodeToString(tokenBytes[:]),
+		OwnerId:   ownerId,
+		ImageId:   opts.ImageId,
+		AlbumId:   opts.AlbumId,
+		ExpiresAt: opts.ExpiresAt.UTC(),
+		MaxViews:  opts.MaxViews,
+		CreatedAt: now,
+	}
+	if opts.Password != "" {
+		if link.PasswordHash, err = s.hashPassword(opts.Password); err != nil {
+			s.logger.Error("unable to hash share password", "error", err)
+			return Link{}, err
+		}
+	}
+
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	created, err := s.repository.CreateLink(c, link)
+	if err != nil {
+		s.logger.Error("cannot create share link", "error", err)
+		return Link{}, err
+	}
+	return created, nil
+}
+
+// checkTarget makes sure the link points at exactly one image or album of
+// the owner. Someone else's image or album looks exactly like a missing one.
+func (s service) checkTarget(ctx context.Context, ownerId uuid.UUID, opts CreateOptions) error {
+	viewerId := uuid.NullUUID{UUID: ownerId, Valid: true}
+	switch {
+	case opts.ImageId.Valid == opts.AlbumId.Valid:
+		return fmt.Errorf("%w: either an image or an album is required", ErrInvalidLink)
+	case opts.ImageId.Valid:
+		image, err := s.imagesService.GetImageById(ctx, opts.ImageId.UUID)
+		if err != nil {
+			return err
+		}
+		if err := s.imagesService.Authorize(viewerId, image.Resource(), images.ActionManage); err != nil {
+			return images.ErrImageNotFound
+		}
+	default:
+		album, err := s.albumsService.GetAlbumById(ctx, opts.AlbumId.UUID)
+		if err != nil {
+			return err
+		}
+		if err := s.imagesService.Authorize(viewerId, album.Resource(), images.ActionManage); err != nil {
+			return albums.ErrAlbumNotFound
+		}
+	}
+	return nil
+}
+
+func (s service) GetLinksByOwnerId(ctx context.Context, ownerId uuid.UUID) ([]Link, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	links, err := s.repository.GetLinksByOwnerId(c, ownerId)
+	if err != nil {
+		s.logger.Error("cannot get share links by owner id", "error", err)
+		return []Link{}, err
+	}
+	return links, nil
+}
+
+func (s service) Delete(ctx context.Context, ownerId uuid.UUID, token string) error {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	link, err := s.repository.GetLinkByToken(c, token)
+	if err != nil {
+		if !errors.Is(err, ErrLinkNotFound) {
+			s.logger.Error("cannot get share link", "error", err)
+		}
+		return err
+	}
+	if link.OwnerId != ownerId {
+		return ErrLinkNotFound
+	}
+	if err := s.repository.DeleteLink(c, token); err != nil {
+		s.logger.Error("cannot delete share link", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) AuthorizeAlbumImage(ctx context.Context, token string, pass string) (Link, error) {
+	link, err := s.authorize(ctx, token, pass)
+	if err != nil {
+		return Link{}, err
+	}
+	if !link.AlbumId.Valid {
+		return Link{}, ErrLinkNotFound
+	}
+	return link, nil
+}
+
+func (s service) authorize(ctx context.Context, token string, pass string) (Link, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	link, err := s.repository.GetLinkByToken(c, token)
+	if err != nil {
+		if !errors.Is(err, ErrLinkNotFound) {
+			s.logger.Error("cannot get share link", "error", err)
+		}
+		return Link{}, err
+	}
+	if link.Expired(time.Now().UTC()) {
+		return Link{}, ErrLinkExpired
+	}
+	if link.HasPassword() {
+		if pass == "" {
+			return Link{}, ErrPasswordRequired
+		}
+		if err := s.checkPassword(ctx, link, pass); err != nil {
+			return Link{}, err
+		}
+	}
+	return link, nil
+}
+
+// checkPassword compares pass with the password of link. The attempt counts
+// as a failure until the password turns out valid, so that parallel guesses
+// are limited like sequential ones.
+func (s service) checkPassword(ctx context.Context, link Link, pass string) error {
+	now := time.Now().UTC()
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	lockedUntil, err := s.repository.CountAttempt(c, link.Token, now, s.opts.MaxFailures, now.Add(s.opts.Lockout))
+	cancel()
+	if err != nil {
+		if !errors.Is(err, ErrLinkNotFound) {
+			s.logger.Error("cannot count share link password attempt", "error", err)
+		}
+		return err
+	}
+	if lockedUntil.After(now) {
+		return LockedError{RetryAfter: lockedUntil.Sub(now)}
+	}
+	if !password.Compare(pass, link.PasswordHash) {
+		return ErrInvalidPassword
+	}
+	c, cancel = context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.ResetAttempts(c, link.Token); err != nil {
+		s.logger.Error("cannot reset share link password attempts", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) Resolve(ctx context.Context, token string, pass string) (Link, error) {
+	// the password is checked before the view is counted so that wrong
+	// guesses do not use up the link
+	link, err := s.authorize(ctx, token, pass)
+	if err != nil {
+		return Link{}, err
+	}
+	if !link.Valid(time.Now().UTC()) {
+		return Link{}, ErrLinkExpired
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	link, err = s.repository.ConsumeView(c, link.Token, time.Now().UTC())
+	if err != nil && !errors.Is(err, ErrLinkExpired) {
+		s.logger.Error("cannot consume share link view", "error", err)
+	}
+	return link, err
+}
@@ -0,0 +1,269 @@
+package shares
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryLink struct {
+	Link
+	failedAttempts int
+	lockedUntil    time.Time
+}
+
+// memoryRepository keeps links in memory. A single mutex stands in for the
+// row lock the postgres statements take.
+type memoryRepository struct {
+	mu    sync.Mutex
+	links map[string]*memoryLink
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{links: map[string]*memoryLink{}}
+}
+
+func (r *memoryRepository) CreateLink(_ context.Context, link Link) (Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.links[link.Token] = &memoryLink{Link: link}
+	return link, nil
+}
+
+func (r *memoryRepository) GetLinkByToken(_ context.Context, token string) (Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[token]
+	if !ok {
+		return Link{}, ErrLinkNotFound
+	}
+	return link.Link, nil
+}
+
+func (r *memoryRepository) GetLinksByOwnerId(_ context.Context, ownerId uuid.UUID) ([]Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var links []Link
+	for _, link := range r.links {
+		if link.OwnerId == ownerId {
+			links = append(links, link.Link)
+		}
+	}
+	return links, nil
+}
+
+func (r *memoryRepository) ConsumeView(_ context.Context, token string, now time.Time) (Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[token]
+	if !ok || !link.Valid(now) {
+		return Link{}, ErrLinkExpired
+	}
+	link.Views++
+	return link.Link, nil
+}
+
+func (r *memoryRepository) CountAttempt(
+	_ context.Context,
+	token string,
+	now time.Time,
+	maxFailures int,
+	lockedUntil time.Time,
+) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[token]
+	if !ok {
+		return time.Time{}, ErrLinkNotFound
+	}
+	if link.lockedUntil.After(now) {
+		return link.lockedUntil, nil
+	}
+	link.failedAttempts++
+	link.lockedUntil = time.Time{}
+	if link.failedAttempts > maxFailures {
+		link.failedAttempts = 0
+		link.lockedUntil = lockedUntil
+	}
+	return link.lockedUntil, nil
+}
+
+func (r *memoryRepository) ResetAttempts(_ context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if link, ok := r.links[token]; ok {
+		link.failedAttempts = 0
+		link.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (r *memoryRepository) DeleteLink(_ context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.links, token)
+	return nil
+}
+
+const (
+	testMaxFailures = 5
+	testPassword    = "correct horse"
+)
+
+// protectedLink returns a service and the token of a password protected link
+// of an image.
+func protectedLink(t *testing.T, maxViews int) (service, string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := newMemoryRepository()
+	link := Link{
+		Token:        "token",
+		OwnerId:      uuid.Must(uuid.NewV4()),
+		ImageId:      uuid.NullUUID{UUID: uuid.Must(uuid.NewV4()), Valid: true},
+		MaxViews:     maxViews,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().UTC(),
+	}
+	if _, err := repository.CreateLink(context.Background(), link); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(repository, nil, nil, Options{
+		MaxFailures: testMaxFailures,
+		Lockout:     time.Minute,
+	}, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return s.(service), link.Token
+}
+
+func TestResolveLimitsConcurrentGuesses(t *testing.T) {
+	svc, token := protectedLink(t, 0)
+
+	const guesses = 50
+	errs := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.Resolve(context.Background(), token, "wrong")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	invalid, locked := 0, 0
+	for err := range errs {
+		switch {
+		case errors.Is(err, ErrInvalidPassword):
+			invalid++
+		case errors.Is(err, ErrLocked):
+			locked++
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if invalid != testMaxFailures {
+		t.Errorf("%d guesses were checked, want %d", invalid, testMaxFailures)
+	}
+	if locked != guesses-testMaxFailures {
+		t.Errorf("%d guesses were refused as locked, want %d", locked, guesses-testMaxFailures)
+	}
+}
+
+func TestResolveRefusesValidPasswordWhileLocked(t *testing.T) {
+	svc, token := protectedLink(t, 1)
+	ctx := context.Background()
+	for i := 0; i < testMaxFailures; i++ {
+		if _, err := svc.Resolve(ctx, token, "wrong"); !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("guess %d: got %v, want ErrInvalidPassword", i, err)
+		}
+	}
+	var locked LockedError
+	if _, err := svc.Resolve(ctx, token, testPassword); !errors.As(err, &locked) || locked.RetryAfter <= 0 {
+		t.Fatalf("got %v, want a LockedError", err)
+	}
+	// neither the guesses nor the locked attempt used up the only view
+	link, err := svc.repository.GetLinkByToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Views != 0 {
+		t.Fatalf("views = %d, want 0", link.Views)
+	}
+}
+
+func TestResolveResetsAttemptsOnValidPassword(t *testing.T) {
+	svc, token := protectedLink(t, 0)
+	ctx := context.Background()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < testMaxFailures-1; i++ {
+			if _, err := svc.Resolve(ctx, token, "wrong"); !errors.Is(err, ErrInvalidPassword) {
+				t.Fatalf("round %d guess %d: got %v, want ErrInvalidPassword", round, i, err)
+			}
+		}
+		if _, err := svc.Resolve(ctx, token, testPassword); err != nil {
+			t.Fatalf("round %d: valid password refused: %v", round, err)
+		}
+	}
+}
+
+func TestResolveMissingPasswordIsNotCounted(t *testing.T) {
+	svc, token := protectedLink(t, 0)
+	ctx := context.Background()
+	for i := 0; i < 2*testMaxFailures; i++ {
+		if _, err := svc.Resolve(ctx, token, ""); !errors.Is(err, ErrPasswordRequired) {
+			t.Fatalf("attempt %d: got %v, want ErrPasswordRequired", i, err)
+		}
+	}
+	if _, err := svc.Resolve(ctx, token, testPassword); err != nil {
+		t.Fatalf("valid password refused: %v", err)
+	}
+}
+
+func TestAuthorizeAlbumImage(t *testing.T) {
+	svc, imageToken := protectedLink(t, 1)
+	ctx := context.Background()
+	album := Link{
+		Token:     "album",
+		OwnerId:   uuid.Must(uuid.NewV4()),
+		AlbumId:   uuid.NullUUID{UUID: uuid.Must(uuid.NewV4()), Valid: true},
+		MaxViews:  1,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := svc.repository.CreateLink(ctx, album); err != nil {
+		t.Fatal(err)
+	}
+
+	// the views of an image link cannot be dodged through the album images
+	if _, err := svc.AuthorizeAlbumImage(ctx, imageToken, testPassword); !errors.Is(err, ErrLinkNotFound) {
+		t.Fatalf("image link: got %v, want ErrLinkNotFound", err)
+	}
+	if _, err := svc.Resolve(ctx, album.Token, ""); err != nil {
+		t.Fatalf("album page refused: %v", err)
+	}
+	if _, err := svc.Resolve(ctx, album.Token, ""); !errors.Is(err, ErrLinkExpired) {
+		t.Fatalf("second album page: got %v, want ErrLinkExpired", err)
+	}
+	// the images of the page that used up the last view still load
+	for i := 0; i < 3; i++ {
+		if _, err := svc.AuthorizeAlbumImage(ctx, album.Token, ""); err != nil {
+			t.Fatalf("album image %d refused: %v", i, err)
+		}
+	}
+	link, err := svc.repository.GetLinkByToken(ctx, album.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Views != 1 {
+		t.Fatalf("views = %d, want 1", link.Views)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidKey     = errors.New("invalid object key")
+)
+
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModifiedAt  time.Time
+}
+
+type PutOptions struct {
+	ContentType string
+	// Size of the content if known up front, zero or negative otherwise.
+	Size int64
+}
+
+// Storage keeps the bytes of uploaded files. Keys are slash separated
+// relative paths such as "originals/<id>.png"; metadata about what the
+// objects are lives in the postgres repositories.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (Object, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+	// Move renames the object stored under src to dst, replacing whatever
+	// dst held before.
+	Move(ctx context.Context, src, dst string) (Object, error)
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// Presigner is implemented by storages that can hand out time limited URLs
+// so that clients download objects without going through the API.
+type Presigner interface {
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Copy streams the object stored under key into w.
+func Copy(ctx context.Context, s Storage, key string, w io.Writer) (int64, error) {
+	r, _, err := s.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
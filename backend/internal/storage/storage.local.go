@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	localStorageSource = "storage.local"
+	localTempPrefix    = ".tmp-"
+)
+
+type localStorage struct {
+	root string
+}
+
+// NewLocalStorage stores objects under root, sharded into two levels of
+// directories named after the leading bytes of the key's SHA-256 so that no
+// single directory grows too large. Writes go to a temp file in the target
+// directory that is renamed into place, so readers never see partial objects.
+func NewLocalStorage(root string) (Storage, error) {
+	const op = localStorageSource + ".NewLocalStorage"
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return &localStorage{root: root}, nil
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	prefix := hex.EncodeToString(sum[:2])
+	return filepath.Join(s.root, prefix[:2], prefix[2:], url.PathEscape(key)), nil
+}
+
+func (s *localStorage) object(key string, info fs.FileInfo) Object {
+	return Object{
+		Key:        key,
+		Size:       info.Size(),
+		ModifiedAt: info.ModTime().UTC(),
+	}
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, _ PutOptions) (Object, error) {
+	const op = localStorageSource + ".Put"
+	path, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	tmp, err := os.CreateTemp(dir, localTempPrefix+"*")
+	if err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: r}); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o640); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	committed = true
+	return s.Stat(ctx, key)
+}
+
+func (s *localStorage) Get(_ context.Context, key string) (io.ReadCloser, Object, error) {
+	const op = localStorageSource + ".Get"
+	path, err := s.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, Object{}, ErrObjectNotFound
+		}
+		return nil, Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return file, s.object(key, info), nil
+}
+
+func (s *localStorage) Stat(_ context.Context, key string) (Object, error) {
+	const op = localStorageSource + ".Stat"
+	path, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Object{}, ErrObjectNotFound
+		}
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return s.object(key, info), nil
+}
+
+func (s *localStorage) Delete(_ context.Context, key string) error {
+	const op = localStorageSource + ".Delete"
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (s *localStorage) Move(ctx context.Context, src, dst string) (Object, error) {
+	const op = localStorageSource + ".Move"
+	srcPath, err := s.path(src)
+	if err != nil {
+		return Object{}, err
+	}
+	dstPath, err := s.path(dst)
+	if err != nil {
+		return Object{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o750); err != nil {
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Object{}, ErrObjectNotFound
+		}
+		return Object{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return s.Stat(ctx, dst)
+}
+
+// List walks every shard, so it is meant for maintenance tasks rather than
+// request handling.
+func (s *localStorage) List(ctx context.Context, prefix string) ([]Object, error) {
+	const op = localStorageSource + ".List"
+	var objects []Object
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localTempPrefix) {
+			return nil
+		}
+		key, err := url.PathUnescape(d.Name())
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, s.object(key, info))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// contextReader stops a copy once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
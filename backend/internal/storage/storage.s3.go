@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	s3StorageSource = "storage.s3"
+
+	s3NoSuchKeyCode = "NoSuchKey"
+)
+
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyId     string
+	SecretAccessKey string
+	UseSSL          bool
+	// PathStyle addresses buckets as endpoint/bucket instead of
+	// bucket.endpoint, which is what MinIO and most local S3 stand-ins expect.
+	PathStyle bool
+	// PartSize is the size of a single part of a multipart upload. Uploads
+	// of unknown size are buffered one part at a time.
+	PartSize uint64
+}
+
+type s3Storage struct {
+	client   *minio.Client
+	bucket   string
+	partSize uint64
+}
+
+// NewS3Storage connects to an S3 compatible API and creates the bucket if it
+// does not exist yet.
+func NewS3Storage(ctx context.Context, opts S3Options) (Storage, error) {
+	const op = s3StorageSource + ".NewS3Storage"
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKeyId, opts.SecretAccessKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{Region: opts.Region}); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+	}
+	return &s3Storage{
+		client:   client,
+		bucket:   opts.Bucket,
+		partSize: opts.PartSize,
+	}, nil
+}
+
+func (s *s3Storage) wrapError(op string, err error) error {
+	if minio.ToErrorResponse(err).Code == s3NoSuchKeyCode {
+		return ErrObjectNotFound
+	}
+	return fmt.Errorf("[%s]: %w", op, err)
+}
+
+func (s *s3Storage) object(info minio.ObjectInfo) Object {
+	return Object{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ModifiedAt:  info.LastModified.UTC(),
+	}
+}
+
+// Put streams r to the bucket. Content larger than a single part, or of
+// unknown size, is sent as a multipart upload.
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) (Object, error) {
+	const op = s3StorageSource + ".Put"
+	if err := validateKey(key); err != nil {
+		return Object{}, err
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = -1
+	}
+	info, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: opts.ContentType,
+		PartSize:    s.partSize,
+	})
+	if err != nil {
+		return Object{}, s.wrapError(op, err)
+	}
+	return Object{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: opts.ContentType,
+		ModifiedAt:  info.LastModified.UTC(),
+	}, nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	const op = s3StorageSource + ".Get"
+	if err := validateKey(key); err != nil {
+		return nil, Object{}, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Object{}, s.wrapError(op, err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, Object{}, s.wrapError(op, err)
+	}
+	return obj, s.object(info), nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, key string) (Object, error) {
+	const op = s3StorageSource + ".Stat"
+	if err := validateKey(key); err != nil {
+		return Object{}, err
+	}
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Object{}, s.wrapError(op, err)
+	}
+	return s.object(info), nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	const op = s3StorageSource + ".Delete"
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		if errors.Is(s.wrapError(op, err), ErrObjectNotFound) {
+			return nil
+		}
+		return s.wrapError(op, err)
+	}
+	return nil
+}
+
+// Move copies src to dst on the server side and removes src, S3 has no
+// rename.
+func (s *s3Storage) Move(ctx context.Context, src, dst string) (Object, error) {
+	const op = s3StorageSource + ".Move"
+	if err := validateKey(src); err != nil {
+		return Object{}, err
+	}
+	if err := validateKey(dst); err != nil {
+		return Object{}, err
+	}
+	if _, err := s.client.CopyObject(
+		ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src},
+	); err != nil {
+		return Object{}, s.wrapError(op, err)
+	}
+	if err := s.Delete(ctx, src); err != nil {
+		return Object{}, err
+	}
+	return s.Stat(ctx, dst)
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]Object, error) {
+	const op = s3StorageSource + ".List"
+	var objects []Object
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, s.wrapError(op, info.Err)
+		}
+		objects = append(objects, s.object(info))
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	const op = s3StorageSource + ".PresignGet"
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, url.Values{})
+	if err != nil {
+		return "", s.wrapError(op, err)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,18 @@
+package tokens
+
+import (
+	"context"
+	"github.com/plinkplenk/img-share/internal/jobs"
+)
+
+const JobDeleteExpiredTokens = "tokens.delete_expired"
+
+// RegisterJobs makes runner execute the tokens jobs and schedules the
+// hourly cleanup of expired tokens.
+func RegisterJobs(runner *jobs.Runner, service Service) error {
+	runner.Handle(JobDeleteExpiredTokens, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.DeleteExpired(ctx)
+		return err
+	})
+	return runner.Schedule(JobDeleteExpiredTokens, "@hourly", JobDeleteExpiredTokens, nil)
+}
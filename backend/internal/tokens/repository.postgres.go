@@ -0,0 +1,178 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "tokens.repo.pg"
+
+const tokenColumns = `id, user_id, purpose, hash, email, expires_at, created_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgToken struct {
+	id        pgtype.UUID
+	userId    pgtype.UUID
+	purpose   string
+	hash      string
+	email     string
+	expiresAt pgtype.Timestamp
+	createdAt pgtype.Timestamp
+}
+
+func (t *pgToken) scanTargets() []any {
+	return []any{&t.id, &t.userId, &t.purpose, &t.hash, &t.email, &t.expiresAt, &t.createdAt}
+}
+
+func fromPGToken(token pgToken) Token {
+	return Token{
+		Id:        uuid.UUID(token.id.Bytes),
+		UserId:    uuid.UUID(token.userId.Bytes),
+		Purpose:   Purpose(token.purpose),
+		Hash:      token.hash,
+		Email:     token.email,
+		ExpiresAt: token.expiresAt.Time,
+		CreatedAt: token.createdAt.Time,
+	}
+}
+
+func toPGToken(token Token) pgToken {
+	return pgToken{
+		id:        pgtype.UUID{Bytes: [16]byte(token.Id.Bytes()), Valid: true},
+		userId:    pgtype.UUID{Bytes: [16]byte(token.UserId.Bytes()), Valid: true},
+		purpose:   string(token.Purpose),
+		hash:      token.Hash,
+		email:     token.Email,
+		expiresAt: pgtype.Timestamp{Time: token.ExpiresAt.UTC(), Valid: true},
+		createdAt: pgtype.Timestamp{Time: token.CreatedAt.UTC(), Valid: true},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateToken(ctx context.Context, token Token) (Token, error) {
+	const op = postgresRepositorySource + ".CreateToken"
+	deleteQuery := `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2`
+	createQuery := `
+INSERT INTO user_tokens (` + tokenColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + tokenColumns
+
+	toCreate := toPGToken(token)
+	var created pgToken
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, deleteQuery, toCreate.userId, toCreate.purpose); err != nil {
+			return err
+		}
+		return tx.QueryRow(
+			ctx,
+			createQuery,
+			toCreate.id,
+			toCreate.userId,
+			toCreate.purpose,
+			toCreate.hash,
+			toCreate.email,
+			toCreate.expiresAt,
+			toCreate.createdAt,
+		).Scan(created.scanTargets()...)
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGToken(created), nil
+}
+
+func (r *postgresRepository) ConsumeToken(ctx context.Context, purpose Purpose, hash string, now time.Time) (Token, error) {
+	const op = postgresRepositorySource + ".ConsumeToken"
+	query := `
+DELETE FROM user_tokens
+	WHERE purpose = $1 AND hash = $2 AND expires_at > $3
+	RETURNING ` + tokenColumns
+
+	var token pgToken
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		string(purpose),
+		hash,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	).Scan(token.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Token{}, ErrTokenNotFound
+		}
+		return Token{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGToken(token), nil
+}
+
+func (r *postgresRepository) GetToken(ctx context.Context, purpose Purpose, hash string, now time.Time) (Token, error) {
+	const op = postgresRepositorySource + ".GetToken"
+	query := `
+SELECT ` + tokenColumns + ` FROM user_tokens
+	WHERE purpose = $1 AND hash = $2 AND expires_at > $3`
+
+	var token pgToken
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		string(purpose),
+		hash,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+	).Scan(token.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Token{}, ErrTokenNotFound
+		}
+		return Token{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGToken(token), nil
+}
+
+func (r *postgresRepository) GetLatestToken(ctx context.Context, userId uuid.UUID, purpose Purpose) (Token, error) {
+	const op = postgresRepositorySource + ".GetLatestToken"
+	query := `
+SELECT ` + tokenColumns + ` FROM user_tokens
+	WHERE user_id = $1 AND purpose = $2
+	ORDER BY created_at DESC
+	LIMIT 1`
+
+	var token pgToken
+	if err := r.db.QueryRow(ctx, query, userId, string(purpose)).Scan(token.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Token{}, ErrTokenNotFound
+		}
+		return Token{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGToken(token), nil
+}
+
+func (r *postgresRepository) DeleteTokens(ctx context.Context, userId uuid.UUID, purpose Purpose) error {
+	const op = postgresRepositorySource + ".DeleteTokens"
+	query := `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2`
+	if _, err := r.db.Exec(ctx, query, userId, string(purpose)); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) DeleteExpiredTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = postgresRepositorySource + ".DeleteExpiredTokens"
+	query := `DELETE FROM user_tokens WHERE expires_at <= $1`
+	tag, err := r.db.Exec(ctx, query, pgtype.Timestamp{Time: now.UTC(), Valid: true})
+	if err != nil {
+		return 0, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return tag.RowsAffected(), nil
+}
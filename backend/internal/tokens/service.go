@@ -0,0 +1,147 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"log/slog"
+	"time"
+)
+
+const tokenSize = 32
+
+type Service interface {
+	// Issue creates a token of purpose for userId sent to email and valid for
+	// ttl, invalidating the previous ones. It returns the secret to mail,
+	// which is not stored anywhere.
+	Issue(ctx context.Context, userId uuid.UUID, purpose Purpose, email string, ttl time.Duration) (string, Token, error)
+	// Consume uses up the token of purpose whose secret is secret.
+	Consume(ctx context.Context, purpose Purpose, secret string) (Token, error)
+	// Lookup returns the token of purpose whose secret is secret, leaving it
+	// valid.
+	Lookup(ctx context.Context, purpose Purpose, secret string) (Token, error)
+	// LastIssuedAt returns when the latest token of purpose was issued to
+	// userId, the zero time when there is none.
+	LastIssuedAt(ctx context.Context, userId uuid.UUID, purpose Purpose) (time.Time, error)
+	Revoke(ctx context.Context, userId uuid.UUID, purpose Purpose) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type service struct {
+	repository Repository
+	timeout    time.Duration
+	logger     *slog.Logger
+}
+
+func NewService(repository Repository, timeout time.Duration, logger *slog.Logger) Service {
+	return service{
+		repository: repository,
+		timeout:    timeout,
+		logger:     logger,
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s service) Issue(
+	ctx context.Context,
+	userId uuid.UUID,
+	purpose Purpose,
+	email string,
+	ttl time.Duration,
+) (string, Token, error) {
+	secretBytes := make([]byte, tokenSize)
+	if _, err := rand.Read(secretBytes); err != nil {
+		s.logger.Error("cannot generate token", "error", err)
+		return "", Token{}, err
+	}
+	secret := hex.EncodeToString(secretBytes)
+	now := time.Now().UTC()
+	token := Token{
+		Id:        uuid.Must(uuid.NewV4()),
+		UserId:    userId,
+		Purpose:   purpose,
+		Hash:      hashSecret(secret),
+		Email:     email,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	created, err := s.repository.CreateToken(c, token)
+	if err != nil {
+		s.logger.Error("cannot create token", "purpose", purpose, "error", err)
+		return "", Token{}, err
+	}
+	return secret, created, nil
+}
+
+func (s service) Consume(ctx context.Context, purpose Purpose, secret string) (Token, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	token, err := s.repository.ConsumeToken(c, purpose, hashSecret(secret), time.Now().UTC())
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			s.logger.Error("cannot consume token", "purpose", purpose, "error", err)
+		}
+		return Token{}, err
+	}
+	return token, nil
+}
+
+func (s service) Lookup(ctx context.Context, purpose Purpose, secret string) (Token, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	token, err := s.repository.GetToken(c, purpose, hashSecret(secret), time.Now().UTC())
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			s.logger.Error("cannot get token", "purpose", purpose, "error", err)
+		}
+		return Token{}, err
+	}
+	return token, nil
+}
+
+func (s service) LastIssuedAt(ctx context.Context, userId uuid.UUID, purpose Purpose) (time.Time, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	token, err := s.repository.GetLatestToken(c, userId, purpose)
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return time.Time{}, nil
+		}
+		s.logger.Error("cannot get latest token", "purpose", purpose, "error", err)
+		return time.Time{}, err
+	}
+	return token.CreatedAt, nil
+}
+
+func (s service) Revoke(ctx context.Context, userId uuid.UUID, purpose Purpose) error {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.DeleteTokens(c, userId, purpose); err != nil {
+		s.logger.Error("cannot revoke tokens", "purpose", purpose, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) DeleteExpired(ctx context.Context) (int64, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	deleted, err := s.repository.DeleteExpiredTokens(c, time.Now().UTC())
+	if err != nil {
+		s.logger.Error("cannot delete expired tokens", "error", err)
+		return 0, err
+	}
+	if deleted > 0 {
+		s.logger.Info("deleted expired tokens", "count", deleted)
+	}
+	return deleted, nil
+}
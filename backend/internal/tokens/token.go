@@ -0,0 +1,57 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	// ErrTokenNotFound is returned for unknown, used and expired tokens
+	// alike so that callers cannot tell them apart.
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// Purpose scopes a token to the one flow it was issued for.
+type Purpose string
+
+const (
+	PurposeVerifyEmail   Purpose = "verify_email"
+	PurposeResetPassword Purpose = "reset_password"
+	// PurposeChangeEmail tokens are sent to the new address, which is the
+	// Email of the token.
+	PurposeChangeEmail Purpose = "change_email"
+	// PurposeTwoFactorLogin tokens stand for a sign in whose password was
+	// right and that waits for the second factor.
+	PurposeTwoFactorLogin Purpose = "two_factor_login"
+)
+
+// Token is a single use secret mailed to a user. Only the hash of the secret
+// is stored, whoever reads the table cannot use the tokens in it.
+type Token struct {
+	Id      uuid.UUID
+	UserId  uuid.UUID
+	Purpose Purpose
+	Hash    string
+	// Email is the address the token was sent to.
+	Email     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type Repository interface {
+	// CreateToken stores token and deletes the other tokens of its user
+	// with the same purpose, only the latest one is valid.
+	CreateToken(ctx context.Context, token Token) (Token, error)
+	// ConsumeToken deletes and returns the token of purpose with hash hash
+	// that has not expired at now.
+	ConsumeToken(ctx context.Context, purpose Purpose, hash string, now time.Time) (Token, error)
+	// GetToken returns the token of purpose with hash hash that has not
+	// expired at now without using it up.
+	GetToken(ctx context.Context, purpose Purpose, hash string, now time.Time) (Token, error)
+	GetLatestToken(ctx context.Context, userId uuid.UUID, purpose Purpose) (Token, error)
+	DeleteTokens(ctx context.Context, userId uuid.UUID, purpose Purpose) error
+	DeleteExpiredTokens(ctx context.Context, now time.Time) (int64, error)
+}
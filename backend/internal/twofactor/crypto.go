@@ -0,0 +1,97 @@
+package twofactor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"strings"
+)
+
+const (
+	// KeySize is the size of the key encrypting secrets, AES-256.
+	KeySize          = 32
+	recoveryCodes    = 10
+	recoveryCodeSize = 10
+)
+
+var errSecretUnreadable = errors.New("cannot decrypt two-factor secret")
+
+// secretBox encrypts TOTP secrets at rest with AES-GCM. The user id is
+// authenticated along with the secret so that a secret copied to another
+// row does not decrypt.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(key []byte) (*secretBox, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("two-factor encryption key must be 32 bytes long")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+// seal returns the nonce followed by the encrypted secret.
+func (b *secretBox) seal(secret string, userId uuid.UUID) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, []byte(secret), userId.Bytes()), nil
+}
+
+func (b *secretBox) open(sealed []byte, userId uuid.UUID) (string, error) {
+	if len(sealed) < b.aead.NonceSize() {
+		return "", errSecretUnreadable
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, userId.Bytes())
+	if err != nil {
+		return "", errSecretUnreadable
+	}
+	return string(secret), nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns codes such as ABCD-EFGH-IJKL-MNOP and the hashes
+// stored in their place.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodes)
+	hashes := make([]string, 0, recoveryCodes)
+	for range recoveryCodes {
+		raw := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := recoveryEncoding.EncodeToString(raw)
+		groups := make([]string, 0, len(encoded)/4)
+		for i := 0; i < len(encoded); i += 4 {
+			groups = append(groups, encoded[i:i+4])
+		}
+		code := strings.Join(groups, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes code ignoring case, dashes and spaces. The codes
+// are random enough for a plain hash, like the one-time tokens.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,80 @@
+package twofactor
+
+import (
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"regexp"
+	"testing"
+)
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("ABCD-EFGH-IJKL-MNOP")
+	tests := []struct {
+		name  string
+		input string
+		same  bool
+	}{
+		{name: "as shown", input: "ABCD-EFGH-IJKL-MNOP", same: true},
+		{name: "lower case", input: "abcd-efgh-ijkl-mnop", same: true},
+		{name: "without dashes", input: "ABCDEFGHIJKLMNOP", same: true},
+		{name: "spaces", input: "abcd efgh ijkl mnop", same: true},
+		{name: "other code", input: "ABCD-EFGH-IJKL-MNOQ", same: false},
+		{name: "prefix", input: "ABCD-EFGH-IJKL", same: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashRecoveryCode(tt.input) == want; got != tt.same {
+				t.Fatalf("hash of %q matches: %v, want %v", tt.input, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodes || len(hashes) != recoveryCodes {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodes)
+	}
+	format := regexp.MustCompile(`^[A-Z2-7]{4}(-[A-Z2-7]{4}){3}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as XXXX-XXXX-XXXX-XXXX", code)
+		}
+		if hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("hash %d does not belong to code %q", i, code)
+		}
+		if seen[code] {
+			t.Errorf("code %q repeated", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := newSecretBox(make([]byte, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId := uuid.Must(uuid.NewV4())
+	sealed, err := box.seal("SECRET", userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := box.open(sealed, userId); err != nil || secret != "SECRET" {
+		t.Fatalf("open = %q, %v", secret, err)
+	}
+	// a secret copied to another user does not decrypt
+	if _, err := box.open(sealed, uuid.Must(uuid.NewV4())); !errors.Is(err, errSecretUnreadable) {
+		t.Fatalf("other user: got %v, want errSecretUnreadable", err)
+	}
+	if _, err := box.open(sealed[:4], userId); !errors.Is(err, errSecretUnreadable) {
+		t.Fatalf("truncated: got %v, want errSecretUnreadable", err)
+	}
+	if _, err := newSecretBox(make([]byte, 16)); err == nil {
+		t.Fatal("16 byte key accepted")
+	}
+}
@@ -0,0 +1,296 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "twofactor.repo.pg"
+
+const credentialColumns = `user_id, secret, confirmed_at, last_used_step, failed_attempts, locked_until, created_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgCredential struct {
+	userId         pgtype.UUID
+	secret         []byte
+	confirmedAt    pgtype.Timestamp
+	lastUsedStep   int64
+	failedAttempts int32
+	lockedUntil    pgtype.Timestamp
+	createdAt      pgtype.Timestamp
+}
+
+func (c *pgCredential) scanTargets() []any {
+	return []any{
+		&c.userId,
+		&c.secret,
+		&c.confirmedAt,
+		&c.lastUsedStep,
+		&c.failedAttempts,
+		&c.lockedUntil,
+		&c.createdAt,
+	}
+}
+
+func fromPGCredential(credential pgCredential) Credential {
+	return Credential{
+		UserId:         uuid.UUID(credential.userId.Bytes),
+		Secret:         credential.secret,
+		ConfirmedAt:    credential.confirmedAt.Time,
+		LastUsedStep:   credential.lastUsedStep,
+		FailedAttempts: int(credential.failedAttempts),
+		LockedUntil:    credential.lockedUntil.Time,
+		CreatedAt:      credential.createdAt.Time,
+	}
+}
+
+func toPGCredential(credential Credential) pgCredential {
+	return pgCredential{
+		userId:         pgtype.UUID{Bytes: [16]byte(credential.UserId.Bytes()), Valid: true},
+		secret:         credential.Secret,
+		confirmedAt:    pgtype.Timestamp{Time: credential.ConfirmedAt.UTC(), Valid: !credential.ConfirmedAt.IsZero()},
+		lastUsedStep:   credential.LastUsedStep,
+		failedAttempts: int32(credential.FailedAttempts),
+		lockedUntil:    pgtype.Timestamp{Time: credential.LockedUntil.UTC(), Valid: !credential.LockedUntil.IsZero()},
+		createdAt:      pgtype.Timestamp{Time: credential.CreatedAt.UTC(), Valid: true},
+	}
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) GetCredential(ctx context.Context, userId uuid.UUID) (Credential, error) {
+	const op = postgresRepositorySource + ".GetCredential"
+	query := `SELECT ` + credentialColumns + ` FROM user_totp WHERE user_id = $1`
+	var credential pgCredential
+	if err := r.db.QueryRow(ctx, query, userId).Scan(credential.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Credential{}, ErrCredentialNotFound
+		}
+		return Credential{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGCredential(credential), nil
+}
+
+func (r *postgresRepository) SaveCredential(ctx context.Context, credential Credential) (Credential, error) {
+	const op = postgresRepositorySource + ".SaveCredential"
+	query := `
+INSERT INTO user_totp (` + credentialColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (user_id) DO UPDATE SET
+		secret = EXCLUDED.secret,
+		last_used_step = EXCLUDED.last_used_step,
+		failed_attempts = EXCLUDED.failed_attempts,
+		locked_until = EXCLUDED.locked_until,
+		created_at = EXCLUDED.created_at
+	WHERE user_totp.confirmed_at IS NULL
+	RETURNING ` + credentialColumns
+
+	toSave := toPGCredential(credential)
+	var saved pgCredential
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		toSave.userId,
+		toSave.secret,
+		toSave.confirmedAt,
+		toSave.lastUsedStep,
+		toSave.failedAttempts,
+		toSave.lockedUntil,
+		toSave.createdAt,
+	).Scan(saved.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Credential{}, ErrAlreadyEnabled
+		}
+		return Credential{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGCredential(saved), nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId uuid.UUID, hashes []string, now time.Time) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+	createdAt := pgtype.Timestamp{Time: now.UTC(), Valid: true}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(
+			ctx,
+			`INSERT INTO user_recovery_codes (id, user_id, hash, created_at) VALUES ($1, $2, $3, $4)`,
+			uuid.Must(uuid.NewV4()),
+			userId,
+			hash,
+			createdAt,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *postgresRepository) ConfirmCredential(
+	ctx context.Context,
+	userId uuid.UUID,
+	step int64,
+	recoveryHashes []string,
+	now time.Time,
+) error {
+	const op = postgresRepositorySource + ".ConfirmCredential"
+	query := `
+UPDATE user_totp SET confirmed_at = $2, last_used_step = $3, failed_attempts = 0, locked_until = NULL
+	WHERE user_id = $1 AND confirmed_at IS NULL`
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, userId, pgtype.Timestamp{Time: now.UTC(), Valid: true}, step)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrCredentialNotFound
+		}
+		return replaceRecoveryCodes(ctx, tx, userId, recoveryHashes, now)
+	})
+	if err != nil {
+		if errors.Is(err, ErrCredentialNotFound) {
+			return err
+		}
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) UseStep(ctx context.Context, userId uuid.UUID, step int64, now time.Time) (bool, error) {
+	const op = postgresRepositorySource + ".UseStep"
+	query := `
+UPDATE user_totp SET last_used_step = $2, failed_attempts = 0, locked_until = NULL
+	WHERE user_id = $1 AND last_used_step < $2 AND (locked_until IS NULL OR locked_until <= $3)`
+	tag, err := r.db.Exec(ctx, query, userId, step, pgtype.Timestamp{Time: now.UTC(), Valid: true})
+	if err != nil {
+		return false, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *postgresRepository) UseRecoveryCode(
+	ctx context.Context,
+	userId uuid.UUID,
+	hash string,
+	now time.Time,
+) (bool, error) {
+	const op = postgresRepositorySource + ".UseRecoveryCode"
+	deleteQuery := `
+DELETE FROM user_recovery_codes
+	WHERE user_id = $1 AND hash = $2 AND EXISTS (
+		SELECT 1 FROM user_totp
+		WHERE user_id = $1 AND (locked_until IS NULL OR locked_until <= $3)
+	)`
+	resetQuery := `UPDATE user_totp SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1`
+	used := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, deleteQuery, userId, hash, pgtype.Timestamp{Time: now.UTC(), Valid: true})
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, resetQuery, userId); err != nil {
+			return err
+		}
+		used = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return used, nil
+}
+
+func (r *postgresRepository) CountAttempt(
+	ctx context.Context,
+	userId uuid.UUID,
+	now time.Time,
+	maxFailures int,
+	lockedUntil time.Time,
+) (time.Time, error) {
+	const op = postgresRepositorySource + ".CountAttempt"
+	// the row lock taken by the update serializes concurrent attempts
+	query := `
+UPDATE user_totp SET
+	failed_attempts = CASE WHEN failed_attempts + 1 > $3 THEN 0 ELSE failed_attempts + 1 END,
+	locked_until = CASE WHEN failed_attempts + 1 > $3 THEN $4 ELSE NULL END
+	WHERE user_id = $1 AND (locked_until IS NULL OR locked_until <= $2)
+	RETURNING locked_until`
+	lockedQuery := `SELECT locked_until FROM user_totp WHERE user_id = $1`
+
+	var locked pgtype.Timestamp
+	err := r.db.QueryRow(
+		ctx,
+		query,
+		userId,
+		pgtype.Timestamp{Time: now.UTC(), Valid: true},
+		maxFailures,
+		pgtype.Timestamp{Time: lockedUntil.UTC(), Valid: true},
+	).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// locked already, or there is no credential at all
+		err = r.db.QueryRow(ctx, lockedQuery, userId).Scan(&locked)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, ErrCredentialNotFound
+		}
+		return time.Time{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return locked.Time, nil
+}
+
+func (r *postgresRepository) ReplaceRecoveryCodes(
+	ctx context.Context,
+	userId uuid.UUID,
+	hashes []string,
+	now time.Time,
+) error {
+	const op = postgresRepositorySource + ".ReplaceRecoveryCodes"
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userId, hashes, now)
+	})
+	if err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) DeleteCredential(ctx context.Context, userId uuid.UUID) error {
+	const op = postgresRepositorySource + ".DeleteCredential"
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userId); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrCredentialNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrCredentialNotFound) {
+			return err
+		}
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/tokens"
+	"github.com/plinkplenk/img-share/internal/users"
+	"github.com/plinkplenk/img-share/pkg/totp"
+	"github.com/skip2/go-qrcode"
+	"log/slog"
+	"time"
+)
+
+const (
+	// codeSkew is how many time steps around the current one codes are
+	// accepted from, one makes up for up to 30 seconds of clock drift.
+	codeSkew = 1
+	qrSize   = 256
+)
+
+type Options struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	// EncryptionKey encrypts the secrets at rest, two-factor authentication
+	// cannot be set up or used without it.
+	EncryptionKey []byte
+	// ChallengeTTL is how long a sign in waits for its second factor.
+	ChallengeTTL time.Duration
+	// MaxFailures codes checked in a row without a valid one lock the second
+	// factor of a user for Lockout.
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+// Enrollment is what a user copies into their authenticator app.
+type Enrollment struct {
+	Secret string
+	// URI is the otpauth:// URI of the secret, usually shown as QRCode.
+	URI string
+}
+
+// QRCode renders the URI as a PNG QR code.
+func (e Enrollment) QRCode() ([]byte, error) {
+	return qrcode.Encode(e.URI, qrcode.Medium, qrSize)
+}
+
+type Service interface {
+	// Enabled reports whether userId signs in with a second factor.
+	Enabled(ctx context.Context, userId uuid.UUID) (bool, error)
+	// Enroll generates a secret for user, replacing the one of an earlier
+	// enrolment that was not confirmed. It is enforced once confirmed.
+	Enroll(ctx context.Context, user users.User) (Enrollment, error)
+	// Enrollment returns the enrolment of user waiting for confirmation.
+	Enrollment(ctx context.Context, user users.User) (Enrollment, error)
+	// Confirm enables the enrolment of userId with a first code from the
+	// authenticator app. It returns the recovery codes, which are not
+	// stored anywhere and cannot be shown again.
+	Confirm(ctx context.Context, userId uuid.UUID, code string) ([]string, error)
+	// Verify checks a code from the authenticator app or a recovery code of
+	// userId. Both work once, invalid ones lock the second factor when
+	// there are too many of them.
+	Verify(ctx context.Context, userId uuid.UUID, code string) error
+	// Disable turns two-factor authentication off given a valid code.
+	Disable(ctx context.Context, userId uuid.UUID, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes of userId given a
+	// valid code.
+	RegenerateRecoveryCodes(ctx context.Context, userId uuid.UUID, code string) ([]string, error)
+	// Challenge starts the sign in of user, whose password was right,
+	// returning the secret to complete it with and when it expires.
+	Challenge(ctx context.Context, user users.User) (string, time.Time, error)
+	// CompleteChallenge verifies code for the sign in of challenge and
+	// returns the user signing in. The challenge is used up when the code
+	// is valid only, so that a mistyped code does not cost the password.
+	CompleteChallenge(ctx context.Context, challenge string, code string) (users.User, error)
+}
+
+type service struct {
+	repository    Repository
+	tokensService tokens.Service
+	usersService  users.Service
+	box           *secretBox
+	opts          Options
+	timeout       time.Duration
+	logger        *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	tokensService tokens.Service,
+	usersService users.Service,
+	opts Options,
+	timeout time.Duration,
+	logger *slog.Logger,
+) (Service, error) {
+	s := service{
+		repository:    repository,
+		tokensService: tokensService,
+		usersService:  usersService,
+		opts:          opts,
+		timeout:       timeout,
+		logger:        logger,
+	}
+	if len(opts.EncryptionKey) > 0 {
+		box, err := newSecretBox(opts.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		s.box = box
+	}
+	return s, nil
+}
+
+func (s service) getCredential(ctx context.Context, userId uuid.UUID) (Credential, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	credential, err := s.repository.GetCredential(c, userId)
+	if err != nil && !errors.Is(err, ErrCredentialNotFound) {
+		s.logger.Error("cannot get two-factor credential", "error", err)
+	}
+	return credential, err
+}
+
+func (s service) Enabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	credential, err := s.getCredential(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrCredentialNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return credential.Enabled(), nil
+}
+
+func (s service) Enroll(ctx context.Context, user users.User) (Enrollment, error) {
+	if s.box == nil {
+		return Enrollment{}, ErrNotConfigured
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.logger.Error("cannot generate two-factor secret", "error", err)
+		return Enrollment{}, err
+	}
+	sealed, err := s.box.seal(secret, user.Id)
+	if err != nil {
+		s.logger.Error("cannot encrypt two-factor secret", "error", err)
+		return Enrollment{}, err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err = s.repository.SaveCredential(c, Credential{
+		UserId:    user.Id,
+		Secret:    sealed,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if !errors.Is(err, ErrAlreadyEnabled) {
+			s.logger.Error("cannot save two-factor credential", "error", err)
+		}
+		return Enrollment{}, err
+	}
+	return Enrollment{Secret: secret, URI: totp.URI(s.opts.Issuer, user.Email, secret)}, nil
+}
+
+func (s service) Enrollment(ctx context.Context, user users.User) (Enrollment, error) {
+	if s.box == nil {
+		return Enrollment{}, ErrNotConfigured
+	}
+	credential, err := s.getCredential(ctx, user.Id)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if credential.Enabled() {
+		return Enrollment{}, ErrAlreadyEnabled
+	}
+	secret, err := s.box.open(credential.Secret, user.Id)
+	if err != nil {
+		s.logger.Error("cannot decrypt two-factor secret", "error", err)
+		return Enrollment{}, err
+	}
+	return Enrollment{Secret: secret, URI: totp.URI(s.opts.Issuer, user.Email, secret)}, nil
+}
+
+func (s service) Confirm(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	if s.box == nil {
+		return nil, ErrNotConfigured
+	}
+	credential, err := s.getCredential(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Enabled() {
+		return nil, ErrAlreadyEnabled
+	}
+	secret, err := s.box.open(credential.Secret, userId)
+	if err != nil {
+		s.logger.Error("cannot decrypt two-factor secret", "error", err)
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), codeSkew)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		s.logger.Error("cannot generate recovery codes", "error", err)
+		return nil, err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.ConfirmCredential(c, userId, step, hashes, time.Now().UTC()); err != nil {
+		if !errors.Is(err, ErrCredentialNotFound) {
+			s.logger.Error("cannot confirm two-factor credential", "error", err)
+		}
+		return nil, err
+	}
+	return codes, nil
+}
+
+// check tells whether code is a valid authenticator or recovery code of
+// credential and uses it up, unless the credential is locked at now.
+func (s service) check(ctx context.Context, credential Credential, code string, now time.Time) (bool, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if len(code) == totp.Digits {
+		secret, err := s.box.open(credential.Secret, credential.UserId)
+		if err != nil {
+			s.logger.Error("cannot decrypt two-factor secret", "error", err)
+			return false, err
+		}
+		step, ok := totp.Validate(secret, code, now, codeSkew)
+		if !ok {
+			return false, nil
+		}
+		// a code seen before is refused like a wrong one
+		used, err := s.repository.UseStep(c, credential.UserId, step, now)
+		if err != nil {
+			s.logger.Error("cannot use two-factor code", "error", err)
+			return false, err
+		}
+		return used, nil
+	}
+	used, err := s.repository.UseRecoveryCode(c, credential.UserId, hashRecoveryCode(code), now)
+	if err != nil {
+		s.logger.Error("cannot use recovery code", "error", err)
+		return false, err
+	}
+	return used, nil
+}
+
+func (s service) Verify(ctx context.Context, userId uuid.UUID, code string) error {
+	if s.box == nil {
+		return ErrNotConfigured
+	}
+	credential, err := s.getCredential(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrCredentialNotFound) {
+			return ErrNotEnabled
+		}
+		return err
+	}
+	if !credential.Enabled() {
+		return ErrNotEnabled
+	}
+	// the attempt counts as a failure until the code turns out valid, so
+	// that parallel guesses are limited like sequential ones
+	now := time.Now().UTC()
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	lockedUntil, err := s.repository.CountAttempt(c, userId, now, s.opts.MaxFailures, now.Add(s.opts.Lockout))
+	cancel()
+	if err != nil {
+		if errors.Is(err, ErrCredentialNotFound) {
+			return ErrNotEnabled
+		}
+		s.logger.Error("cannot count two-factor attempt", "error", err)
+		return err
+	}
+	if lockedUntil.After(now) {
+		return LockedError{RetryAfter: lockedUntil.Sub(now)}
+	}
+	ok, err := s.check(ctx, credential, code, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (s service) Disable(ctx context.Context, userId uuid.UUID, code string) error {
+	if err := s.Verify(ctx, userId, code); err != nil {
+		return err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.DeleteCredential(c, userId); err != nil {
+		if !errors.Is(err, ErrCredentialNotFound) {
+			s.logger.Error("cannot delete two-factor credential", "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s service) RegenerateRecoveryCodes(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	if err := s.Verify(ctx, userId, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		s.logger.Error("cannot generate recovery codes", "error", err)
+		return nil, err
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.repository.ReplaceRecoveryCodes(c, userId, hashes, time.Now().UTC()); err != nil {
+		s.logger.Error("cannot replace recovery codes", "error", err)
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s service) Challenge(ctx context.Context, user users.User) (string, time.Time, error) {
+	challenge, token, err := s.tokensService.Issue(
+		ctx,
+		user.Id,
+		tokens.PurposeTwoFactorLogin,
+		user.Email,
+		s.opts.ChallengeTTL,
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return challenge, token.ExpiresAt, nil
+}
+
+func (s service) CompleteChallenge(ctx context.Context, challenge string, code string) (users.User, error) {
+	token, err := s.tokensService.Lookup(ctx, tokens.PurposeTwoFactorLogin, challenge)
+	if err != nil {
+		if errors.Is(err, tokens.ErrTokenNotFound) {
+			return users.User{}, ErrInvalidChallenge
+		}
+		return users.User{}, err
+	}
+	if err := s.Verify(ctx, token.UserId, code); err != nil {
+		return users.User{}, err
+	}
+	// a concurrent request may have completed the challenge meanwhile
+	if _, err := s.tokensService.Consume(ctx, tokens.PurposeTwoFactorLogin, challenge); err != nil {
+		if errors.Is(err, tokens.ErrTokenNotFound) {
+			return users.User{}, ErrInvalidChallenge
+		}
+		return users.User{}, err
+	}
+	user, err := s.usersService.GetUserById(ctx, token.UserId)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return users.User{}, ErrInvalidChallenge
+		}
+		return users.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,265 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/pkg/totp"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryRepository keeps credentials in memory. A single mutex stands in for
+// the row lock the postgres statements take.
+type memoryRepository struct {
+	mu          sync.Mutex
+	credentials map[uuid.UUID]Credential
+	recovery    map[uuid.UUID]map[string]struct{}
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		credentials: map[uuid.UUID]Credential{},
+		recovery:    map[uuid.UUID]map[string]struct{}{},
+	}
+}
+
+func (r *memoryRepository) GetCredential(_ context.Context, userId uuid.UUID) (Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userId]
+	if !ok {
+		return Credential{}, ErrCredentialNotFound
+	}
+	return credential, nil
+}
+
+func (r *memoryRepository) SaveCredential(_ context.Context, credential Credential) (Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.credentials[credential.UserId]; ok && existing.Enabled() {
+		return Credential{}, ErrAlreadyEnabled
+	}
+	r.credentials[credential.UserId] = credential
+	return credential, nil
+}
+
+func (r *memoryRepository) ConfirmCredential(
+	_ context.Context,
+	userId uuid.UUID,
+	step int64,
+	recoveryHashes []string,
+	now time.Time,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userId]
+	if !ok || credential.Enabled() {
+		return ErrCredentialNotFound
+	}
+	credential.ConfirmedAt = now
+	credential.LastUsedStep = step
+	r.credentials[userId] = credential
+	r.replaceRecoveryCodes(userId, recoveryHashes)
+	return nil
+}
+
+func unlocked(credential Credential, now time.Time) bool {
+	return !credential.LockedUntil.After(now)
+}
+
+func (r *memoryRepository) UseStep(_ context.Context, userId uuid.UUID, step int64, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userId]
+	if !ok || credential.LastUsedStep >= step || !unlocked(credential, now) {
+		return false, nil
+	}
+	credential.LastUsedStep = step
+	credential.FailedAttempts = 0
+	credential.LockedUntil = time.Time{}
+	r.credentials[userId] = credential
+	return true, nil
+}
+
+func (r *memoryRepository) UseRecoveryCode(_ context.Context, userId uuid.UUID, hash string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userId]
+	if !ok || !unlocked(credential, now) {
+		return false, nil
+	}
+	if _, ok := r.recovery[userId][hash]; !ok {
+		return false, nil
+	}
+	delete(r.recovery[userId], hash)
+	credential.FailedAttempts = 0
+	credential.LockedUntil = time.Time{}
+	r.credentials[userId] = credential
+	return true, nil
+}
+
+func (r *memoryRepository) CountAttempt(
+	_ context.Context,
+	userId uuid.UUID,
+	now time.Time,
+	maxFailures int,
+	lockedUntil time.Time,
+) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userId]
+	if !ok {
+		return time.Time{}, ErrCredentialNotFound
+	}
+	if !unlocked(credential, now) {
+		return credential.LockedUntil, nil
+	}
+	credential.FailedAttempts++
+	credential.LockedUntil = time.Time{}
+	if credential.FailedAttempts > maxFailures {
+		credential.FailedAttempts = 0
+		credential.LockedUntil = lockedUntil
+	}
+	r.credentials[userId] = credential
+	return credential.LockedUntil, nil
+}
+
+func (r *memoryRepository) replaceRecoveryCodes(userId uuid.UUID, hashes []string) {
+	r.recovery[userId] = map[string]struct{}{}
+	for _, hash := range hashes {
+		r.recovery[userId][hash] = struct{}{}
+	}
+}
+
+func (r *memoryRepository) ReplaceRecoveryCodes(_ context.Context, userId uuid.UUID, hashes []string, _ time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replaceRecoveryCodes(userId, hashes)
+	return nil
+}
+
+func (r *memoryRepository) DeleteCredential(_ context.Context, userId uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.credentials[userId]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(r.credentials, userId)
+	delete(r.recovery, userId)
+	return nil
+}
+
+const testMaxFailures = 5
+
+// enabledService returns a service with a confirmed credential for a new
+// user, its TOTP secret and its recovery codes.
+func enabledService(t *testing.T) (service, uuid.UUID, string, []string) {
+	t.Helper()
+	key := make([]byte, KeySize)
+	s, err := NewService(newMemoryRepository(), nil, nil, Options{
+		Issuer:        "img-share",
+		EncryptionKey: key,
+		MaxFailures:   testMaxFailures,
+		Lockout:       time.Minute,
+	}, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := s.(service)
+	userId := uuid.Must(uuid.NewV4())
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := svc.box.seal(secret, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := svc.repository.SaveCredential(ctx, Credential{UserId: userId, Secret: sealed}); err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := svc.Confirm(ctx, userId, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, userId, secret, codes
+}
+
+func TestVerifyLimitsConcurrentGuesses(t *testing.T) {
+	svc, userId, _, _ := enabledService(t)
+
+	const guesses = 50
+	errs := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- svc.Verify(context.Background(), userId, "wrong-recovery-code")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	invalid, locked := 0, 0
+	for err := range errs {
+		switch {
+		case errors.Is(err, ErrInvalidCode):
+			invalid++
+		case errors.Is(err, ErrLocked):
+			locked++
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if invalid != testMaxFailures {
+		t.Errorf("%d guesses were checked, want %d", invalid, testMaxFailures)
+	}
+	if locked != guesses-testMaxFailures {
+		t.Errorf("%d guesses were refused as locked, want %d", locked, guesses-testMaxFailures)
+	}
+}
+
+func TestVerifyRefusesValidCodesWhileLocked(t *testing.T) {
+	svc, userId, _, codes := enabledService(t)
+	ctx := context.Background()
+	for i := 0; i < testMaxFailures; i++ {
+		if err := svc.Verify(ctx, userId, "wrong-recovery-code"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("guess %d: got %v, want ErrInvalidCode", i, err)
+		}
+	}
+	if err := svc.Verify(ctx, userId, codes[0]); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	// the recovery code must still be there once the lock is lifted
+	if used, err := svc.repository.UseRecoveryCode(ctx, userId, hashRecoveryCode(codes[0]), time.Now().Add(time.Hour)); err != nil || !used {
+		t.Fatalf("recovery code was used while locked: %v %v", used, err)
+	}
+}
+
+func TestVerifyResetsAttemptsOnValidCode(t *testing.T) {
+	svc, userId, _, codes := enabledService(t)
+	ctx := context.Background()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < testMaxFailures-1; i++ {
+			if err := svc.Verify(ctx, userId, "wrong-recovery-code"); !errors.Is(err, ErrInvalidCode) {
+				t.Fatalf("round %d guess %d: got %v, want ErrInvalidCode", round, i, err)
+			}
+		}
+		if err := svc.Verify(ctx, userId, codes[round]); err != nil {
+			t.Fatalf("round %d: valid recovery code refused: %v", round, err)
+		}
+	}
+	if err := svc.Verify(ctx, userId, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("reused recovery code: got %v, want ErrInvalidCode", err)
+	}
+}
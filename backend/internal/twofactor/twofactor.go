@@ -0,0 +1,84 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrNotConfigured      = errors.New("two-factor authentication is not configured")
+	ErrCredentialNotFound = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrNotEnabled         = errors.New("two-factor authentication is not enabled")
+	ErrInvalidCode        = errors.New("invalid two-factor code")
+	ErrInvalidChallenge   = errors.New("invalid or expired two-factor challenge")
+	ErrLocked             = errors.New("too many invalid two-factor codes")
+)
+
+// LockedError is returned while codes are refused after too many invalid
+// ones, it matches ErrLocked.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrLocked, e.RetryAfter)
+}
+
+func (e LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Credential is the TOTP secret of a user.
+type Credential struct {
+	UserId uuid.UUID
+	// Secret is the base32 TOTP secret encrypted with the configured key,
+	// bound to UserId.
+	Secret []byte
+	// ConfirmedAt is zero while the enrolment waits for a first code from
+	// the authenticator app, unconfirmed credentials are not enforced.
+	ConfirmedAt time.Time
+	// LastUsedStep is the time step of the latest accepted code, codes of
+	// it and earlier steps are refused so that a code works once.
+	LastUsedStep   int64
+	FailedAttempts int
+	LockedUntil    time.Time
+	CreatedAt      time.Time
+}
+
+func (c Credential) Enabled() bool {
+	return !c.ConfirmedAt.IsZero()
+}
+
+type Repository interface {
+	GetCredential(ctx context.Context, userId uuid.UUID) (Credential, error)
+	// SaveCredential stores an unconfirmed credential, replacing the
+	// unconfirmed one of the same user. It fails with ErrAlreadyEnabled when
+	// the user has a confirmed one.
+	SaveCredential(ctx context.Context, credential Credential) (Credential, error)
+	// ConfirmCredential enables the unconfirmed credential of userId whose
+	// first code was of step and stores the hashes of its recovery codes.
+	ConfirmCredential(ctx context.Context, userId uuid.UUID, step int64, recoveryHashes []string, now time.Time) error
+	// UseStep records step as used, reporting false when it is not after
+	// the last used one or the credential is locked at now. Attempts are
+	// reset.
+	UseStep(ctx context.Context, userId uuid.UUID, step int64, now time.Time) (bool, error)
+	// UseRecoveryCode deletes the recovery code of userId with hash hash,
+	// reporting false when there is none or the credential is locked at now.
+	// Attempts are reset.
+	UseRecoveryCode(ctx context.Context, userId uuid.UUID, hash string, now time.Time) (bool, error)
+	// CountAttempt counts a code of userId about to be checked, before it is
+	// checked, so that concurrent attempts cannot all slip under the limit.
+	// The attempt after maxFailures unused ones locks the credential until
+	// lockedUntil and starts the count over. It returns when the credential
+	// is locked until, zero when the attempt may go ahead.
+	CountAttempt(ctx context.Context, userId uuid.UUID, now time.Time, maxFailures int, lockedUntil time.Time) (time.Time, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId uuid.UUID, hashes []string, now time.Time) error
+	// DeleteCredential removes the credential of userId and its recovery
+	// codes.
+	DeleteCredential(ctx context.Context, userId uuid.UUID) error
+}
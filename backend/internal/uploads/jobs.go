@@ -0,0 +1,18 @@
+package uploads
+
+import (
+	"context"
+	"github.com/plinkplenk/img-share/internal/jobs"
+)
+
+const JobPurgeExpired = "uploads.purge_expired"
+
+// RegisterJobs makes runner execute the uploads jobs and schedules the
+// hourly purge of expired uploads.
+func RegisterJobs(runner *jobs.Runner, service Service) error {
+	runner.Handle(JobPurgeExpired, func(ctx context.Context, job jobs.Job) error {
+		_, err := service.PurgeExpired(ctx)
+		return err
+	})
+	return runner.Schedule(JobPurgeExpired, "@hourly", JobPurgeExpired, nil)
+}
@@ -0,0 +1,30 @@
+package uploads
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// ParseMetadata decodes a tus Upload-Metadata header: comma separated pairs
+// of a key and an optional base64 encoded value, divided by a space.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(encoded, " ") {
+			return nil, ErrInvalidMetadata
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, ErrInvalidMetadata
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ErrInvalidMetadata
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
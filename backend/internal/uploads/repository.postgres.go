@@ -0,0 +1,238 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const postgresRepositorySource = "uploads.repo.pg"
+
+const uploadColumns = `id, owner_id, length, upload_offset, metadata, image_id, created_at, updated_at, expires_at`
+
+type postgresRepository struct {
+	db *pgxpool.Pool
+}
+
+type pgUpload struct {
+	id        pgtype.UUID
+	ownerId   pgtype.UUID
+	length    int64
+	offset    int64
+	metadata  string
+	imageId   pgtype.UUID
+	createdAt pgtype.Timestamp
+	updatedAt pgtype.Timestamp
+	expiresAt pgtype.Timestamp
+}
+
+func (u *pgUpload) scanTargets() []any {
+	return []any{
+		&u.id,
+		&u.ownerId,
+		&u.length,
+		&u.offset,
+		&u.metadata,
+		&u.imageId,
+		&u.createdAt,
+		&u.updatedAt,
+		&u.expiresAt,
+	}
+}
+
+func fromPGUpload(upload pgUpload) (Upload, error) {
+	id, err := uuid.FromBytes(upload.id.Bytes[:])
+	if err != nil {
+		return Upload{}, err
+	}
+	ownerId, err := uuid.FromBytes(upload.ownerId.Bytes[:])
+	if err != nil {
+		return Upload{}, err
+	}
+	var imageId uuid.NullUUID
+	if upload.imageId.Valid {
+		imageId = uuid.NullUUID{UUID: uuid.UUID(upload.imageId.Bytes), Valid: true}
+	}
+	return Upload{
+		Id:        id,
+		OwnerId:   ownerId,
+		Length:    upload.length,
+		Offset:    upload.offset,
+		Metadata:  upload.metadata,
+		ImageId:   imageId,
+		CreatedAt: upload.createdAt.Time,
+		UpdatedAt: upload.updatedAt.Time,
+		ExpiresAt: upload.expiresAt.Time,
+	}, nil
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) Repository {
+	return &postgresRepository{
+		db: db,
+	}
+}
+
+func (r *postgresRepository) CreateUpload(ctx context.Context, upload Upload) (Upload, error) {
+	const op = postgresRepositorySource + ".CreateUpload"
+	query := `
+INSERT INTO uploads (id, owner_id, length, upload_offset, metadata, created_at, updated_at, expires_at)
+	VALUES ($1, $2, $3, 0, $4, $5, $5, $6)
+	RETURNING ` + uploadColumns
+
+	var created pgUpload
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		upload.Id,
+		upload.OwnerId,
+		upload.Length,
+		upload.Metadata,
+		pgtype.Timestamp{Time: upload.CreatedAt.UTC(), Valid: true},
+		pgtype.Timestamp{Time: upload.ExpiresAt.UTC(), Valid: true},
+	).Scan(created.scanTargets()...); err != nil {
+		return Upload{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGUpload(created)
+}
+
+func (r *postgresRepository) GetUploadById(ctx context.Context, id uuid.UUID) (Upload, error) {
+	const op = postgresRepositorySource + ".GetUploadById"
+	query := `SELECT ` + uploadColumns + ` FROM uploads WHERE id = $1`
+	var upload pgUpload
+	if err := r.db.QueryRow(ctx, query, id).Scan(upload.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Upload{}, ErrUploadNotFound
+		}
+		return Upload{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGUpload(upload)
+}
+
+func (r *postgresRepository) AppendChunk(ctx context.Context, chunk Chunk, expiresAt time.Time) (Upload, error) {
+	const op = postgresRepositorySource + ".AppendChunk"
+	query := `
+UPDATE uploads SET upload_offset = upload_offset + $3, updated_at = $4, expires_at = $5
+	WHERE id = $1 AND upload_offset = $2 AND image_id IS NULL
+	RETURNING ` + uploadColumns
+	chunkQuery := `
+INSERT INTO upload_chunks (upload_id, start_offset, size, storage_key)
+	VALUES ($1, $2, $3, $4)`
+
+	var updated pgUpload
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(
+			ctx,
+			query,
+			chunk.UploadId,
+			chunk.StartOffset,
+			chunk.Size,
+			pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+			pgtype.Timestamp{Time: expiresAt.UTC(), Valid: true},
+		).Scan(updated.scanTargets()...); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, chunkQuery, chunk.UploadId, chunk.StartOffset, chunk.Size, chunk.StorageKey)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Upload{}, ErrOffsetMismatch
+		}
+		return Upload{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGUpload(updated)
+}
+
+func (r *postgresRepository) GetChunksByUploadId(ctx context.Context, uploadId uuid.UUID) ([]Chunk, error) {
+	const op = postgresRepositorySource + ".GetChunksByUploadId"
+	query := `
+SELECT upload_id, start_offset, size, storage_key FROM upload_chunks
+	WHERE upload_id = $1
+	ORDER BY start_offset`
+	rows, err := r.db.Query(ctx, query, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var chunks []Chunk
+	for rows.Next() {
+		var id pgtype.UUID
+		var chunk Chunk
+		if err := rows.Scan(&id, &chunk.StartOffset, &chunk.Size, &chunk.StorageKey); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		chunk.UploadId = uuid.UUID(id.Bytes)
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return chunks, nil
+}
+
+func (r *postgresRepository) FinishUpload(ctx context.Context, id uuid.UUID, imageId uuid.UUID) (Upload, error) {
+	const op = postgresRepositorySource + ".FinishUpload"
+	query := `
+UPDATE uploads SET image_id = $2, updated_at = $3
+	WHERE id = $1 AND image_id IS NULL
+	RETURNING ` + uploadColumns
+
+	var updated pgUpload
+	if err := r.db.QueryRow(
+		ctx,
+		query,
+		id,
+		imageId,
+		pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	).Scan(updated.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Upload{}, ErrUploadAlreadyFinished
+		}
+		return Upload{}, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return fromPGUpload(updated)
+}
+
+func (r *postgresRepository) DeleteUpload(ctx context.Context, id uuid.UUID) error {
+	const op = postgresRepositorySource + ".DeleteUpload"
+	query := `DELETE FROM uploads WHERE id = $1`
+	if _, err := r.db.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
+}
+
+func (r *postgresRepository) GetExpiredUploads(ctx context.Context, now time.Time, limit int) ([]Upload, error) {
+	const op = postgresRepositorySource + ".GetExpiredUploads"
+	query := `
+SELECT ` + uploadColumns + ` FROM uploads
+	WHERE expires_at <= $1
+	ORDER BY expires_at
+	LIMIT $2`
+	rows, err := r.db.Query(ctx, query, pgtype.Timestamp{Time: now.UTC(), Valid: true}, limit)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	defer rows.Close()
+	var uploads []Upload
+	for rows.Next() {
+		var pgUpload pgUpload
+		if err := rows.Scan(pgUpload.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		upload, err := fromPGUpload(pgUpload)
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %w", op, err)
+		}
+		uploads = append(uploads, upload)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[%s]: %w", op, err)
+	}
+	return uploads, nil
+}
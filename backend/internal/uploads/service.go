@@ -0,0 +1,406 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/plinkplenk/img-share/internal/images"
+	"github.com/plinkplenk/img-share/internal/storage"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const (
+	metadataFileName     = "filename"
+	metadataName         = "name"
+	metadataKeepMetadata = "keep_metadata"
+	metadataVisibility   = "visibility"
+	metadataTTL          = "ttl"
+	metadataBurn         = "burn_after_reading"
+
+	reaperBatchSize = 100
+)
+
+type Service interface {
+	Create(ctx context.Context, ownerId uuid.UUID, length int64, metadata string) (Upload, error)
+	GetUploadById(ctx context.Context, id uuid.UUID) (Upload, error)
+	// WriteChunk appends data at offset, which has to match the upload offset.
+	// When the upload is complete its chunks are assembled into an image and
+	// the returned upload carries the image id.
+	WriteChunk(ctx context.Context, upload Upload, offset int64, data io.Reader) (Upload, error)
+	Delete(ctx context.Context, upload Upload) error
+	// PurgeExpired deletes the uploads that expired together with their
+	// chunks and returns how many were deleted.
+	PurgeExpired(ctx context.Context) (int, error)
+	MaxSize() int64
+}
+
+type service struct {
+	repository    Repository
+	storage       storage.Storage
+	imagesService images.Service
+	maxSize       int64
+	expiry        time.Duration
+	timeout       time.Duration
+	logger        *slog.Logger
+}
+
+func NewService(
+	repository Repository,
+	storage storage.Storage,
+	imagesService images.Service,
+	maxSize int64,
+	expiry time.Duration,
+	timeout time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		repository:    repository,
+		storage:       storage,
+		imagesService: imagesService,
+		maxSize:       maxSize,
+		expiry:        expiry,
+		timeout:       timeout,
+		logger:        logger,
+	}
+}
+
+func (s service) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s service) Create(ctx context.Context, ownerId uuid.UUID, length int64, metadata string) (Upload, error) {
+	if length <= 0 {
+		return Upload{}, ErrInvalidUploadLength
+	}
+	if length > s.maxSize {
+		return Upload{}, ErrUploadTooLarge
+	}
+	parsed, err := ParseMetadata(metadata)
+	if err != nil {
+		return Upload{}, err
+	}
+	// rejected now rather than once all of the data was sent
+	opts, err := uploadOptions(parsed)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := s.imagesService.ValidateUploadOptions(opts); err != nil {
+		return Upload{}, fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	now := time.Now().UTC()
+	upload, err := s.repository.CreateUpload(c, Upload{
+		Id:        uuid.Must(uuid.NewV4()),
+		OwnerId:   ownerId,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiry),
+	})
+	if err != nil {
+		s.logger.Error("cannot create upload", "error", err)
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (s service) GetUploadById(ctx context.Context, id uuid.UUID) (Upload, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	upload, err := s.repository.GetUploadById(c, id)
+	if err != nil {
+		if !errors.Is(err, ErrUploadNotFound) {
+			s.logger.Error("cannot get upload by id", "error", err)
+		}
+		return Upload{}, err
+	}
+	if upload.Expired(time.Now().UTC()) {
+		return Upload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (s service) WriteChunk(ctx context.Context, upload Upload, offset int64, data io.Reader) (Upload, error) {
+	if offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+	if upload.Finished() {
+		// a retry of the request that completed the upload
+		return upload, nil
+	}
+	if upload.Offset < upload.Length {
+		var err error
+		// on a broken connection the upload still advances by whatever
+		// arrived, the client resumes from the new offset
+		if upload, err = s.appendChunk(ctx, upload, data); err != nil {
+			return upload, err
+		}
+	}
+	if upload.Offset < upload.Length {
+		return upload, nil
+	}
+	return s.finish(ctx, upload)
+}
+
+// appendChunk stores data up to the remaining length as a new chunk. A read
+// error ends the chunk early instead of discarding it and is returned once
+// the received part is recorded.
+func (s service) appendChunk(ctx context.Context, upload Upload, data io.Reader) (Upload, error) {
+	// a client going away cancels the request context, which must not stop
+	// the received part from being recorded
+	ctx = context.WithoutCancel(ctx)
+	remaining := upload.Length - upload.Offset
+	body := &partialReader{r: io.LimitReader(data, remaining+1)}
+	chunk := Chunk{
+		UploadId:    upload.Id,
+		StartOffset: upload.Offset,
+		StorageKey:  chunkKey(upload.Id, uuid.Must(uuid.NewV4())),
+	}
+	object, err := s.storage.Put(ctx, chunk.StorageKey, body, storage.PutOptions{
+		ContentType: "application/offset+octet-stream",
+	})
+	if err != nil {
+		s.logger.Error("cannot store upload chunk", "upload_id", upload.Id, "error", err)
+		return upload, err
+	}
+	discard := func() {
+		if err := s.storage.Delete(ctx, chunk.StorageKey); err != nil {
+			s.logger.Error("cannot remove upload chunk", "key", chunk.StorageKey, "error", err)
+		}
+	}
+	if object.Size > remaining {
+		discard()
+		return upload, ErrChunkTooLarge
+	}
+	if object.Size == 0 {
+		discard()
+		return upload, body.err
+	}
+	chunk.Size = object.Size
+
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	updated, err := s.repository.AppendChunk(c, chunk, time.Now().UTC().Add(s.expiry))
+	if err != nil {
+		// a concurrent request for the same offset won, its chunk is the one
+		// that counts
+		discard()
+		if !errors.Is(err, ErrOffsetMismatch) {
+			s.logger.Error("cannot append upload chunk", "upload_id", upload.Id, "error", err)
+		}
+		return upload, err
+	}
+	return updated, body.err
+}
+
+// uploadOptions reads the image options from the upload metadata.
+func uploadOptions(metadata map[string]string) (images.UploadOptions, error) {
+	var opts images.UploadOptions
+	var err error
+	if raw := metadata[metadataKeepMetadata]; raw != "" {
+		if opts.KeepMetadata, err = strconv.ParseBool(raw); err != nil {
+			return images.UploadOptions{}, fmt.Errorf("%w: keep_metadata must be a boolean", ErrInvalidMetadata)
+		}
+	}
+	visibility, err := images.ParseVisibility(metadata[metadataVisibility])
+	if err != nil {
+		return images.UploadOptions{}, fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	opts.Visibility = visibility
+	if raw := metadata[metadataTTL]; raw != "" {
+		if opts.TTL, err = time.ParseDuration(raw); err != nil {
+			return images.UploadOptions{}, fmt.Errorf("%w: ttl must be a duration", ErrInvalidMetadata)
+		}
+	}
+	if raw := metadata[metadataBurn]; raw != "" {
+		if opts.BurnAfterReading, err = strconv.ParseBool(raw); err != nil {
+			return images.UploadOptions{}, fmt.Errorf("%w: burn_after_reading must be a boolean", ErrInvalidMetadata)
+		}
+	}
+	return opts, nil
+}
+
+// finish assembles the chunks of a complete upload into an image. Uploads
+// that turn out not to be valid images are removed.
+func (s service) finish(ctx context.Context, upload Upload) (Upload, error) {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	chunks, err := s.repository.GetChunksByUploadId(c, upload.Id)
+	cancel()
+	if err != nil {
+		s.logger.Error("cannot get upload chunks", "upload_id", upload.Id, "error", err)
+		return upload, err
+	}
+	metadata, err := ParseMetadata(upload.Metadata)
+	if err != nil {
+		return upload, err
+	}
+	name := metadata[metadataFileName]
+	if name == "" {
+		name = metadata[metadataName]
+	}
+	// the ttl runs from when the image is assembled, not from when the
+	// upload was created
+	opts, err := uploadOptions(metadata)
+	if err != nil {
+		return upload, err
+	}
+
+	data := &chunksReader{ctx: ctx, storage: s.storage, chunks: chunks}
+	defer data.Close()
+	image, err := s.imagesService.Upload(ctx, upload.OwnerId, name, data, opts)
+	if err != nil {
+		// chunks that cannot be read are a storage problem, the upload
+		// itself may still be fine
+		invalid := errors.Is(err, images.ErrUnsupportedFormat) ||
+			errors.Is(err, images.ErrInvalidImageUpload) ||
+			errors.Is(err, images.ErrInvalidTTL)
+		if invalid && data.err == nil {
+			if err := s.Delete(ctx, upload); err != nil {
+				return upload, err
+			}
+		}
+		return upload, err
+	}
+
+	c, cancel = context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	finished, err := s.repository.FinishUpload(c, upload.Id, image.Id)
+	if err != nil {
+		// the same upload was completed twice concurrently, keep one image
+		if !errors.Is(err, ErrUploadAlreadyFinished) {
+			s.logger.Error("cannot finish upload", "upload_id", upload.Id, "error", err)
+		}
+		if err := s.imagesService.DeleteImage(ctx, image.Id); err != nil {
+			s.logger.Error("cannot remove duplicate image", "image_id", image.Id, "error", err)
+		}
+		return upload, err
+	}
+	s.deleteChunks(ctx, chunks)
+	return finished, nil
+}
+
+func (s service) Delete(ctx context.Context, upload Upload) error {
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	chunks, err := s.repository.GetChunksByUploadId(c, upload.Id)
+	if err != nil {
+		s.logger.Error("cannot get upload chunks", "upload_id", upload.Id, "error", err)
+		return err
+	}
+	if err := s.repository.DeleteUpload(c, upload.Id); err != nil {
+		s.logger.Error("cannot delete upload", "upload_id", upload.Id, "error", err)
+		return err
+	}
+	s.deleteChunks(ctx, chunks)
+	return nil
+}
+
+func (s service) PurgeExpired(ctx context.Context) (int, error) {
+	purged := 0
+	for {
+		c, cancel := context.WithTimeout(ctx, s.timeout)
+		expired, err := s.repository.GetExpiredUploads(c, time.Now().UTC(), reaperBatchSize)
+		cancel()
+		if err != nil {
+			s.logger.Error("cannot get expired uploads", "error", err)
+			return purged, err
+		}
+		for _, upload := range expired {
+			if err := s.Delete(ctx, upload); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(expired) < reaperBatchSize {
+			if purged > 0 {
+				s.logger.Info("purged expired uploads", "count", purged)
+			}
+			return purged, nil
+		}
+	}
+}
+
+func (s service) deleteChunks(ctx context.Context, chunks []Chunk) {
+	for _, chunk := range chunks {
+		if err := s.storage.Delete(ctx, chunk.StorageKey); err != nil {
+			s.logger.Error("cannot remove upload chunk", "key", chunk.StorageKey, "error", err)
+		}
+	}
+}
+
+// partialReader turns a read error into the end of the data, remembering
+// the error, so that the bytes received before a broken connection are
+// stored rather than thrown away.
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, io.EOF
+	}
+	n, err := r.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// chunksReader reads the chunks of an upload one after another, opening
+// each one only when the previous is exhausted.
+type chunksReader struct {
+	ctx     context.Context
+	storage storage.Storage
+	chunks  []Chunk
+	current io.ReadCloser
+	err     error
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			file, _, err := r.storage.Get(r.ctx, r.chunks[0].StorageKey)
+			if err != nil {
+				r.err = fmt.Errorf("cannot open chunk %s: %w", r.chunks[0].StorageKey, err)
+				return 0, r.err
+			}
+			r.current = file
+			r.chunks = r.chunks[1:]
+		}
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			err = r.current.Close()
+			r.current = nil
+			if err != nil {
+				r.err = err
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err != nil {
+			r.err = err
+		}
+		return n, err
+	}
+}
+
+func (r *chunksReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
@@ -0,0 +1,58 @@
+package uploads
+
+import (
+	"errors"
+	"github.com/plinkplenk/img-share/internal/images"
+	"testing"
+	"time"
+)
+
+func TestUploadOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     images.UploadOptions
+		wantErr  bool
+	}{
+		{
+			name: "defaults",
+			want: images.UploadOptions{Visibility: images.VisibilityPrivate},
+		},
+		{
+			name: "every option",
+			metadata: map[string]string{
+				metadataKeepMetadata: "true",
+				metadataVisibility:   "public",
+				metadataTTL:          "1h30m",
+				metadataBurn:         "1",
+			},
+			want: images.UploadOptions{
+				KeepMetadata:     true,
+				Visibility:       images.VisibilityPublic,
+				TTL:              90 * time.Minute,
+				BurnAfterReading: true,
+			},
+		},
+		{name: "invalid keep_metadata", metadata: map[string]string{metadataKeepMetadata: "yes"}, wantErr: true},
+		{name: "invalid visibility", metadata: map[string]string{metadataVisibility: "friends"}, wantErr: true},
+		{name: "invalid ttl", metadata: map[string]string{metadataTTL: "1 day"}, wantErr: true},
+		{name: "invalid burn", metadata: map[string]string{metadataBurn: "once"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uploadOptions(tt.metadata)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMetadata) {
+					t.Fatalf("err = %v, want %v", err, ErrInvalidMetadata)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("options = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+var (
+	ErrUploadNotFound        = errors.New("upload not found")
+	ErrUploadTooLarge        = errors.New("upload is too large")
+	ErrInvalidUploadLength   = errors.New("invalid upload length")
+	ErrInvalidMetadata       = errors.New("invalid upload metadata")
+	ErrOffsetMismatch        = errors.New("upload offset mismatch")
+	ErrChunkTooLarge         = errors.New("chunk exceeds the upload length")
+	ErrUploadAlreadyFinished = errors.New("upload already finished")
+)
+
+func chunkKey(uploadId uuid.UUID, chunkId uuid.UUID) string {
+	return fmt.Sprintf("uploads/%s/%s", uploadId, chunkId)
+}
+
+// Upload is a resumable upload in progress. Once Offset reaches Length the
+// chunks are assembled into an image and ImageId is set.
+type Upload struct {
+	Id      uuid.UUID
+	OwnerId uuid.UUID
+	Length  int64
+	Offset  int64
+	// Metadata is the Upload-Metadata header as sent on creation.
+	Metadata  string
+	ImageId   uuid.NullUUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// ExpiresAt is pushed back by every chunk received. Uploads past it are
+	// treated as deleted until the reaper purges them.
+	ExpiresAt time.Time
+}
+
+func (u Upload) Finished() bool {
+	return u.ImageId.Valid
+}
+
+func (u Upload) Expired(now time.Time) bool {
+	return !now.Before(u.ExpiresAt)
+}
+
+// Chunk is the part of an upload received by a single request, stored as an
+// object of its own.
+type Chunk struct {
+	UploadId    uuid.UUID
+	StartOffset int64
+	Size        int64
+	StorageKey  string
+}
+
+type Repository interface {
+	CreateUpload(ctx context.Context, upload Upload) (Upload, error)
+	GetUploadById(ctx context.Context, id uuid.UUID) (Upload, error)
+	// AppendChunk records chunk, advances the upload offset by its size and
+	// moves its expiry to expiresAt, provided the offset still equals the
+	// chunk start. Otherwise it returns ErrOffsetMismatch and records nothing.
+	AppendChunk(ctx context.Context, chunk Chunk, expiresAt time.Time) (Upload, error)
+	GetChunksByUploadId(ctx context.Context, uploadId uuid.UUID) ([]Chunk, error)
+	// FinishUpload links the upload to the image it was assembled into, or
+	// returns ErrUploadAlreadyFinished if another request got there first.
+	FinishUpload(ctx context.Context, id uuid.UUID, imageId uuid.UUID) (Upload, error)
+	DeleteUpload(ctx context.Context, id uuid.UUID) error
+	// GetExpiredUploads returns up to limit uploads that expired at now.
+	GetExpiredUploads(ctx context.Context, now time.Time, limit int) ([]Upload, error)
+}
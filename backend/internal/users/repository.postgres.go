@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"strings"
@@ -13,6 +14,10 @@ import (
 
 const postgresRepositorySource = "users.repo.pg"
 
+const userColumns = `id, email, password, is_active, created_at`
+
+const uniqueViolationCode = "23505"
+
 // TODO: maybe this shouldn't be here
 var allowedUpdateFields = map[string]struct{}{
 	"email":     {},
@@ -32,6 +37,10 @@ type pgUser struct {
 	createdAt pgtype.Timestamp
 }
 
+func (u *pgUser) scanTargets() []any {
+	return []any{&u.id, &u.email, &u.password, &u.isActive, &u.createdAt}
+}
+
 func fromPGUser(user pgUser) (User, error) {
 	id, err := uuid.FromBytes(user.id.Bytes[:])
 	if err != nil {
@@ -50,11 +59,12 @@ func toPGUser(user User) pgUser {
 	return pgUser{
 		id: pgtype.UUID{
 			Bytes: [16]byte(user.Id.Bytes()),
+			Valid: true,
 		},
 		email:     user.Email,
 		password:  user.Password,
 		isActive:  user.IsActive,
-		createdAt: pgtype.Timestamp{Time: user.CreatedAt.UTC()},
+		createdAt: pgtype.Timestamp{Time: user.CreatedAt.UTC(), Valid: true},
 	}
 }
 
@@ -67,22 +77,17 @@ func NewPostgresRepository(db *pgxpool.Pool) Repository {
 func (r *postgresRepository) getByField(ctx context.Context, field string, value any) (User, error) {
 	const op = postgresRepositorySource + ".getByField"
 	query := fmt.Sprintf(
-		`SELECT id, email, password, is_active, created_at FROM users WHERE %s = $1`,
+		`SELECT %s FROM users WHERE %s = $1`,
+		userColumns,
 		field,
 	)
 	row := r.db.QueryRow(ctx, query, value)
 	var user pgUser
-	if err := row.Scan(
-		&user.id,
-		&user.email,
-		&user.password,
-		&user.isActive,
-		&user.createdAt,
-	); err != nil {
+	if err := row.Scan(user.scanTargets()...); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return User{}, ErrUserNotFound
 		}
-		return User{}, fmt.Errorf("[%s]: %e", op, err)
+		return User{}, fmt.Errorf("[%s]: %w", op, err)
 	}
 	return fromPGUser(user)
 }
@@ -100,8 +105,8 @@ func (r *postgresRepository) UpdateUser(ctx context.Context, id uuid.UUID, field
 	if len(fieldValue) == 0 {
 		return r.GetUserById(ctx, id)
 	}
-	args := make([]any, len(fieldValue))
-	fieldsToSet := make([]string, len(fieldValue))
+	args := make([]any, 0, len(fieldValue)+1)
+	fieldsToSet := make([]string, 0, len(fieldValue))
 	for field, value := range fieldValue {
 		// TODO: think about it later
 		if _, ok := allowedUpdateFields[field]; !ok {
@@ -110,7 +115,7 @@ func (r *postgresRepository) UpdateUser(ctx context.Context, id uuid.UUID, field
 		args = append(args, value)
 		fieldsToSet = append(
 			fieldsToSet,
-			fmt.Sprintf("%s = $%d, ", field, len(args)),
+			fmt.Sprintf("%s = $%d", field, len(args)),
 		)
 	}
 	if len(fieldsToSet) == 0 {
@@ -119,43 +124,65 @@ func (r *postgresRepository) UpdateUser(ctx context.Context, id uuid.UUID, field
 	args = append(args, id)
 
 	query := fmt.Sprintf(
-		`UPDATE users SET %s WHERE id = $%d`,
+		`UPDATE users SET %s WHERE id = $%d RETURNING %s`,
 		strings.Join(fieldsToSet, ", "),
 		len(args),
+		userColumns,
 	)
 
 	row := r.db.QueryRow(ctx, query, args...)
 	var user pgUser
-	if err := row.Scan(&user.id, &user.email, &user.password, &user.isActive, &user.createdAt); err != nil {
-		return User{}, fmt.Errorf("[%s]: %e", op, err)
+	if err := row.Scan(user.scanTargets()...); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		if isUniqueViolation(err) {
+			return User{}, ErrUserExists
+		}
+		return User{}, fmt.Errorf("[%s]: %w", op, err)
 	}
 	return fromPGUser(user)
 }
 
 func (r *postgresRepository) CreateUser(ctx context.Context, user User) (User, error) {
-	const op = postgresRepositorySource + ".CreateSession"
+	const op = postgresRepositorySource + ".CreateUser"
 	query := `
-INSERT INTO users (email, password, is_active) 
-	VALUES ($1, $2, $3, $4) 
-	RETURNING id, email, password, is_active, created_at
-`
-	row := r.db.QueryRow(ctx, query, user.Email, user.Password, user.IsActive)
-	var createdUser User
-	if err := row.Scan(
-		&createdUser.Id,
-		&createdUser.Email,
-		&createdUser.Password,
-		&createdUser.IsActive,
-		&createdUser.CreatedAt,
-	); err != nil {
-		return user, fmt.Errorf("[%s]: %e", op, err)
+INSERT INTO users (` + userColumns + `) 
+	VALUES ($1, $2, $3, $4, $5) 
+	RETURNING ` + userColumns
+
+	toCreate := toPGUser(user)
+	row := r.db.QueryRow(
+		ctx,
+		query,
+		toCreate.id,
+		toCreate.email,
+		toCreate.password,
+		toCreate.isActive,
+		toCreate.createdAt,
+	)
+	var createdUser pgUser
+	if err := row.Scan(createdUser.scanTargets()...); err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrUserExists
+		}
+		return User{}, fmt.Errorf("[%s]: %w", op, err)
 	}
-	return user, nil
+	return fromPGUser(createdUser)
+}
+
+// isUniqueViolation reports whether err is postgres refusing a duplicate
+// email.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
 }
 
 func (r *postgresRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
 	const op = postgresRepositorySource + ".DeleteUser"
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := r.db.Exec(ctx, query, id)
-	return fmt.Errorf("[%s]: %e", op, err)
+	if _, err := r.db.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("[%s]: %w", op, err)
+	}
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/plinkplenk/img-share/pkg/password"
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,9 @@ type Service interface {
 	GetUserById(ctx context.Context, id uuid.UUID) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	ChangePassword(ctx context.Context, id uuid.UUID, newPassword, oldPassword string) error
+	// SetPassword replaces the password of id without asking for the old
+	// one, callers have to have proven who the user is some other way.
+	SetPassword(ctx context.Context, id uuid.UUID, newPassword string) error
 	CreateUser(ctx context.Context, user User) (User, error)
 	UpdateUser(ctx context.Context, id uuid.UUID, fieldValue map[string]any) (User, error)
 	DeleteUser(ctx context.Context, id uuid.UUID) error
@@ -58,7 +62,7 @@ func (s service) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
 func (s service) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	c, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	user, err := s.repository.GetUserByEmail(c, email)
+	user, err := s.repository.GetUserByEmail(c, strings.ToLower(strings.TrimSpace(email)))
 	if err != nil && !errors.Is(err, ErrUserNotFound) {
 		s.logger.Error("cannot get user by email", "error", err)
 	}
@@ -66,30 +70,44 @@ func (s service) GetUserByEmail(ctx context.Context, email string) (User, error)
 }
 
 func (s service) ChangePassword(ctx context.Context, id uuid.UUID, newPassword, oldPassword string) error {
-	c1, cancel1 := context.WithTimeout(ctx, s.timeout)
-	defer cancel1()
-	user, err := s.GetUserById(c1, id)
+	user, err := s.GetUserById(ctx, id)
 	if err != nil {
-		s.logger.Error("cannot get user by id", "error", err)
 		return err
 	}
-	if !password.Compare(user.Password, oldPassword) {
+	if !password.Compare(oldPassword, user.Password) {
 		return ErrPasswordsDidNotMatch
 	}
+	return s.SetPassword(ctx, id, newPassword)
+}
+
+func (s service) SetPassword(ctx context.Context, id uuid.UUID, newPassword string) error {
+	if len(newPassword) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
 	hash, err := s.hashPassword(newPassword)
 	if err != nil {
 		return err
 	}
-	c2, cancel2 := context.WithTimeout(ctx, s.timeout)
-	defer cancel2()
-	if _, err := s.repository.UpdateUser(c2, id, map[string]any{"password": hash}); err != nil {
-		s.logger.Error("cannot update user", "error", err)
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if _, err := s.repository.UpdateUser(c, id, map[string]any{"password": hash}); err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			s.logger.Error("cannot update user password", "error", err)
+		}
 		return err
 	}
 	return nil
 }
 
 func (s service) CreateUser(ctx context.Context, user User) (User, error) {
+	email, err := NormalizeEmail(user.Email)
+	if err != nil {
+		return User{}, err
+	}
+	if len(user.Password) < MinPasswordLength {
+		return User{}, ErrPasswordTooShort
+	}
+	user.Email = email
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, err
@@ -101,15 +119,25 @@ func (s service) CreateUser(ctx context.Context, user User) (User, error) {
 	defer cancel()
 	createdUser, err := s.repository.CreateUser(c, user)
 	if err != nil {
-		s.logger.Error("cannot create user", "error", err)
+		if !errors.Is(err, ErrUserExists) {
+			s.logger.Error("cannot create user", "error", err)
+		}
 		return User{}, err
 	}
 	return createdUser, err
 }
 
 func (s service) UpdateUser(ctx context.Context, id uuid.UUID, fieldValue map[string]any) (User, error) {
-	//TODO implement me
-	panic("implement me")
+	c, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	user, err := s.repository.UpdateUser(c, id, fieldValue)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrUserExists) {
+			s.logger.Error("cannot update user", "error", err)
+		}
+		return User{}, err
+	}
+	return user, nil
 }
 
 func (s service) DeleteUser(ctx context.Context, id uuid.UUID) error {
@@ -3,13 +3,21 @@ package users
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 )
 
+const MinPasswordLength = 8
+
 var (
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("user with this email already exists")
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
 )
 
 type User struct {
@@ -27,3 +35,14 @@ type Repository interface {
 	UpdateUser(ctx context.Context, id uuid.UUID, fieldValue map[string]any) (User, error)
 	DeleteUser(ctx context.Context, id uuid.UUID) error
 }
+
+// NormalizeEmail validates a bare email address and lowercases it, so that
+// the same mailbox cannot be registered twice with different cases.
+func NormalizeEmail(raw string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,110 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"github.com/plinkplenk/img-share/internal/mailer"
+	"github.com/plinkplenk/img-share/internal/tokens"
+	"github.com/plinkplenk/img-share/internal/users"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const tokenQueryParamName = "token"
+
+type Service interface {
+	// Send mails user a link verifying their email address, in locale when
+	// there are templates for it. Links can only be asked for once per
+	// resend interval, a new link invalidates the previous one.
+	Send(ctx context.Context, user users.User, locale string) error
+	// Verify consumes the token sent in a link and activates its user.
+	Verify(ctx context.Context, token string) (users.User, error)
+}
+
+type service struct {
+	tokensService  tokens.Service
+	usersService   users.Service
+	mailerService  mailer.Service
+	verifyURL      url.URL
+	tokenTTL       time.Duration
+	resendInterval time.Duration
+	logger         *slog.Logger
+}
+
+func NewService(
+	tokensService tokens.Service,
+	usersService users.Service,
+	mailerService mailer.Service,
+	verifyURL url.URL,
+	tokenTTL time.Duration,
+	resendInterval time.Duration,
+	logger *slog.Logger,
+) Service {
+	return service{
+		tokensService:  tokensService,
+		usersService:   usersService,
+		mailerService:  mailerService,
+		verifyURL:      verifyURL,
+		tokenTTL:       tokenTTL,
+		resendInterval: resendInterval,
+		logger:         logger,
+	}
+}
+
+func (s service) link(token string) string {
+	link := s.verifyURL
+	query := link.Query()
+	query.Set(tokenQueryParamName, token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+func (s service) Send(ctx context.Context, user users.User, locale string) error {
+	if user.IsActive {
+		return ErrAlreadyVerified
+	}
+	lastSent, err := s.tokensService.LastIssuedAt(ctx, user.Id, tokens.PurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if wait := s.resendInterval - time.Since(lastSent); !lastSent.IsZero() && wait > 0 {
+		return ThrottledError{RetryAfter: wait}
+	}
+	token, _, err := s.tokensService.Issue(ctx, user.Id, tokens.PurposeVerifyEmail, user.Email, s.tokenTTL)
+	if err != nil {
+		return err
+	}
+	data := mailer.VerifyEmailData{Link: s.link(token)}
+	if err := s.mailerService.Queue(ctx, user.Email, mailer.TemplateVerifyEmail, locale, data); err != nil {
+		s.logger.Error("cannot send verification email", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (s service) Verify(ctx context.Context, token string) (users.User, error) {
+	consumed, err := s.tokensService.Consume(ctx, tokens.PurposeVerifyEmail, token)
+	if err != nil {
+		if errors.Is(err, tokens.ErrTokenNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	user, err := s.usersService.GetUserById(ctx, consumed.UserId)
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return users.User{}, ErrInvalidToken
+		}
+		return users.User{}, err
+	}
+	// the link verifies the address it was sent to, not one the user
+	// switched to since
+	if user.Email != consumed.Email {
+		return users.User{}, ErrInvalidToken
+	}
+	if user.IsActive {
+		return user, nil
+	}
+	return s.usersService.UpdateUser(ctx, user.Id, map[string]any{"is_active": true})
+}
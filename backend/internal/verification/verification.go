@@ -0,0 +1,27 @@
+package verification
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAlreadyVerified = errors.New("email address is already verified")
+	ErrInvalidToken    = errors.New("invalid or expired verification token")
+	ErrResendThrottled = errors.New("verification email was sent recently")
+)
+
+// ThrottledError is returned when a verification email is asked for again
+// too soon, it matches ErrResendThrottled.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrResendThrottled, e.RetryAfter)
+}
+
+func (e ThrottledError) Is(target error) bool {
+	return target == ErrResendThrottled
+}
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS images(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    owner_id UUID NOT NULL,
+    storage_key TEXT NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    original_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_images_owner FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS images_owner_id_and_created_at_index ON images(owner_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS images_owner_id_and_created_at_index;
+DROP TABLE IF EXISTS images;
+-- +goose StatementEnd
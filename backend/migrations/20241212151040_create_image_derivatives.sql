@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS image_derivatives(
+    image_id UUID NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    storage_key TEXT NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (image_id, name),
+    CONSTRAINT fk_image_derivatives_image FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS image_derivatives;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS camera_make TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS camera_model TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS orientation SMALLINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS metadata_stripped BOOLEAN NOT NULL DEFAULT true;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE images
+    DROP COLUMN IF EXISTS metadata_stripped,
+    DROP COLUMN IF EXISTS orientation,
+    DROP COLUMN IF EXISTS taken_at,
+    DROP COLUMN IF EXISTS camera_model,
+    DROP COLUMN IF EXISTS camera_make;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS image_blobs(
+    storage_key TEXT UNIQUE NOT NULL PRIMARY KEY,
+    content_type VARCHAR(32) NOT NULL,
+    size BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL CHECK (ref_count >= 0),
+    created_at TIMESTAMP NOT NULL
+);
+-- originals uploaded before deduplication each become a blob of their own
+INSERT INTO image_blobs (storage_key, content_type, size, ref_count, created_at)
+    SELECT storage_key, MIN(content_type), MIN(size), COUNT(*), MIN(created_at)
+    FROM images
+    GROUP BY storage_key
+    ON CONFLICT (storage_key) DO NOTHING;
+ALTER TABLE images
+    ADD CONSTRAINT fk_images_blob FOREIGN KEY (storage_key) REFERENCES image_blobs(storage_key);
+CREATE INDEX IF NOT EXISTS images_storage_key_index ON images(storage_key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS images_storage_key_index;
+ALTER TABLE images DROP CONSTRAINT IF EXISTS fk_images_blob;
+DROP TABLE IF EXISTS image_blobs;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS uploads(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    owner_id UUID NOT NULL,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL DEFAULT '',
+    image_id UUID,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_uploads_owner FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_uploads_image FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE SET NULL,
+    CONSTRAINT uploads_offset_within_length CHECK (upload_offset >= 0 AND upload_offset <= length)
+);
+CREATE TABLE IF NOT EXISTS upload_chunks(
+    upload_id UUID NOT NULL,
+    start_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    PRIMARY KEY (upload_id, start_offset),
+    CONSTRAINT fk_upload_chunks_upload FOREIGN KEY (upload_id) REFERENCES uploads(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS upload_chunks;
+DROP TABLE IF EXISTS uploads;
+-- +goose StatementEnd
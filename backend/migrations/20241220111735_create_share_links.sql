@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS share_links(
+    token VARCHAR(64) UNIQUE NOT NULL PRIMARY KEY,
+    owner_id UUID NOT NULL,
+    image_id UUID NOT NULL,
+    expires_at TIMESTAMP,
+    max_views INTEGER NOT NULL DEFAULT 0 CHECK (max_views >= 0),
+    views INTEGER NOT NULL DEFAULT 0,
+    password_hash TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_share_links_owner FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_share_links_image FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS share_links_owner_id_and_created_at_index ON share_links(owner_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS share_links_owner_id_and_created_at_index;
+DROP TABLE IF EXISTS share_links;
+-- +goose StatementEnd
@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS albums(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    owner_id UUID NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    cover_image_id UUID,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_albums_owner FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_albums_cover_image FOREIGN KEY (cover_image_id) REFERENCES images(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS albums_owner_id_and_created_at_index ON albums(owner_id, created_at);
+-- positions are sparse, moving an image only rewrites its own row; the
+-- uniqueness check is deferred so that renumbering an album can shift rows
+-- past each other within one statement
+CREATE TABLE IF NOT EXISTS album_images(
+    album_id UUID NOT NULL,
+    image_id UUID NOT NULL,
+    position BIGINT NOT NULL,
+    added_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (album_id, image_id),
+    CONSTRAINT album_images_position_unique UNIQUE (album_id, position) DEFERRABLE INITIALLY DEFERRED,
+    CONSTRAINT fk_album_images_album FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+    CONSTRAINT fk_album_images_image FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS album_images_image_id_index ON album_images(image_id);
+ALTER TABLE share_links
+    ALTER COLUMN image_id DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS album_id UUID,
+    ADD CONSTRAINT fk_share_links_album FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE,
+    ADD CONSTRAINT share_links_single_target CHECK ((image_id IS NULL) <> (album_id IS NULL));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DELETE FROM share_links WHERE album_id IS NOT NULL;
+ALTER TABLE share_links
+    DROP CONSTRAINT IF EXISTS share_links_single_target,
+    DROP CONSTRAINT IF EXISTS fk_share_links_album,
+    DROP COLUMN IF EXISTS album_id,
+    ALTER COLUMN image_id SET NOT NULL;
+DROP INDEX IF EXISTS album_images_image_id_index;
+DROP TABLE IF EXISTS album_images;
+DROP INDEX IF EXISTS albums_owner_id_and_created_at_index;
+DROP TABLE IF EXISTS albums;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS visibility VARCHAR(16) NOT NULL DEFAULT 'private',
+    ADD CONSTRAINT images_visibility_check CHECK (visibility IN ('private', 'unlisted', 'public'));
+ALTER TABLE albums
+    ADD COLUMN IF NOT EXISTS visibility VARCHAR(16) NOT NULL DEFAULT 'private',
+    ADD CONSTRAINT albums_visibility_check CHECK (visibility IN ('private', 'unlisted', 'public'));
+-- feeds only ever read public rows, newest first
+CREATE INDEX IF NOT EXISTS images_public_feed_index ON images(created_at DESC, id DESC)
+    WHERE visibility = 'public';
+CREATE INDEX IF NOT EXISTS albums_public_feed_index ON albums(created_at DESC, id DESC)
+    WHERE visibility = 'public';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS albums_public_feed_index;
+DROP INDEX IF EXISTS images_public_feed_index;
+ALTER TABLE albums
+    DROP CONSTRAINT IF EXISTS albums_visibility_check,
+    DROP COLUMN IF EXISTS visibility;
+ALTER TABLE images
+    DROP CONSTRAINT IF EXISTS images_visibility_check,
+    DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
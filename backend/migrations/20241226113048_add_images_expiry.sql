@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS burn_after_reading BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS burned_at TIMESTAMP;
+-- the reaper only ever looks for rows with one of these set
+CREATE INDEX IF NOT EXISTS images_expires_at_index ON images(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS images_burned_at_index ON images(burned_at) WHERE burned_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS images_burned_at_index;
+DROP INDEX IF EXISTS images_expires_at_index;
+ALTER TABLE images
+    DROP COLUMN IF EXISTS burned_at,
+    DROP COLUMN IF EXISTS burn_after_reading,
+    DROP COLUMN IF EXISTS expires_at;
+-- +goose StatementEnd
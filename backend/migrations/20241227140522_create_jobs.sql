@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS jobs(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    kind VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    key TEXT,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    CONSTRAINT jobs_status_check CHECK (status IN ('pending', 'running', 'dead'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_key_index ON jobs(key) WHERE key IS NOT NULL;
+-- workers look for due jobs of their kinds, dead letters are left out
+CREATE INDEX IF NOT EXISTS jobs_kind_and_run_at_index ON jobs(kind, run_at) WHERE status <> 'dead';
+CREATE TABLE IF NOT EXISTS job_schedules(
+    name VARCHAR(64) NOT NULL PRIMARY KEY,
+    next_run_at TIMESTAMP NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS job_schedules;
+DROP INDEX IF EXISTS jobs_kind_and_run_at_index;
+DROP INDEX IF EXISTS jobs_key_index;
+DROP TABLE IF EXISTS jobs;
+-- +goose StatementEnd
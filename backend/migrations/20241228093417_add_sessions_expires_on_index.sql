@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE INDEX IF NOT EXISTS auth_sessions_expires_on_index ON auth_sessions(expires_on);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS auth_sessions_expires_on_index;
+-- +goose StatementEnd
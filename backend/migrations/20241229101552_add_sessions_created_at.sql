@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- sessions from before the column count their maximum lifetime from now on
+ALTER TABLE auth_sessions
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'utc');
+ALTER TABLE auth_sessions ALTER COLUMN created_at DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE auth_sessions DROP COLUMN IF EXISTS created_at;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE auth_sessions
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+UPDATE auth_sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL;
+ALTER TABLE auth_sessions ALTER COLUMN last_seen_at SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE auth_sessions
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS last_seen_at;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS user_tokens(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS user_tokens_purpose_and_hash_index ON user_tokens(purpose, hash);
+CREATE INDEX IF NOT EXISTS user_tokens_user_id_and_purpose_index ON user_tokens(user_id, purpose);
+CREATE INDEX IF NOT EXISTS user_tokens_expires_at_index ON user_tokens(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS user_tokens_expires_at_index;
+DROP INDEX IF EXISTS user_tokens_user_id_and_purpose_index;
+DROP INDEX IF EXISTS user_tokens_purpose_and_hash_index;
+DROP TABLE IF EXISTS user_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS user_totp(
+    user_id UUID UNIQUE NOT NULL PRIMARY KEY,
+    secret BYTEA NOT NULL,
+    confirmed_at TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user_totp_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS user_recovery_codes(
+    id UUID UNIQUE NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    CONSTRAINT fk_user_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS user_recovery_codes_user_id_and_hash_index ON user_recovery_codes(user_id, hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS user_recovery_codes_user_id_and_hash_index;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
+-- +goose StatementEnd
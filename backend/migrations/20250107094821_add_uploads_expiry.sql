@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+-- uploads started before expiry existed get a day from their last chunk
+UPDATE uploads SET expires_at = updated_at + INTERVAL '24 hours' WHERE expires_at IS NULL;
+ALTER TABLE uploads ALTER COLUMN expires_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS uploads_expires_at_index ON uploads(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS uploads_expires_at_index;
+ALTER TABLE uploads DROP COLUMN IF EXISTS expires_at;
+-- +goose StatementEnd
@@ -1,6 +1,20 @@
 package cookies
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
+
+func Set(cookieName string, value string, expires time.Time, w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires.UTC(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
 
 func Delete(cookieName string, w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
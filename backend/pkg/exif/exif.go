@@ -0,0 +1,192 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFDPointer     = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	typeASCII = 2
+	typeShort = 3
+	typeLong  = 4
+
+	dateTimeLayout = "2006:01:02 15:04:05"
+)
+
+var ErrInvalidExif = errors.New("invalid exif data")
+
+// Metadata holds the few EXIF fields worth keeping after the rest of the
+// metadata is removed.
+type Metadata struct {
+	CameraMake  string
+	CameraModel string
+	TakenAt     time.Time
+	// Orientation is the EXIF orientation from 1 to 8, 1 meaning upright.
+	Orientation int
+	// Stripped is set by Filter when the metadata was removed from the image.
+	Stripped bool
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// Parse reads Metadata from a TIFF structured EXIF payload, the part of a
+// JPEG APP1 segment following "Exif\x00\x00" or a PNG eXIf chunk.
+func Parse(tiff []byte) (Metadata, error) {
+	meta := Metadata{Orientation: 1}
+	if len(tiff) < 8 {
+		return meta, ErrInvalidExif
+	}
+	r := tiffReader{data: tiff}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		r.order = binary.LittleEndian
+	case "MM\x00*":
+		r.order = binary.BigEndian
+	default:
+		return meta, ErrInvalidExif
+	}
+
+	var dateTime, dateTimeOriginal, offsetTimeOriginal string
+	var exifIFD uint32
+	err := r.walkIFD(r.order.Uint32(tiff[4:8]), func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagMake:
+			meta.CameraMake = r.ascii(typ, value)
+		case tagModel:
+			meta.CameraModel = r.ascii(typ, value)
+		case tagOrientation:
+			if typ == typeShort && count > 0 {
+				if o := int(r.order.Uint16(value)); o >= 1 && o <= 8 {
+					meta.Orientation = o
+				}
+			}
+		case tagDateTime:
+			dateTime = r.ascii(typ, value)
+		case tagExifIFDPointer:
+			if typ == typeLong && count > 0 {
+				exifIFD = r.order.Uint32(value)
+			}
+		}
+	})
+	if err != nil {
+		return meta, err
+	}
+	if exifIFD != 0 {
+		err := r.walkIFD(exifIFD, func(tag, typ uint16, _ uint32, value []byte) {
+			switch tag {
+			case tagDateTimeOriginal:
+				dateTimeOriginal = r.ascii(typ, value)
+			case tagOffsetTimeOriginal:
+				offsetTimeOriginal = r.ascii(typ, value)
+			}
+		})
+		if err != nil {
+			return meta, err
+		}
+	}
+	if dateTimeOriginal == "" {
+		dateTimeOriginal = dateTime
+	}
+	meta.TakenAt = parseDateTime(dateTimeOriginal, offsetTimeOriginal)
+	return meta, nil
+}
+
+func (r tiffReader) walkIFD(offset uint32, visit func(tag, typ uint16, count uint32, value []byte)) error {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return ErrInvalidExif
+	}
+	entries := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+entries*12 > len(r.data) {
+		return ErrInvalidExif
+	}
+	for i := 0; i < entries; i++ {
+		entry := r.data[start+i*12 : start+(i+1)*12]
+		tag := r.order.Uint16(entry[0:2])
+		typ := r.order.Uint16(entry[2:4])
+		count := r.order.Uint32(entry[4:8])
+		size := uint64(count) * uint64(typeSize(typ))
+		value := entry[8:12]
+		if size > 4 {
+			valueOffset := uint64(r.order.Uint32(entry[8:12]))
+			if valueOffset+size > uint64(len(r.data)) {
+				continue
+			}
+			value = r.data[valueOffset : valueOffset+size]
+		}
+		visit(tag, typ, count, value)
+	}
+	return nil
+}
+
+func (r tiffReader) ascii(typ uint16, value []byte) string {
+	if typ != typeASCII {
+		return ""
+	}
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	default:
+		return 1
+	}
+}
+
+func parseDateTime(value, offset string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if t, err := time.Parse(dateTimeLayout+"-07:00", value+offset); err == nil {
+			return t.UTC()
+		}
+	}
+	// without an offset the camera's wall clock is all we have
+	t, err := time.Parse(dateTimeLayout, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// orientationOnly builds a minimal TIFF structure holding nothing but the
+// orientation tag, used to keep images displayed upright after everything
+// else was stripped.
+func orientationOnly(orientation int) []byte {
+	buf := make([]byte, 0, 26)
+	buf = append(buf, "MM\x00*"...)
+	buf = binary.BigEndian.AppendUint32(buf, 8)
+	buf = binary.BigEndian.AppendUint16(buf, 1)
+	buf = binary.BigEndian.AppendUint16(buf, tagOrientation)
+	buf = binary.BigEndian.AppendUint16(buf, typeShort)
+	buf = binary.BigEndian.AppendUint32(buf, 1)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(orientation))
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	// no next IFD
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	return buf
+}
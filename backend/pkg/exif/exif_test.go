@@ -0,0 +1,165 @@
+package exif
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiEntry(tag uint16, value string) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeASCII, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+func shortEntry(order byteOrder, tag uint16, value uint16) tiffEntry {
+	return tiffEntry{tag: tag, typ: typeShort, count: 1, value: order.AppendUint16(nil, value)}
+}
+
+// serializeIFD lays out entries as an IFD at offset start followed by the
+// values that do not fit in an entry.
+func serializeIFD(order byteOrder, entries []tiffEntry, start int) []byte {
+	dataStart := start + 2 + len(entries)*12 + 4
+	var ifd, data []byte
+	ifd = order.AppendUint16(ifd, uint16(len(entries)))
+	for _, entry := range entries {
+		ifd = order.AppendUint16(ifd, entry.tag)
+		ifd = order.AppendUint16(ifd, entry.typ)
+		ifd = order.AppendUint32(ifd, entry.count)
+		if len(entry.value) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.value)
+			ifd = append(ifd, value...)
+			continue
+		}
+		ifd = order.AppendUint32(ifd, uint32(dataStart+len(data)))
+		data = append(data, entry.value...)
+	}
+	ifd = order.AppendUint32(ifd, 0)
+	return append(ifd, data...)
+}
+
+// buildTIFF builds an EXIF payload with ifd0 and, when given, an EXIF
+// sub-IFD holding exifIFD.
+func buildTIFF(order byteOrder, ifd0 []tiffEntry, exifIFD []tiffEntry) []byte {
+	header := []byte("II*\x00")
+	if order == binary.BigEndian {
+		header = []byte("MM\x00*")
+	}
+	header = order.AppendUint32(header, 8)
+	if exifIFD == nil {
+		return append(header, serializeIFD(order, ifd0, 8)...)
+	}
+	pointer := tiffEntry{tag: tagExifIFDPointer, typ: typeLong, count: 1}
+	entries := append(append([]tiffEntry{}, ifd0...), pointer)
+	exifStart := 8 + len(serializeIFD(order, entries, 8))
+	entries[len(entries)-1].value = order.AppendUint32(nil, uint32(exifStart))
+	tiff := append(header, serializeIFD(order, entries, 8)...)
+	return append(tiff, serializeIFD(order, exifIFD, exifStart)...)
+}
+
+func cameraTIFF(order byteOrder, orientation uint16) []byte {
+	return buildTIFF(order, []tiffEntry{
+		asciiEntry(tagMake, "Acme"),
+		asciiEntry(tagModel, "Shooter 3000"),
+		shortEntry(order, tagOrientation, orientation),
+		asciiEntry(tagDateTime, "2024:01:02 03:04:05"),
+	}, []tiffEntry{
+		asciiEntry(tagDateTimeOriginal, "2023:06:07 08:09:10"),
+		asciiEntry(tagOffsetTimeOriginal, "+02:00"),
+	})
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiff    []byte
+		want    Metadata
+		wantErr bool
+	}{
+		{
+			name: "little endian",
+			tiff: cameraTIFF(binary.LittleEndian, 6),
+			want: Metadata{
+				CameraMake:  "Acme",
+				CameraModel: "Shooter 3000",
+				TakenAt:     time.Date(2023, 6, 7, 6, 9, 10, 0, time.UTC),
+				Orientation: 6,
+			},
+		},
+		{
+			name: "big endian",
+			tiff: cameraTIFF(binary.BigEndian, 8),
+			want: Metadata{
+				CameraMake:  "Acme",
+				CameraModel: "Shooter 3000",
+				TakenAt:     time.Date(2023, 6, 7, 6, 9, 10, 0, time.UTC),
+				Orientation: 8,
+			},
+		},
+		{
+			name: "date time without exif ifd",
+			tiff: buildTIFF(binary.LittleEndian, []tiffEntry{
+				asciiEntry(tagDateTime, "2024:01:02 03:04:05"),
+			}, nil),
+			want: Metadata{TakenAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Orientation: 1},
+		},
+		{
+			name: "orientation out of range",
+			tiff: buildTIFF(binary.LittleEndian, []tiffEntry{
+				shortEntry(binary.LittleEndian, tagOrientation, 9),
+			}, nil),
+			want: Metadata{Orientation: 1},
+		},
+		{
+			name: "orientation only",
+			tiff: orientationOnly(3),
+			want: Metadata{Orientation: 3},
+		},
+		{
+			name:    "not tiff",
+			tiff:    []byte("JFIF\x00\x00\x00\x08"),
+			want:    Metadata{Orientation: 1},
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			tiff:    []byte("II*\x00"),
+			want:    Metadata{Orientation: 1},
+			wantErr: true,
+		},
+		{
+			name:    "ifd out of bounds",
+			tiff:    []byte("II*\x00\xff\x00\x00\x00"),
+			want:    Metadata{Orientation: 1},
+			wantErr: true,
+		},
+		{
+			name:    "entries past the end",
+			tiff:    append([]byte("II*\x00\x08\x00\x00\x00"), 0x10, 0x00),
+			want:    Metadata{Orientation: 1},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.tiff)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	jpegMarkerPrefix = 0xFF
+	jpegSOI          = 0xD8
+	jpegEOI          = 0xD9
+	jpegSOS          = 0xDA
+	jpegAPP1         = 0xE1
+	jpegAPP2         = 0xE2
+	jpegAPP13        = 0xED
+	jpegCOM          = 0xFE
+
+	pngSignature      = "\x89PNG\r\n\x1a\n"
+	maxExifLength     = 1 << 20
+	pngChunkExif      = "eXIf"
+	pngChunkEnd       = "IEND"
+	jpegExifHeader    = "Exif\x00\x00"
+	jpegXMPHeader     = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegXMPExtHeader  = "http://ns.adobe.com/xmp/extension/\x00"
+	jpegMPFHeader     = "MPF\x00"
+	jpegMaxSegmentLen = 0xFFFF
+
+	webpRIFF      = "RIFF"
+	webpFormType  = "WEBP"
+	webpChunkVP8X = "VP8X"
+	webpChunkExif = "EXIF"
+	webpChunkXMP  = "XMP "
+	// webpChunkJunk takes the place of dropped chunks, readers skip chunks
+	// they do not know.
+	webpChunkJunk = "JUNK"
+	// webpFlagXMP is the VP8X feature flag announcing the XMP chunk, it is
+	// cleared along with the chunk.
+	webpFlagXMP = 0x04
+)
+
+var ErrInvalidImage = errors.New("invalid image structure")
+
+// pngMetadataChunks are the ancillary chunks removed when stripping, they
+// carry free form text including XMP packets.
+var pngMetadataChunks = map[string]struct{}{
+	"tEXt": {},
+	"zTXt": {},
+	"iTXt": {},
+	"tIME": {},
+}
+
+// Filter copies the JPEG, PNG or WebP image from r to w segment by segment
+// and returns the Metadata found in its EXIF block. With strip set, EXIF,
+// XMP, IPTC and comment segments are dropped; a minimal EXIF block holding
+// only the orientation is written in their place so the image keeps
+// displaying upright. Data after the end of a JPEG image is dropped too, it
+// is where phones keep secondary images and further EXIF. Other content types are copied unchanged, the returned
+// Metadata.Stripped tells whether anything could be stripped. The pixel data
+// is never decoded or re-encoded.
+func Filter(w io.Writer, r io.Reader, contentType string, strip bool) (Metadata, error) {
+	var (
+		meta Metadata
+		err  error
+	)
+	switch contentType {
+	case "image/jpeg":
+		meta, err = filterJPEG(w, r, strip)
+	case "image/png":
+		meta, err = filterPNG(w, r, strip)
+	case "image/webp":
+		meta, err = filterWebP(w, r, strip)
+	default:
+		_, err = io.Copy(w, r)
+		return Metadata{Orientation: 1}, err
+	}
+	meta.Stripped = strip
+	return meta, err
+}
+
+func filterJPEG(w io.Writer, r io.Reader, strip bool) (Metadata, error) {
+	meta := Metadata{Orientation: 1}
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return meta, err
+	}
+	if soi[0] != jpegMarkerPrefix || soi[1] != jpegSOI {
+		return meta, ErrInvalidImage
+	}
+	if _, err := w.Write(soi[:]); err != nil {
+		return meta, err
+	}
+
+	// next is the marker that ended the entropy coded data of a scan, zero
+	// when the next marker is still to be read
+	var next byte
+	for {
+		marker := next
+		next = 0
+		if marker == 0 {
+			prefix, err := br.ReadByte()
+			if err != nil {
+				return meta, err
+			}
+			if prefix != jpegMarkerPrefix {
+				return meta, ErrInvalidImage
+			}
+			marker = jpegMarkerPrefix
+			for marker == jpegMarkerPrefix {
+				if marker, err = br.ReadByte(); err != nil {
+					return meta, err
+				}
+			}
+		}
+		switch {
+		case marker == jpegEOI:
+			if _, err := w.Write([]byte{jpegMarkerPrefix, marker}); err != nil {
+				return meta, err
+			}
+			if !strip {
+				_, err := io.Copy(w, br)
+				return meta, err
+			}
+			// whatever follows the image, such as the secondary images of
+			// MPF files or EXIF appended by phones, is dropped
+			return meta, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			if _, err := w.Write([]byte{jpegMarkerPrefix, marker}); err != nil {
+				return meta, err
+			}
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return meta, err
+		}
+		size := int(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return meta, ErrInvalidImage
+		}
+		payload := make([]byte, size-2)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return meta, err
+		}
+
+		keep := true
+		var replacement []byte
+		switch marker {
+		case jpegAPP1:
+			switch {
+			case bytes.HasPrefix(payload, []byte(jpegExifHeader)):
+				if parsed, err := Parse(payload[len(jpegExifHeader):]); err == nil {
+					meta = parsed
+				}
+				if strip {
+					keep = false
+					if meta.Orientation != 1 {
+						replacement = append([]byte(jpegExifHeader), orientationOnly(meta.Orientation)...)
+					}
+				}
+			case bytes.HasPrefix(payload, []byte(jpegXMPHeader)), bytes.HasPrefix(payload, []byte(jpegXMPExtHeader)):
+				keep = !strip
+			}
+		case jpegAPP2:
+			// the MPF index points at the secondary images dropped at EOI
+			keep = !strip || !bytes.HasPrefix(payload, []byte(jpegMPFHeader))
+		case jpegAPP13, jpegCOM:
+			keep = !strip
+		}
+
+		if keep {
+			if err := writeJPEGSegment(w, marker, payload); err != nil {
+				return meta, err
+			}
+		} else if replacement != nil {
+			if err := writeJPEGSegment(w, jpegAPP1, replacement); err != nil {
+				return meta, err
+			}
+		}
+		if marker == jpegSOS {
+			var err error
+			if next, err = copyEntropyCoded(w, br); err != nil {
+				if errors.Is(err, io.EOF) {
+					// truncated image, kept as it is
+					return meta, nil
+				}
+				return meta, err
+			}
+		}
+	}
+}
+
+// copyEntropyCoded copies the entropy coded data of a scan, restart markers
+// and stuffed bytes included, and returns the marker that ends it.
+func copyEntropyCoded(w io.Writer, br *bufio.Reader) (byte, error) {
+	for {
+		data, err := br.ReadSlice(jpegMarkerPrefix)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			if _, err := w.Write(data); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			if _, werr := w.Write(data); werr != nil {
+				return 0, werr
+			}
+			return 0, err
+		}
+		// data ends with the prefix, which may be followed by fill bytes
+		if _, err := w.Write(data[:len(data)-1]); err != nil {
+			return 0, err
+		}
+		marker := byte(jpegMarkerPrefix)
+		for marker == jpegMarkerPrefix {
+			if marker, err = br.ReadByte(); err != nil {
+				return 0, err
+			}
+		}
+		if marker != 0x00 && (marker < 0xD0 || marker > 0xD7) {
+			return marker, nil
+		}
+		if _, err := w.Write([]byte{jpegMarkerPrefix, marker}); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func writeJPEGSegment(w io.Writer, marker byte, payload []byte) error {
+	if len(payload)+2 > jpegMaxSegmentLen {
+		return ErrInvalidImage
+	}
+	header := []byte{jpegMarkerPrefix, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func filterPNG(w io.Writer, r io.Reader, strip bool) (Metadata, error) {
+	meta := Metadata{Orientation: 1}
+	br := bufio.NewReader(r)
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, signature); err != nil {
+		return meta, err
+	}
+	if string(signature) != pngSignature {
+		return meta, ErrInvalidImage
+	}
+	if _, err := w.Write(signature); err != nil {
+		return meta, err
+	}
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return meta, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:8])
+
+		switch _, isMetadata := pngMetadataChunks[chunkType]; {
+		case chunkType == pngChunkExif:
+			if length > maxExifLength {
+				return meta, ErrInvalidImage
+			}
+			data := make([]byte, length+4)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return meta, err
+			}
+			if parsed, err := Parse(data[:length]); err == nil {
+				meta = parsed
+			}
+			if !strip {
+				if err := writeAll(w, header[:], data); err != nil {
+					return meta, err
+				}
+			} else if meta.Orientation != 1 {
+				if err := writePNGChunk(w, pngChunkExif, orientationOnly(meta.Orientation)); err != nil {
+					return meta, err
+				}
+			}
+		case strip && isMetadata:
+			if _, err := io.CopyN(io.Discard, br, length+4); err != nil {
+				return meta, err
+			}
+		default:
+			if _, err := w.Write(header[:]); err != nil {
+				return meta, err
+			}
+			if _, err := io.CopyN(w, br, length+4); err != nil {
+				return meta, err
+			}
+		}
+
+		if chunkType == pngChunkEnd {
+			_, err := io.Copy(w, br)
+			return meta, err
+		}
+	}
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], chunkType)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	return writeAll(w, header[:], data, binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+}
+
+func writeAll(w io.Writer, chunks ...[]byte) error {
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterWebP streams a WebP image chunk by chunk. With strip set, the EXIF
+// chunk is overwritten with an EXIF block holding only the orientation and
+// the XMP chunk with a JUNK chunk of the same size, so that the RIFF size
+// in the header, written before the metadata at the end is seen, stays
+// right without buffering the image.
+func filterWebP(w io.Writer, r io.Reader, strip bool) (Metadata, error) {
+	meta := Metadata{Orientation: 1}
+	br := bufio.NewReader(r)
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return meta, err
+	}
+	if string(header[:4]) != webpRIFF || string(header[8:12]) != webpFormType {
+		return meta, ErrInvalidImage
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return meta, err
+	}
+
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return meta, nil
+			}
+			return meta, err
+		}
+		chunkType := string(chunk[:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch {
+		case chunkType == webpChunkExif:
+			if length > maxExifLength {
+				return meta, ErrInvalidImage
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return meta, err
+			}
+			if parsed, err := Parse(bytes.TrimPrefix(data, []byte(jpegExifHeader))); err == nil {
+				meta = parsed
+			}
+			if strip {
+				clear(data)
+				if orientation := orientationOnly(meta.Orientation); len(orientation) <= len(data) {
+					copy(data, orientation)
+				}
+			}
+			if err := writeAll(w, chunk[:], data); err != nil {
+				return meta, err
+			}
+		case chunkType == webpChunkXMP && strip:
+			copy(chunk[:4], webpChunkJunk)
+			if _, err := w.Write(chunk[:]); err != nil {
+				return meta, err
+			}
+			if _, err := io.CopyN(io.Discard, br, length); err != nil {
+				return meta, err
+			}
+			if _, err := io.CopyN(w, zeros{}, length); err != nil {
+				return meta, err
+			}
+		case chunkType == webpChunkVP8X && strip:
+			if length < 1 {
+				return meta, ErrInvalidImage
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return meta, err
+			}
+			data[0] &^= webpFlagXMP
+			if err := writeAll(w, chunk[:], data); err != nil {
+				return meta, err
+			}
+		default:
+			if _, err := w.Write(chunk[:]); err != nil {
+				return meta, err
+			}
+			if _, err := io.CopyN(w, br, length); err != nil {
+				return meta, err
+			}
+		}
+		// chunks are padded to an even size, some encoders leave the
+		// padding of the last one out
+		if length%2 == 1 {
+			pad, err := br.ReadByte()
+			if errors.Is(err, io.EOF) {
+				return meta, nil
+			}
+			if err != nil {
+				return meta, err
+			}
+			if _, err := w.Write([]byte{pad}); err != nil {
+				return meta, err
+			}
+		}
+	}
+}
+
+// zeros reads an endless run of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,269 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secret stands for the metadata that must not survive stripping.
+const secret = "GPS 48.8584 2.2945"
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 32), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	if err := writeJPEGSegment(&buf, marker, payload); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(jpegAPP1, append([]byte(jpegExifHeader), tiff...))
+}
+
+func secretTIFF(orientation uint16) []byte {
+	return buildTIFF(binary.LittleEndian, []tiffEntry{
+		asciiEntry(tagMake, "Acme"),
+		asciiEntry(tagModel, secret),
+		shortEntry(binary.LittleEndian, tagOrientation, orientation),
+	}, nil)
+}
+
+// jpegFixture encodes a JPEG with segments inserted after SOI and trailer
+// appended after EOI.
+func jpegFixture(t *testing.T, segments [][]byte, trailer []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	fixture := append([]byte{}, encoded[:2]...)
+	for _, segment := range segments {
+		fixture = append(fixture, segment...)
+	}
+	fixture = append(fixture, encoded[2:]...)
+	return append(fixture, trailer...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	var buf bytes.Buffer
+	if err := writePNGChunk(&buf, chunkType, data); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// pngFixture encodes a PNG with chunks inserted after IHDR.
+func pngFixture(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	// signature and the 25 bytes of IHDR
+	ihdrEnd := len(pngSignature) + 25
+	fixture := append([]byte{}, encoded[:ihdrEnd]...)
+	for _, chunk := range chunks {
+		fixture = append(fixture, chunk...)
+	}
+	return append(fixture, encoded[ihdrEnd:]...)
+}
+
+func webpChunk(chunkType string, data []byte) []byte {
+	chunk := append([]byte(chunkType), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpFixture builds an extended WebP announcing the EXIF and XMP chunks
+// it ends with.
+func webpFixture(t *testing.T, exif []byte, xmp []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// the encoder writes a simple file, its image chunk follows the header
+	encoded := buf.Bytes()
+	bounds := testImage().Bounds()
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | webpFlagXMP
+	vp8x[4], vp8x[5], vp8x[6] = byte(bounds.Dx()-1), 0, 0
+	vp8x[7], vp8x[8], vp8x[9] = byte(bounds.Dy()-1), 0, 0
+	body := []byte(webpFormType)
+	body = append(body, webpChunk(webpChunkVP8X, vp8x)...)
+	body = append(body, encoded[12:]...)
+	body = append(body, webpChunk(webpChunkExif, exif)...)
+	body = append(body, webpChunk(webpChunkXMP, xmp)...)
+	fixture := append([]byte(webpRIFF), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(fixture, body...)
+}
+
+func filter(t *testing.T, data []byte, contentType string, strip bool) ([]byte, Metadata) {
+	t.Helper()
+	var out bytes.Buffer
+	meta, err := Filter(&out, bytes.NewReader(data), contentType, strip)
+	if err != nil {
+		t.Fatalf("Filter: %v", err)
+	}
+	return out.Bytes(), meta
+}
+
+func TestFilterJPEG(t *testing.T) {
+	xmp := jpegSegment(jpegAPP1, append([]byte(jpegXMPHeader), secret...))
+	mpf := jpegSegment(jpegAPP2, append([]byte(jpegMPFHeader), "II*\x00"...))
+	comment := jpegSegment(jpegCOM, []byte(secret))
+	// phones append secondary images, each with an EXIF block of its own
+	trailer := jpegFixture(t, [][]byte{exifSegment(secretTIFF(1))}, nil)
+
+	tests := []struct {
+		name            string
+		segments        [][]byte
+		trailer         []byte
+		wantOrientation int
+	}{
+		{name: "exif", segments: [][]byte{exifSegment(secretTIFF(1))}, wantOrientation: 1},
+		{name: "rotated exif", segments: [][]byte{exifSegment(secretTIFF(6))}, wantOrientation: 6},
+		{name: "xmp and comment", segments: [][]byte{xmp, comment}, wantOrientation: 1},
+		{name: "trailing exif", segments: [][]byte{mpf}, trailer: trailer, wantOrientation: 1},
+		{name: "trailing garbage", trailer: []byte(secret), wantOrientation: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := jpegFixture(t, test.segments, test.trailer)
+
+			stripped, meta := filter(t, fixture, "image/jpeg", true)
+			if bytes.Contains(stripped, []byte(secret)) {
+				t.Error("metadata survived stripping")
+			}
+			if !bytes.HasSuffix(stripped, []byte{jpegMarkerPrefix, jpegEOI}) {
+				t.Error("stripped image does not end with EOI")
+			}
+			if !meta.Stripped || meta.Orientation != test.wantOrientation {
+				t.Errorf("got %+v, want stripped with orientation %d", meta, test.wantOrientation)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+
+			kept, meta := filter(t, fixture, "image/jpeg", false)
+			if !bytes.Equal(kept, fixture) {
+				t.Error("image changed without stripping")
+			}
+			if meta.Stripped {
+				t.Error("image reported stripped without stripping")
+			}
+		})
+	}
+}
+
+func TestFilterJPEGKeepsOrientation(t *testing.T) {
+	stripped, _ := filter(t, jpegFixture(t, [][]byte{exifSegment(secretTIFF(6))}, nil), "image/jpeg", true)
+	_, meta := filter(t, stripped, "image/jpeg", false)
+	if meta.Orientation != 6 || meta.CameraMake != "" {
+		t.Errorf("got %+v, want orientation 6 and nothing else", meta)
+	}
+}
+
+func TestFilterJPEGInvalid(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := Filter(&out, bytes.NewReader([]byte("GIF89a")), "image/jpeg", true); err == nil {
+		t.Error("got no error for a non JPEG")
+	}
+}
+
+func TestFilterPNG(t *testing.T) {
+	fixture := pngFixture(t,
+		pngChunk(pngChunkExif, secretTIFF(6)),
+		pngChunk("tEXt", append([]byte("Comment\x00"), secret...)),
+		pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), secret...)),
+	)
+
+	stripped, meta := filter(t, fixture, "image/png", true)
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Error("metadata survived stripping")
+	}
+	if !meta.Stripped || meta.Orientation != 6 || meta.CameraMake != "Acme" {
+		t.Errorf("got %+v, want stripped Acme metadata with orientation 6", meta)
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image does not decode: %v", err)
+	}
+	if _, meta := filter(t, stripped, "image/png", false); meta.Orientation != 6 {
+		t.Errorf("got orientation %d after stripping, want 6", meta.Orientation)
+	}
+
+	kept, meta := filter(t, fixture, "image/png", false)
+	if !bytes.Equal(kept, fixture) || meta.Stripped {
+		t.Error("image changed without stripping")
+	}
+}
+
+func TestFilterWebP(t *testing.T) {
+	exif := append([]byte(jpegExifHeader), secretTIFF(6)...)
+	fixture := webpFixture(t, exif, []byte(secret))
+
+	stripped, meta := filter(t, fixture, "image/webp", true)
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Error("metadata survived stripping")
+	}
+	if !meta.Stripped || meta.Orientation != 6 || meta.CameraMake != "Acme" {
+		t.Errorf("got %+v, want stripped Acme metadata with orientation 6", meta)
+	}
+	if len(stripped) != len(fixture) {
+		t.Errorf("got %d bytes, want the %d of the original", len(stripped), len(fixture))
+	}
+	if stripped[20]&webpFlagXMP != 0 {
+		t.Error("VP8X still announces XMP")
+	}
+	img, err := webp.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped image does not decode: %v", err)
+	}
+	if img.Bounds() != testImage().Bounds() {
+		t.Errorf("got bounds %v, want %v", img.Bounds(), testImage().Bounds())
+	}
+	if _, meta := filter(t, stripped, "image/webp", false); meta.Orientation != 6 || meta.CameraModel != "" {
+		t.Errorf("got %+v after stripping, want orientation 6 and nothing else", meta)
+	}
+
+	kept, meta := filter(t, fixture, "image/webp", false)
+	if !bytes.Equal(kept, fixture) || meta.Stripped {
+		t.Error("image changed without stripping")
+	}
+	if meta.Orientation != 6 || meta.CameraModel != secret {
+		t.Errorf("got %+v, want the metadata read without stripping", meta)
+	}
+}
+
+func TestFilterOtherTypes(t *testing.T) {
+	data := []byte("GIF89a" + secret)
+	got, meta := filter(t, data, "image/gif", true)
+	if !bytes.Equal(got, data) {
+		t.Error("unsupported content type was changed")
+	}
+	if meta.Stripped {
+		t.Error("unsupported content type reported stripped")
+	}
+}
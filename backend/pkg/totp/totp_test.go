@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, the ASCII string
+// "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCode checks the SHA1 vectors of RFC 6238 appendix B, which have 8
+// digits; the 6 digit codes are their last 6 digits.
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		want string
+	}{
+		{unix: 59, step: 0x1, want: "287082"},
+		{unix: 1111111109, step: 0x23523EC, want: "081804"},
+		{unix: 1111111111, step: 0x23523ED, want: "050471"},
+		{unix: 1234567890, step: 0x273EF07, want: "005924"},
+		{unix: 2000000000, step: 0x3F940AA, want: "279037"},
+		{unix: 20000000000, step: 0x27BC86AA, want: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			at := time.Unix(tt.unix, 0)
+			if step := Step(at); step != tt.step {
+				t.Errorf("step = %#x, want %#x", step, tt.step)
+			}
+			got, err := Code(rfcSecret, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("code = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeSecretForms(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, secret := range []string{rfcSecret, strings.ToLower(rfcSecret), rfcSecret + "===="} {
+		got, err := Code(secret, at)
+		if err != nil {
+			t.Fatalf("secret %q: %v", secret, err)
+		}
+		if got != "287082" {
+			t.Errorf("secret %q: code = %s, want 287082", secret, got)
+		}
+	}
+	if _, err := Code("not base32!", at); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	tests := []struct {
+		name     string
+		input    string
+		skew     int64
+		wantStep int64
+		wantOk   bool
+	}{
+		{name: "current step", input: "050471", wantStep: 0x23523ED, wantOk: true},
+		{name: "previous step without skew", input: "081804", wantOk: false},
+		{name: "previous step with skew", input: "081804", skew: 1, wantStep: 0x23523EC, wantOk: true},
+		{name: "wrong code", input: "000000", skew: 1, wantOk: false},
+		{name: "too short", input: "50471", skew: 1, wantOk: false},
+		{name: "eight digits", input: "14050471", skew: 1, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.input, at, tt.skew)
+			if ok != tt.wantOk || step != tt.wantStep {
+				t.Fatalf("Validate = %#x, %v, want %#x, %v", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != SecretSize {
+		t.Fatalf("secret is %d bytes, want %d", len(key), SecretSize)
+	}
+}